@@ -1,9 +1,134 @@
+// Command timetracker serves the time tracker API and manages the schema of
+// its database.
+//
+// Usage:
+//
+//	timetracker [serve] [-skip-migrate] [-storage postgres|sqlite|memory]
+//	timetracker migrate [-storage postgres|sqlite] up|down N|goto V|version|force V|create NAME
 package main
 
-import "timeTracker/internal/app"
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"timeTracker/internal/app"
+)
+
+const usage = `Usage:
+  timetracker [serve] [-skip-migrate]   apply pending migrations, then serve the API
+  timetracker [serve] -storage sqlite   keep data in the SQLite file at SQLITE_PATH instead of Postgres
+  timetracker [serve] -storage memory   serve a demo that keeps seeded data in memory
+  timetracker migrate up                apply all pending migrations
+  timetracker migrate down N            roll back the last N migrations
+  timetracker migrate goto V            migrate up or down to version V
+  timetracker migrate version           print the current version
+  timetracker migrate force V           set the version after fixing a failed migration
+  timetracker migrate create NAME       add empty up and down migrations
+  timetracker migrate -storage sqlite   manage the SQLite database and migrations instead
+`
 
 func main() {
-	a := app.NewApp()
-	a.Migrate()
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+
+	// Flags without a command are those of serve.
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+	case "migrate":
+		if err := migrate(args); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = flag.Usage
+	skipMigrate := flags.Bool("skip-migrate", false, "do not apply pending migrations on startup")
+	storage := flags.String("storage", "", "storage backend, postgres, sqlite or memory, overriding STORAGE")
+	flags.Parse(args)
+
+	a := app.NewApp(*storage)
+	if !*skipMigrate {
+		a.Migrate()
+	}
 	a.ListenAndServe()
-}
\ No newline at end of file
+}
+
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = flag.Usage
+	storage := flags.String("storage", "", "storage backend, postgres or sqlite, overriding STORAGE")
+	flags.Parse(args)
+
+	args = flags.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, args := args[0], args[1:]
+
+	wantArgs := map[string]int{"up": 0, "down": 1, "goto": 1, "version": 0, "force": 1, "create": 1}
+	n, ok := wantArgs[command]
+	if !ok || len(args) != n {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if command == "create" {
+		paths, err := app.CreateMigration(args[0], *storage)
+		for _, path := range paths {
+			log.Printf("Created %s", path)
+		}
+		return err
+	}
+
+	m, err := app.NewMigrator(*storage)
+	if err != nil {
+		return fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+	defer m.Close()
+
+	switch command {
+	case "up":
+		err = m.Up()
+	case "down":
+		var steps int
+		if steps, err = strconv.Atoi(args[0]); err == nil {
+			err = m.Down(steps)
+		}
+	case "goto":
+		var version uint64
+		if version, err = strconv.ParseUint(args[0], 10, 0); err == nil {
+			err = m.Goto(uint(version))
+		}
+	case "force":
+		var version int
+		if version, err = strconv.Atoi(args[0]); err == nil {
+			err = m.Force(version)
+		}
+	case "version":
+		var version uint
+		var dirty bool
+		if version, dirty, err = m.Version(); err == nil {
+			if dirty {
+				fmt.Printf("%d (dirty)\n", version)
+			} else {
+				fmt.Println(version)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+// Command encrypt applies pending migrations, then encrypts personal data
+// stored in plaintext and re-encrypts data still under a retired key. Run it
+// after enabling encryption and after every key rotation.
+package main
+
+import "timeTracker/internal/app"
+
+func main() {
+	a := app.NewApp("")
+	a.Migrate()
+	a.EncryptPersonalData()
+}
@@ -1,66 +1,501 @@
 package app
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+	"timeTracker/internal/auth"
 	"timeTracker/internal/config"
 	"timeTracker/internal/controllers"
+	"timeTracker/internal/fieldcrypt"
+	"timeTracker/internal/health"
+	"timeTracker/internal/metrics"
+	"timeTracker/internal/pii"
+	"timeTracker/internal/ratelimit"
 	"timeTracker/internal/repository"
 	"timeTracker/internal/service"
+	"timeTracker/internal/tracing"
+)
+
+const (
+	defaultDBConnectTimeout  = time.Minute
+	defaultDBReadTimeout     = 5 * time.Second
+	defaultDBWriteTimeout    = 10 * time.Second
+	defaultDBBulkTimeout     = time.Minute
+	defaultEnrichmentTimeout = 5 * time.Second
+	enrichmentCheckInterval  = 30 * time.Second
+	defaultUserPurgeInterval = time.Hour
 
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	defaultAdminPort         = "9090"
+	defaultReadTimeout       = 15 * time.Second
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultMaxHeaderBytes    = 64 << 10
+	defaultMaxBodyBytes      = 1 << 20
+	defaultShutdownTimeout   = 30 * time.Second
+
+	idempotencyPurgeInterval = time.Hour
+	rateLimitPurgeInterval   = time.Hour
+	encryptionBatchSize      = 500
 )
 
 type app struct {
-	cfg     *config.Config
-	handler *controllers.Handler
+	cfg                *config.Config
+	handler            *controllers.Handler
+	userService        *service.UserService
+	idempotencyService *service.IdempotencyService
+	repo               repository.Repository
+	checker            *health.Checker
+	shutdownTracing    func(context.Context) error
 }
 
-func NewApp() *app {
+// loadConfig loads the config from the working directory. A non-empty
+// storage overrides the configured storage backend.
+func loadConfig(storage string) config.Config {
 	dir, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
 	}
-	config := config.MustLoad(dir)
-	userService := service.NewUserService(repository.NewRepository(config.PostgresHost,
-		config.PostgresPort,
-		config.PostgresUser, config.PostgresPassword, config.PostgresDBName), config.GetByPassportDomain)
-	handler := controllers.NewHandler(userService, slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
-	})))
-
-	return &app{cfg: &config, handler: handler}
+	cfg := config.MustLoad(dir)
+	if storage != "" {
+		cfg.Storage = storage
+	}
+	return cfg
 }
 
-// TODO: add path to migrations to config
-func (a *app) Migrate() {
-	path, err := filepath.Abs("./migrations")
+// NewApp wires the application up from the config. A non-empty storage
+// overrides the configured storage backend.
+func NewApp(storage string) *app {
+	config := loadConfig(storage)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    config.TracingExporter,
+		Endpoint:    config.TracingOTLPEndpoint,
+		Insecure:    config.TracingOTLPInsecure,
+		SampleRatio: config.TracingSampleRatio,
+	})
 	if err != nil {
-		log.Fatalf("Failed to get absolute path: %v", err)
+		log.Fatalf("Failed to set up tracing: %v", err)
 	}
-	sourceURL := fmt.Sprintf("file://%s", filepath.ToSlash(path))
-	log.Printf("Using migrations from: %s", sourceURL)
-
-	m, err := migrate.New(sourceURL, fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		a.cfg.PostgresUser, a.cfg.PostgresPassword, a.cfg.PostgresHost,
-		a.cfg.PostgresPort, a.cfg.PostgresDBName))
+	repo, err := newRepository(&config)
 	if err != nil {
-		log.Fatalf("Failed to create migrate instance: %v", err)
+		log.Fatalf("Failed to open storage: %v", err)
 	}
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		log.Fatalf("Failed to apply migrations: %v", err)
+	verifier, err := auth.NewJWTVerifier(config.JWTHMACSecret, config.JWTRSAPublicKeyPath,
+		config.JWTIssuer, config.JWTAudience)
+	if err != nil {
+		log.Fatal(err)
+	}
+	userService := service.NewUserService(repo, config.GetByPassportDomain,
+		orDefault(config.EnrichmentTimeout, defaultEnrichmentTimeout))
+	authService := service.NewAuthService(repo, verifier)
+	auditService := service.NewAuditService(repo)
+	idempotencyService := service.NewIdempotencyService(repo, config.IdempotencyKeyTTL)
+	limiter, err := newLimiter(&config, repo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler := controllers.NewHandler(userService, authService, auditService, idempotencyService, limiter,
+		bulkWriteTimeout(&config), slog.New(pii.NewRedactingHandler(
+			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+				Level: slog.LevelDebug,
+			}))))
+
+	if config.Storage == storageMemory {
+		if err := seedDemo(context.Background(), repo, authService); err != nil {
+			log.Fatalf("Failed to seed demo data: %v", err)
+		}
 	}
-	log.Println("Migrations applied successfully")
+
+	if pool, ok := repo.(interface{ DB() *sql.DB }); ok {
+		name := config.PostgresDBName
+		if config.Storage == storageSQLite {
+			name = sqlitePath(&config)
+		}
+		metrics.RegisterDB(pool.DB(), name)
+	}
+	metrics.RegisterTimerStats(userService.TimerStats)
+
+	a := &app{cfg: &config, handler: handler, userService: userService, idempotencyService: idempotencyService,
+		repo: repo, shutdownTracing: shutdownTracing}
+	if a.checker, err = a.newChecker(); err != nil {
+		log.Fatalf("Failed to set up readiness checks: %v", err)
+	}
+	return a
 }
+
+// ListenAndServe serves the API until SIGINT or SIGTERM, then stops the
+// background jobs, waits for in-flight requests to finish and releases the
+// database and the tracer.
 func (a *app) ListenAndServe() {
-	log.Printf("Starting server on port %s", a.cfg.AppPort)
-	if err := http.ListenAndServe(":"+a.cfg.AppPort, a.handler.Router()); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var jobs sync.WaitGroup
+	for _, job := range []func(ctx context.Context){
+		a.purgeDeletedUsers,
+		a.purgeIdempotencyKeys,
+		a.purgeRateLimitBuckets,
+	} {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job(ctx)
+		}()
+	}
+
+	server := &http.Server{
+		Addr:              ":" + a.cfg.AppPort,
+		Handler:           http.MaxBytesHandler(a.handler.Router(), orDefault(a.cfg.HTTPMaxBodyBytes, defaultMaxBodyBytes)),
+		ReadTimeout:       orDefault(a.cfg.HTTPReadTimeout, defaultReadTimeout),
+		ReadHeaderTimeout: orDefault(a.cfg.HTTPReadHeaderTimeout, defaultReadHeaderTimeout),
+		WriteTimeout:      orDefault(a.cfg.HTTPWriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       orDefault(a.cfg.HTTPIdleTimeout, defaultIdleTimeout),
+		MaxHeaderBytes:    orDefault(a.cfg.HTTPMaxHeaderBytes, defaultMaxHeaderBytes),
+	}
+
+	// Metrics and probes are served on a port of their own, to be reached
+	// by the orchestrator and the metrics scraper only, so that they need
+	// no credentials yet reveal nothing to API clients.
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", health.LiveHandler())
+	mux.Handle("/readyz", a.checker.ReadyHandler())
+	adminPort := orDefault(a.cfg.AdminPort, defaultAdminPort)
+	admin := &http.Server{
+		Addr:              ":" + adminPort,
+		Handler:           mux,
+		ReadHeaderTimeout: orDefault(a.cfg.HTTPReadHeaderTimeout, defaultReadHeaderTimeout),
+		WriteTimeout:      orDefault(a.cfg.HTTPWriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       orDefault(a.cfg.HTTPIdleTimeout, defaultIdleTimeout),
+	}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		log.Printf("Starting server on port %s", a.cfg.AppPort)
+		serveErr <- server.ListenAndServe()
+	}()
+	go func() {
+		log.Printf("Serving metrics and probes on port %s", adminPort)
+		serveErr <- admin.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		a.close(context.Background())
 		log.Fatalf("Failed to start server: %v", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process without waiting.
+	stop()
+
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), orDefault(a.cfg.ShutdownTimeout, defaultShutdownTimeout))
+	defer cancel()
+	a.checker.Drain()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to drain requests: %v", err)
+	}
+	// Probes are answered until the API has drained.
+	if err := admin.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to stop the admin server: %v", err)
+	}
+	jobs.Wait()
+	a.close(shutdownCtx)
+	log.Println("Server stopped")
+}
+
+// close releases the database connections and flushes pending spans.
+func (a *app) close(ctx context.Context) {
+	if closer, ok := a.repo.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Failed to close database: %v", err)
+		}
+	}
+	if err := a.shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+}
+
+// purgeDeletedUsers periodically removes users that were soft-deleted longer
+// than the configured retention period ago.
+func (a *app) purgeDeletedUsers(ctx context.Context) {
+	if a.cfg.UserRetentionPeriod <= 0 {
+		return
+	}
+	interval := a.cfg.UserPurgeInterval
+	if interval <= 0 {
+		interval = defaultUserPurgeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := a.userService.PurgeDeletedUsers(ctx, a.cfg.UserRetentionPeriod)
+		if err != nil {
+			log.Printf("Failed to purge deleted users: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted users", purged)
+		}
+		if !tick(ctx, ticker) {
+			return
+		}
+	}
+}
+
+// purgeIdempotencyKeys periodically removes stored responses whose TTL has
+// passed.
+func (a *app) purgeIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+	for tick(ctx, ticker) {
+		purged, err := a.idempotencyService.PurgeExpired(ctx)
+		if err != nil {
+			log.Printf("Failed to purge idempotency keys: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d idempotency keys", purged)
+		}
+	}
+}
+
+const (
+	storagePostgres = "postgres"
+	storageSQLite   = "sqlite"
+	storageMemory   = "memory"
+
+	defaultSQLitePath = "timetracker.db"
+)
+
+// newRepository opens the storage backend selected by the config.
+func newRepository(cfg *config.Config) (repository.Repository, error) {
+	timeouts := repository.Timeouts{
+		Connect: orDefault(cfg.DBConnectTimeout, defaultDBConnectTimeout),
+		Read:    orDefault(cfg.DBReadTimeout, defaultDBReadTimeout),
+		Write:   orDefault(cfg.DBWriteTimeout, defaultDBWriteTimeout),
+		Bulk:    orDefault(cfg.DBBulkTimeout, defaultDBBulkTimeout),
+	}
+	switch cfg.Storage {
+	case "", storagePostgres:
+		keys, err := newKeyring(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewRepository(cfg.PostgresHost,
+			cfg.PostgresPort,
+			cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresDBName, keys, timeouts)
+	case storageSQLite:
+		keys, err := newKeyring(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewSQLiteRepo(sqlitePath(cfg), keys, timeouts)
+	case storageMemory:
+		log.Println("Keeping data in memory; it is lost when the server stops")
+		return repository.NewMemoryRepo(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage)
+	}
+}
+
+// newKeyring loads the keys encrypting personal data, naming the setting
+// that is missing rather than failing to parse an empty one.
+func newKeyring(cfg *config.Config) (*fieldcrypt.Keyring, error) {
+	for _, setting := range []struct{ name, value string }{
+		{"ENCRYPTION_KEYS", cfg.EncryptionKeys},
+		{"ENCRYPTION_ACTIVE_KEY_ID", cfg.EncryptionActiveKeyID},
+		{"BLIND_INDEX_KEY", cfg.BlindIndexKey},
+	} {
+		if setting.value == "" {
+			return nil, fmt.Errorf("%s is not set; it is needed to encrypt personal data unless storage is memory",
+				setting.name)
+		}
+	}
+
+	keys, err := fieldcrypt.NewKeyring(cfg.EncryptionKeys, cfg.EncryptionActiveKeyID, cfg.BlindIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading encryption keys: %w", err)
+	}
+	return keys, nil
+}
+
+// newLimiter builds the rate limiter selected by the config, or returns nil
+// when rate limiting is off.
+func newLimiter(cfg *config.Config, repo repository.Repository) (*ratelimit.Limiter, error) {
+	var store ratelimit.Store
+	switch cfg.RateLimitStore {
+	case "", "memory":
+		store = ratelimit.NewMemoryStore()
+	case "postgres":
+		buckets, ok := repo.(repository.RateLimitRepository)
+		if !ok {
+			return nil, fmt.Errorf("storage backend cannot keep rate limits")
+		}
+		store = ratelimit.StoreFunc(buckets.TakeRateLimitToken)
+	case "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", cfg.RateLimitStore)
+	}
+
+	return ratelimit.NewLimiter(store, map[ratelimit.Class]ratelimit.Limit{
+		ratelimit.ClassAddress: ratelimit.PerMinute(orDefault(cfg.RateLimitAddressPerMinute, 600),
+			orDefault(cfg.RateLimitAddressBurst, 120)),
+		ratelimit.ClassRead: ratelimit.PerMinute(orDefault(cfg.RateLimitReadsPerMinute, 300),
+			orDefault(cfg.RateLimitReadBurst, 60)),
+		ratelimit.ClassWrite: ratelimit.PerMinute(orDefault(cfg.RateLimitWritesPerMinute, 60),
+			orDefault(cfg.RateLimitWriteBurst, 20)),
+		ratelimit.ClassExport: ratelimit.PerMinute(orDefault(cfg.RateLimitExportsPerMinute, 2),
+			orDefault(cfg.RateLimitExportBurst, 2)),
+	}), nil
+}
+
+func sqlitePath(cfg *config.Config) string {
+	if cfg.SQLitePath == "" {
+		return defaultSQLitePath
+	}
+	return cfg.SQLitePath
+}
+
+// bulkWriteTimeout is the write timeout of responses waiting on bulk
+// database operations: the bulk timeout plus the usual write timeout to send
+// the result, or none if the bulk timeout is lifted.
+func bulkWriteTimeout(cfg *config.Config) time.Duration {
+	bulk := orDefault(cfg.DBBulkTimeout, defaultDBBulkTimeout)
+	if bulk < 0 {
+		return -1
+	}
+	return bulk + orDefault(cfg.HTTPWriteTimeout, defaultWriteTimeout)
+}
+
+func orDefault[T ~int | ~int64 | ~string](value, fallback T) T {
+	var zero T
+	if value == zero {
+		return fallback
+	}
+	return value
+}
+
+// newChecker sets up the readiness checks: the database must answer and be
+// migrated to the latest migration shipped with the application. The
+// enrichment API is checked too, but being needed only to add users it
+// degrades readiness rather than taking every instance out of rotation.
+func (a *app) newChecker() (*health.Checker, error) {
+	checker := health.NewChecker()
+	checker.Add("enrichment", false, health.Cached(a.userService.CheckEnrichment, enrichmentCheckInterval))
+
+	schema, ok := a.repo.(repository.SchemaRepository)
+	if !ok {
+		return checker, nil
+	}
+	expected, err := latestMigration(a.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	checker.Add("database", true, schema.Ping)
+	if indexer, ok := a.repo.(blindIndexer); ok {
+		checker.Add("blind_index", true, func(ctx context.Context) error {
+			unindexed, err := indexer.UnindexedUsers(ctx)
+			if err == nil && unindexed > 0 {
+				err = fmt.Errorf("%d users have no blind indexes until personal data is encrypted", unindexed)
+			}
+			return err
+		})
+	}
+	checker.Add("migrations", true, func(ctx context.Context) error {
+		version, dirty, err := schema.SchemaVersion(ctx)
+		switch {
+		case err != nil:
+			return err
+		case dirty:
+			return fmt.Errorf("migration %d failed and must be fixed", version)
+		case version != expected:
+			return fmt.Errorf("schema is at version %d, expected %d", version, expected)
+		}
+		return nil
+	})
+
+	return checker, nil
+}
+
+// purgeRateLimitBuckets periodically removes buckets kept in Postgres that
+// have not been used for a day, long after they filled up again.
+func (a *app) purgeRateLimitBuckets(ctx context.Context) {
+	buckets, ok := a.repo.(repository.RateLimitRepository)
+	if !ok || a.cfg.RateLimitStore != "postgres" {
+		return
+	}
+
+	ticker := time.NewTicker(rateLimitPurgeInterval)
+	defer ticker.Stop()
+	for tick(ctx, ticker) {
+		purged, err := buckets.PurgeRateLimitBuckets(ctx, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			log.Printf("Failed to purge rate limit buckets: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d rate limit buckets", purged)
+		}
+	}
+}
+
+// tick waits for the next tick, reporting false once ctx is done.
+func tick(ctx context.Context, ticker *time.Ticker) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-ticker.C:
+		return true
+	}
+}
+
+// blindIndexer is implemented by backends that may hold users stored before
+// passport numbers were encrypted and indexed or addresses were tokenised
+// for search.
+type blindIndexer interface {
+	UnindexedUsers(ctx context.Context) (int, error)
+	EncryptPersonalData(ctx context.Context, batchSize int) (int, error)
+}
+
+// indexPersonalData encrypts and indexes the users left without blind
+// indexes by the migrations that introduced them, so that lookups, the
+// uniqueness check and address search cover them.
+func (a *app) indexPersonalData() {
+	indexer, ok := a.repo.(blindIndexer)
+	if !ok {
+		return
+	}
+	unindexed, err := indexer.UnindexedUsers(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to count unindexed users: %v", err)
+	}
+	if unindexed == 0 {
+		return
+	}
+
+	log.Printf("Encrypting and indexing %d users stored before their blind indexes", unindexed)
+	a.EncryptPersonalData()
+}
+
+// EncryptPersonalData encrypts personal data stored before encryption was
+// enabled and re-encrypts values still under a retired key.
+func (a *app) EncryptPersonalData() {
+	encrypter, ok := a.repo.(blindIndexer)
+	if !ok {
+		log.Fatal("Storage backend does not encrypt personal data")
+	}
+
+	rewritten, err := encrypter.EncryptPersonalData(context.Background(), encryptionBatchSize)
+	if err != nil {
+		log.Fatalf("Failed to encrypt personal data after %d rows: %v", rewritten, err)
 	}
+	log.Printf("Encrypted personal data in %d rows", rewritten)
 }
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"timeTracker/internal/models"
+	"timeTracker/internal/repository"
+	"timeTracker/internal/service"
+)
+
+// demoActor marks the changes made while seeding in the audit log.
+var demoActor = models.Actor{RequestID: "demo-seed"}
+
+// demoUsers are seeded into the default organization, each with a few tasks
+// to track time on. The first one is the admin the demo API key belongs to.
+var demoUsers = []struct {
+	user  models.User
+	tasks []string
+}{
+	{
+		user: models.User{PassportNumber: "1000 000001", Surname: "Admin", Name: "Demo", Address: "1 Demo St",
+			Role: models.RoleAdmin, Privileges: []string{models.PrivilegeViewPersonalData}},
+	},
+	{
+		user: models.User{PassportNumber: "1000 000002", Surname: "Ivanova", Name: "Anna", Patronymic: "Sergeevna",
+			Address: "2 Demo St", Role: models.RoleManager, Team: "backend"},
+		tasks: []string{"Sprint planning", "Code review"},
+	},
+	{
+		user: models.User{PassportNumber: "1000 000003", Surname: "Petrov", Name: "Ivan", Patronymic: "Olegovich",
+			Address: "3 Demo St", Role: models.RoleEmployee, Team: "backend"},
+		tasks: []string{"Payment API", "Fix flaky tests"},
+	},
+	{
+		user: models.User{PassportNumber: "1000 000004", Surname: "Smirnova", Name: "Olga",
+			Address: "4 Demo St", Role: models.RoleEmployee, Team: "frontend"},
+		tasks: []string{"Dashboard redesign"},
+	},
+}
+
+// seedDemo fills an empty in-memory repository with sample users and tasks
+// and logs an API key of the demo admin to try the API with.
+func seedDemo(ctx context.Context, repo repository.Repository, authService *service.AuthService) error {
+	tasks, ok := repo.(interface {
+		AddTask(ctx context.Context, orgID, userID int, description string) (models.Task, error)
+	})
+	if !ok {
+		return errors.New("storage backend cannot create tasks")
+	}
+
+	const orgID = 1
+	var adminID int
+	for _, demo := range demoUsers {
+		user, err := repo.AddUser(ctx, orgID, demoActor, demo.user)
+		if err != nil {
+			return err
+		}
+		if adminID == 0 {
+			adminID = user.ID
+		}
+		for _, description := range demo.tasks {
+			if _, err := tasks.AddTask(ctx, orgID, user.ID, description); err != nil {
+				return err
+			}
+		}
+	}
+
+	key, err := authService.IssueAPIKey(ctx, orgID, demoActor, "demo", strconv.Itoa(adminID))
+	if err != nil {
+		return err
+	}
+	log.Printf("Seeded %d demo users; authenticate as the demo admin with: Authorization: Bearer %s", len(demoUsers), key.Key)
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"timeTracker/internal/config"
+	"timeTracker/internal/repository"
+	"timeTracker/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// defaultMigrationsPath is where new migrations are created unless the
+// config names another directory; those of SQLite go to the sqlite
+// subdirectory of either.
+const defaultMigrationsPath = "migrations"
+
+// Migrate applies all pending migrations. Backends without a schema, such
+// as the in-memory one, are left alone.
+func (a *app) Migrate() {
+	if _, ok := a.repo.(repository.SchemaRepository); !ok {
+		return
+	}
+
+	m, err := newMigrator(a.cfg)
+	if err != nil {
+		log.Fatalf("Failed to create migrate instance: %v", err)
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil {
+		log.Fatalf("Failed to apply migrations: %v", err)
+	}
+	log.Println("Migrations applied successfully")
+	a.indexPersonalData()
+}
+
+// Migrator changes and inspects the schema version of the configured
+// database.
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+// NewMigrator opens the configured database. A non-empty storage overrides
+// the configured storage backend.
+func NewMigrator(storage string) (*Migrator, error) {
+	cfg := loadConfig(storage)
+	return newMigrator(&cfg)
+}
+
+func newMigrator(cfg *config.Config) (*Migrator, error) {
+	src, err := migrationSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MigrationsPath != "" {
+		log.Printf("Using migrations from: %s", cfg.MigrationsPath)
+	}
+
+	url := postgresURL(cfg)
+	if cfg.Storage == storageSQLite {
+		url = "sqlite://" + repository.SQLiteDSN(sqlitePath(cfg))
+	}
+	m, err := migrate.NewWithSourceInstance("migrations", src, url)
+	if err != nil {
+		return nil, err
+	}
+	m.Log = migrateLogger{}
+	return &Migrator{m: m}, nil
+}
+
+// migrationSource returns the migrations of the configured storage backend,
+// read from MigrationsPath if it is set.
+func migrationSource(cfg *config.Config) (source.Driver, error) {
+	if cfg.Storage == storageSQLite {
+		return migrations.SQLiteSource(cfg.MigrationsPath)
+	}
+	return migrations.Source(cfg.MigrationsPath)
+}
+
+func postgresURL(cfg *config.Config) string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresHost,
+		cfg.PostgresPort, cfg.PostgresDBName)
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up() error {
+	return ignoreNoChange(m.m.Up())
+}
+
+// Down rolls back the last n applied migrations.
+func (m *Migrator) Down(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("number of migrations to roll back must be positive, got %d", n)
+	}
+	return ignoreNoChange(m.m.Steps(-n))
+}
+
+// Goto migrates up or down to the given version.
+func (m *Migrator) Goto(version uint) error {
+	return ignoreNoChange(m.m.Migrate(version))
+}
+
+// Version returns the current version and whether its migration failed
+// halfway. A database without migrations is at version zero.
+func (m *Migrator) Version() (uint, bool, error) {
+	version, dirty, err := m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Force sets the version without running migrations, clearing the dirty
+// flag after a failed migration has been fixed by hand. A version of -1
+// means no migration has been applied.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+func (m *Migrator) Close() {
+	if srcErr, dbErr := m.m.Close(); srcErr != nil || dbErr != nil {
+		log.Printf("Failed to close migrate instance: %v", errors.Join(srcErr, dbErr))
+	}
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, v ...interface{}) {
+	log.Printf(strings.TrimSuffix(format, "\n"), v...)
+}
+
+func (migrateLogger) Verbose() bool {
+	return false
+}
+
+var (
+	migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+	migrationFile = regexp.MustCompile(`^(\d+)_.*\.(up|down)\.sql$`)
+)
+
+// CreateMigration adds empty up and down migrations with the given name,
+// numbered after the last migration in the migrations directory of the
+// storage backend, and returns their paths. A non-empty storage overrides
+// the configured one. The migrations are built into the binary on the next
+// build.
+func CreateMigration(name, storage string) ([]string, error) {
+	if !migrationName.MatchString(name) {
+		return nil, fmt.Errorf("migration name %q must consist of lowercase letters, digits and underscores", name)
+	}
+	cfg := loadConfig(storage)
+	dir := cfg.MigrationsPath
+	if dir == "" {
+		dir = defaultMigrationsPath
+	}
+	if cfg.Storage == storageSQLite {
+		dir = filepath.Join(dir, storageSQLite)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var last uint64
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration %s: %w", entry.Name(), err)
+		}
+		last = max(last, version)
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%06d_%s.%s.sql", last+1, name, direction))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			return paths, err
+		}
+		file.Close()
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// latestMigration returns the version of the last migration of the
+// configured storage backend.
+func latestMigration(cfg *config.Config) (uint, error) {
+	src, err := migrationSource(cfg)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open migrations: %w", err)
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read migrations: %w", err)
+		}
+		version = next
+	}
+}
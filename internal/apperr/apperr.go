@@ -0,0 +1,106 @@
+// Package apperr defines the domain errors shared by the repository and
+// service layers, so that callers can tell a missing user from a conflicting
+// write or a failing upstream without matching on error strings.
+package apperr
+
+import "errors"
+
+// Kind classifies a domain error.
+type Kind string
+
+const (
+	// KindInvalid means the request could not be interpreted, such as an
+	// unsupported filter.
+	KindInvalid Kind = "invalid"
+	// KindNotFound means the entity does not exist or is not visible.
+	KindNotFound Kind = "not_found"
+	// KindConflict means the request clashes with the current state, such as
+	// a duplicate passport number or an already running task.
+	KindConflict Kind = "conflict"
+	// KindValidation means the request was understood but its values are not
+	// acceptable.
+	KindValidation Kind = "validation"
+	// KindPrecondition means the entity changed since the version the
+	// request was based on.
+	KindPrecondition Kind = "precondition"
+	// KindUpstream means a dependency outside the database failed.
+	KindUpstream Kind = "upstream"
+)
+
+// FieldError describes a single invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field" example:"passportNumber"`
+	Code    string `json:"code" example:"invalid_format"`
+	Message string `json:"message" example:"must look like 1234 567890"`
+}
+
+// Error is a domain error with a machine-readable code.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Invalid returns an error for a request that could not be interpreted.
+func Invalid(code, message string) *Error {
+	return &Error{Kind: KindInvalid, Code: code, Message: message}
+}
+
+// NotFound returns an error for a missing entity.
+func NotFound(code, message string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Message: message}
+}
+
+// Conflict returns an error for a request that clashes with the stored state.
+func Conflict(code, message string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Message: message}
+}
+
+// PreconditionFailed returns an error for a write based on a stale version.
+func PreconditionFailed(code, message string) *Error {
+	return &Error{Kind: KindPrecondition, Code: code, Message: message}
+}
+
+// Validation returns an error listing every invalid field.
+func Validation(message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Code: "validation_failed", Message: message, Fields: fields}
+}
+
+// Upstream returns an error for a failed call to an external dependency.
+func Upstream(code, message string, err error) *Error {
+	return &Error{Kind: KindUpstream, Code: code, Message: message, Err: err}
+}
+
+// Wrap attaches the underlying cause to e and returns it.
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// As returns the domain error in err's chain, if any.
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// Is reports whether err's chain contains a domain error of the given kind.
+func Is(err error, kind Kind) bool {
+	e, ok := As(err)
+	return ok && e.Kind == kind
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	APIKeyPrefix = "tt_"
+	// APIKeyDisplayLength is how much of the key is kept in clear so that
+	// operators can tell keys apart without storing the secret itself.
+	APIKeyDisplayLength = 11
+	// apiKeyBytes is the number of random bytes encoded in a key.
+	apiKeyBytes = 32
+)
+
+func GenerateAPIKey() (string, error) {
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashAPIKey returns the value persisted for a key. API keys carry 256 bits
+// of entropy, so a plain SHA-256 is sufficient and keeps lookups indexable.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// ParseAPIKey checks that key has the form GenerateAPIKey gives keys, so
+// that malformed keys are rejected without being looked up.
+func ParseAPIKey(key string) error {
+	secret, ok := strings.CutPrefix(key, APIKeyPrefix)
+	if !ok {
+		return fmt.Errorf("%w: api key lacks the %s prefix", ErrUnauthenticated, APIKeyPrefix)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(secret)
+	if err != nil || len(b) != apiKeyBytes {
+		return fmt.Errorf("%w: malformed api key", ErrUnauthenticated)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(key) || ParseAPIKey(key) != nil {
+		t.Errorf("generated key %q does not parse", key)
+	}
+	other, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == other {
+		t.Error("two generated keys are equal")
+	}
+
+	hash := HashAPIKey(key)
+	if hash != HashAPIKey(key) || hash == HashAPIKey(other) || len(hash) != 64 || strings.Contains(hash, key) {
+		t.Errorf("hash %q of %q is not a stable SHA-256", hash, key)
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	valid := APIKeyPrefix + strings.Repeat("A", 43)
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"wrong prefix", "xx_" + strings.Repeat("A", 43), true},
+		{"no prefix", strings.Repeat("A", 43), true},
+		{"bad base64", APIKeyPrefix + strings.Repeat("!", 43), true},
+		{"padded base64", APIKeyPrefix + strings.Repeat("A", 42) + "=", true},
+		{"too short", APIKeyPrefix + strings.Repeat("A", 42), true},
+		{"too long", valid + "AAAA", true},
+		{"empty", APIKeyPrefix, true},
+	}
+	for _, tt := range tests {
+		err := ParseAPIKey(tt.key)
+		if tt.wantErr != (err != nil) || (err != nil && !errors.Is(err, ErrUnauthenticated)) {
+			t.Errorf("%s: ParseAPIKey(%q) = %v", tt.name, tt.key, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"timeTracker/internal/models"
+)
+
+type Method string
+
+const (
+	MethodJWT    Method = "jwt"
+	MethodAPIKey Method = "api_key"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Principal is the authenticated caller of a request. Subject is the user ID
+// the credential was issued to; the remaining fields are resolved from it.
+type Principal struct {
+	Subject        string
+	Method         Method
+	APIKeyID       int
+	UserID         int
+	OrganizationID int
+	Role           models.Role
+	Team           string
+	Privileges     []string
+}
+
+func (p Principal) HasPrivilege(privilege string) bool {
+	for _, granted := range p.Privileges {
+		if granted == privilege {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
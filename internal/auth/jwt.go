@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTVerifier validates bearer tokens signed either with a shared HMAC
+// secret or with an RSA private key whose public half is configured.
+type JWTVerifier struct {
+	hmacSecret []byte
+	rsaKey     *rsa.PublicKey
+	issuer     string
+	audience   string
+}
+
+func NewJWTVerifier(hmacSecret, rsaPublicKeyPath, issuer, audience string) (*JWTVerifier, error) {
+	v := &JWTVerifier{issuer: issuer, audience: audience}
+	if hmacSecret != "" {
+		v.hmacSecret = []byte(hmacSecret)
+	}
+	if rsaPublicKeyPath != "" {
+		pem, err := os.ReadFile(rsaPublicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading RSA public key: %w", err)
+		}
+		v.rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing RSA public key: %w", err)
+		}
+	}
+
+	return v, nil
+}
+
+func (v *JWTVerifier) Verify(tokenString string) (Principal, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512"}),
+		jwt.WithExpirationRequired(),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, v.key, opts...)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+
+	subject, err := token.Claims.GetSubject()
+	if err != nil || subject == "" {
+		return Principal{}, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+
+	return Principal{Subject: subject, Method: MethodJWT}, nil
+}
+
+func (v *JWTVerifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if v.hmacSecret == nil {
+			return nil, errors.New("HMAC signed tokens are not accepted")
+		}
+		return v.hmacSecret, nil
+	case *jwt.SigningMethodRSA:
+		if v.rsaKey == nil {
+			return nil, errors.New("RSA signed tokens are not accepted")
+		}
+		return v.rsaKey, nil
+	}
+
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	publicKeyPath := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(publicKeyPath, publicKey, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	hmacVerifier, err := NewJWTVerifier("secret", "", "issuer", "audience")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaVerifier, err := NewJWTVerifier("", publicKeyPath, "issuer", "audience")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Subject:   "7",
+			Issuer:    "issuer",
+			Audience:  jwt.ClaimStrings{"audience"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, change func(*jwt.RegisteredClaims)) string {
+		claims := valid()
+		if change != nil {
+			change(&claims)
+		}
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name     string
+		verifier *JWTVerifier
+		token    string
+		wantErr  bool
+	}{
+		{"hmac", hmacVerifier, sign(jwt.SigningMethodHS256, []byte("secret"), nil), false},
+		{"rsa", rsaVerifier, sign(jwt.SigningMethodRS256, rsaKey, nil), false},
+		{"alg none", hmacVerifier, sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, nil), true},
+		{"hmac token for rsa key", rsaVerifier, sign(jwt.SigningMethodHS256, []byte("secret"), nil), true},
+		{"hmac token signed with the rsa public key", rsaVerifier, sign(jwt.SigningMethodHS256, publicKey, nil), true},
+		{"rsa token for hmac secret", hmacVerifier, sign(jwt.SigningMethodRS256, rsaKey, nil), true},
+		{"bad signature", hmacVerifier, sign(jwt.SigningMethodHS256, []byte("guess"), nil), true},
+		{"no expiry", hmacVerifier, sign(jwt.SigningMethodHS256, []byte("secret"), func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = nil
+		}), true},
+		{"expired", hmacVerifier, sign(jwt.SigningMethodHS256, []byte("secret"), func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		}), true},
+		{"other issuer", hmacVerifier, sign(jwt.SigningMethodHS256, []byte("secret"), func(c *jwt.RegisteredClaims) {
+			c.Issuer = "elsewhere"
+		}), true},
+		{"other audience", hmacVerifier, sign(jwt.SigningMethodHS256, []byte("secret"), func(c *jwt.RegisteredClaims) {
+			c.Audience = jwt.ClaimStrings{"another"}
+		}), true},
+		{"no subject", hmacVerifier, sign(jwt.SigningMethodHS256, []byte("secret"), func(c *jwt.RegisteredClaims) {
+			c.Subject = ""
+		}), true},
+		{"not a token", hmacVerifier, "not.a.token", true},
+	}
+	for _, tt := range tests {
+		principal, err := tt.verifier.Verify(tt.token)
+		if tt.wantErr {
+			if !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf("%s: got %+v, %v, want ErrUnauthenticated", tt.name, principal, err)
+			}
+			continue
+		}
+		if err != nil || principal.Subject != "7" || principal.Method != MethodJWT {
+			t.Errorf("%s: got %+v, %v", tt.name, principal, err)
+		}
+	}
+}
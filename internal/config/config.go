@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -14,6 +15,83 @@ type Config struct {
 	PostgresUser        string `mapstructure:"POSTGRES_USER"`
 	PostgresPassword    string `mapstructure:"POSTGRES_PASSWORD"`
 	PostgresDBName      string `mapstructure:"POSTGRES_DBNAME"`
+	JWTHMACSecret       string `mapstructure:"JWT_HMAC_SECRET"`
+	JWTRSAPublicKeyPath string `mapstructure:"JWT_RSA_PUBLIC_KEY_PATH"`
+	JWTIssuer           string `mapstructure:"JWT_ISSUER"`
+	JWTAudience         string `mapstructure:"JWT_AUDIENCE"`
+	// MigrationsPath is a directory of migrations to apply instead of those
+	// built into the binary, and where new migrations are created. Those of
+	// SQLite are in its sqlite subdirectory.
+	MigrationsPath string `mapstructure:"MIGRATIONS_PATH"`
+	// UserRetentionPeriod is how long soft-deleted users are kept before
+	// being purged; zero keeps them forever.
+	UserRetentionPeriod time.Duration `mapstructure:"USER_RETENTION_PERIOD"`
+	UserPurgeInterval   time.Duration `mapstructure:"USER_PURGE_INTERVAL"`
+	// DBConnectTimeout is how long startup keeps retrying to reach the
+	// database. The other timeouts bound single reads, writes, and bulk
+	// operations such as exports and purges, and calls to the enrichment
+	// API. Zero selects the default, a negative timeout lifts the bound.
+	DBConnectTimeout  time.Duration `mapstructure:"DB_CONNECT_TIMEOUT"`
+	DBReadTimeout     time.Duration `mapstructure:"DB_READ_TIMEOUT"`
+	DBWriteTimeout    time.Duration `mapstructure:"DB_WRITE_TIMEOUT"`
+	DBBulkTimeout     time.Duration `mapstructure:"DB_BULK_TIMEOUT"`
+	EnrichmentTimeout time.Duration `mapstructure:"ENRICHMENT_TIMEOUT"`
+	// IdempotencyKeyTTL is how long the response to a request with an
+	// Idempotency-Key is replayed for retries; zero means a day.
+	IdempotencyKeyTTL time.Duration `mapstructure:"IDEMPOTENCY_KEY_TTL"`
+	// RateLimitStore keeps the rate limiter's buckets in "memory", per
+	// instance, or in "postgres", shared by all instances; "off" disables
+	// rate limiting.
+	RateLimitStore string `mapstructure:"RATE_LIMIT_STORE"`
+	// Requests per minute and burst size per remote address, limited before
+	// authentication. Zero selects the default, a negative rate lifts the
+	// limit.
+	RateLimitAddressPerMinute int `mapstructure:"RATE_LIMIT_ADDRESS_PER_MINUTE"`
+	RateLimitAddressBurst     int `mapstructure:"RATE_LIMIT_ADDRESS_BURST"`
+	// Requests per minute and burst sizes per API key or token subject of
+	// reads, writes and personal data exports. Zero selects the default, a
+	// negative rate lifts the limit.
+	RateLimitReadsPerMinute   int `mapstructure:"RATE_LIMIT_READS_PER_MINUTE"`
+	RateLimitReadBurst        int `mapstructure:"RATE_LIMIT_READ_BURST"`
+	RateLimitWritesPerMinute  int `mapstructure:"RATE_LIMIT_WRITES_PER_MINUTE"`
+	RateLimitWriteBurst       int `mapstructure:"RATE_LIMIT_WRITE_BURST"`
+	RateLimitExportsPerMinute int `mapstructure:"RATE_LIMIT_EXPORTS_PER_MINUTE"`
+	RateLimitExportBurst      int `mapstructure:"RATE_LIMIT_EXPORT_BURST"`
+	// EncryptionKeys lists key-encryption keys for personal data as
+	// comma-separated id:base64 pairs; EncryptionActiveKeyID selects the one
+	// new values are encrypted with. Retired keys stay listed until the
+	// encrypt command has re-encrypted everything under the active key.
+	EncryptionKeys        string `mapstructure:"ENCRYPTION_KEYS"`
+	EncryptionActiveKeyID string `mapstructure:"ENCRYPTION_ACTIVE_KEY_ID"`
+	BlindIndexKey         string `mapstructure:"BLIND_INDEX_KEY"`
+	// TracingExporter sends spans to an OTLP/HTTP collector ("otlp"), prints
+	// them ("stdout") or drops them ("none", the default).
+	TracingExporter     string  `mapstructure:"TRACING_EXPORTER"`
+	TracingOTLPEndpoint string  `mapstructure:"TRACING_OTLP_ENDPOINT"`
+	TracingOTLPInsecure bool    `mapstructure:"TRACING_OTLP_INSECURE"`
+	TracingSampleRatio  float64 `mapstructure:"TRACING_SAMPLE_RATIO"`
+	// Limits of the HTTP server; zero selects the default of each. The write
+	// timeout bounds how long a handler may take to respond, except that
+	// exports and erasure get the bulk timeout on top of it. The shutdown
+	// timeout is how long in-flight requests are waited for on SIGTERM.
+	HTTPReadTimeout       time.Duration `mapstructure:"HTTP_READ_TIMEOUT"`
+	HTTPReadHeaderTimeout time.Duration `mapstructure:"HTTP_READ_HEADER_TIMEOUT"`
+	HTTPWriteTimeout      time.Duration `mapstructure:"HTTP_WRITE_TIMEOUT"`
+	HTTPIdleTimeout       time.Duration `mapstructure:"HTTP_IDLE_TIMEOUT"`
+	HTTPMaxHeaderBytes    int           `mapstructure:"HTTP_MAX_HEADER_BYTES"`
+	HTTPMaxBodyBytes      int64         `mapstructure:"HTTP_MAX_BODY_BYTES"`
+	ShutdownTimeout       time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+	// Storage keeps data in "postgres", the default, in a "sqlite" database
+	// file, or in "memory" for a demo: nothing survives a restart and sample
+	// data is seeded on startup.
+	Storage string `mapstructure:"STORAGE"`
+	// SQLitePath is the database file of the sqlite storage, created on first
+	// start; it defaults to timetracker.db in the working directory.
+	SQLitePath string `mapstructure:"SQLITE_PATH"`
+	// AdminPort serves /metrics, /healthz and /readyz, which need no
+	// credentials; keep it reachable only by the orchestrator and the
+	// metrics scraper.
+	AdminPort string `mapstructure:"ADMIN_PORT"`
 }
 
 func LoadConfig(path string) (c Config, err error) {
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timeTracker/internal/models"
+	"timeTracker/internal/pii"
+	"timeTracker/internal/policy"
+)
+
+// AuditEvents godoc
+// @Summary Get audit events
+// @Description Get the audit trail of changes, newest first
+// @Tags audit
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number, starting at 1" default(1)
+// @Param limit query int false "Number of items per page, at most 100" default(20)
+// @Param actor_id query int false "Filter by the user who made the change"
+// @Param action query string false "Filter by action, e.g. user.update"
+// @Param entity_type query string false "Filter by entity type, e.g. user"
+// @Param entity_id query int false "Filter by entity ID"
+// @Param from query string false "Only events at or after this time (RFC 3339)"
+// @Param to query string false "Only events before this time (RFC 3339)"
+// @Success 200 {array} models.AuditEvent
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 422 {object} Problem "Validation failed"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Router /audit [get]
+func (h *Handler) AuditEvents(w http.ResponseWriter, r *http.Request) {
+	const op = "controller AuditEvents: "
+	principal, ok := h.authorize(w, r, policy.ViewAudit, policy.Target{})
+	if !ok {
+		return
+	}
+
+	filter, err := parseAuditQuery(r)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	events, err := h.auditService.AuditEvents(r.Context(), principal.OrganizationID, filter)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	if !principal.HasPrivilege(models.PrivilegeViewPersonalData) {
+		for i := range events {
+			events[i].Before = pii.MaskSnapshot(events[i].Before)
+			events[i].After = pii.MaskSnapshot(events[i].After)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(events); err != nil {
+		h.logger.With("operation: ", op).Error(err.Error())
+		return
+	}
+	h.logger.With("page", filter.Page, "limit", filter.Limit).Debug("return audit events")
+}
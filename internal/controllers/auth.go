@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"timeTracker/internal/auth"
+	"timeTracker/internal/models"
+	"timeTracker/internal/policy"
+	"timeTracker/internal/validate"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	UnauthorizedMessage = "unauthorized"
+	ForbiddenMessage    = "forbidden"
+)
+
+type issueAPIKeyRequest struct {
+	Name string `json:"name" validate:"required,max=100" example:"payroll export"`
+}
+
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const op = "controller authenticate: "
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="time-tracker"`)
+			writeProblem(w, r, http.StatusUnauthorized, UnauthorizedMessage)
+			return
+		}
+
+		principal, err := h.authService.Authenticate(r.Context(), token)
+		if err != nil {
+			h.unauthenticated(w, r, op, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+func (h *Handler) unauthenticated(w http.ResponseWriter, r *http.Request, op string, err error) {
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="time-tracker", error="invalid_token"`)
+	}
+	h.fail(w, r, h.logger.With("operation: ", op), err)
+}
+
+// authorize consults the policy for the principal of the request and writes
+// a 403 response with the reason when the action is denied.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, action policy.Action, target policy.Target) (auth.Principal, bool) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	decision := policy.Evaluate(principal, action, target)
+	if !decision.Allowed {
+		h.logger.With("userID", principal.UserID,
+			"action", action,
+			"targetUserID", target.UserID).Info("access denied: " + decision.Reason)
+		writeProblem(w, r, http.StatusForbidden, ForbiddenMessage+": "+decision.Reason)
+		return principal, false
+	}
+
+	return principal, true
+}
+
+// authorizeUser authorizes an action on the user with the given ID and loads
+// them. The action is checked before the lookup as if the user were in the
+// caller's team, so that callers who may only act on themselves get 403
+// whether or not the ID exists. Users outside the caller's team are then
+// reported as missing, just as the user list leaves them out.
+func (h *Handler) authorizeUser(w http.ResponseWriter, r *http.Request, logger *slog.Logger, action policy.Action,
+	id int) (auth.Principal, models.User, bool) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if _, ok := h.authorize(w, r, action, policy.Target{UserID: id, Team: principal.Team}); !ok {
+		return principal, models.User{}, false
+	}
+
+	user, err := h.userService.User(r.Context(), principal.OrganizationID, id)
+	if err != nil {
+		h.fail(w, r, logger, err)
+		return principal, user, false
+	}
+	if decision := policy.Evaluate(principal, action, policy.Target{UserID: user.ID, Team: user.Team}); !decision.Allowed {
+		logger.With("userID", principal.UserID, "action", action).Info("access denied: " + decision.Reason)
+		writeProblem(w, r, http.StatusNotFound, NotFoundMessage)
+		return principal, user, false
+	}
+
+	return principal, user, true
+}
+
+func bearerToken(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+// IssueAPIKey godoc
+// @Summary Issue an API key
+// @Description Create a long-lived API key acting on behalf of the caller. The key is returned only once.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param key body issueAPIKeyRequest true "API key name"
+// @Param Idempotency-Key header string false "Unique key making retries safe; the first response is replayed for retries"
+// @Success 201 {object} models.IssuedAPIKey
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 413 {object} Problem "Request Entity Too Large"
+// @Failure 422 {object} Problem "Validation failed"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Router /api-keys [post]
+func (h *Handler) IssueAPIKey(w http.ResponseWriter, r *http.Request) {
+	const op = "controller IssueAPIKey: "
+	var req issueAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyProblem(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+	if err := validate.Struct(&req); err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	principal, ok := h.authorize(w, r, policy.IssueAPIKey, policy.Target{})
+	if !ok {
+		return
+	}
+	issued, err := h.authService.IssueAPIKey(r.Context(), principal.OrganizationID, actor(r, principal), req.Name, principal.Subject)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err = json.NewEncoder(w).Encode(issued); err != nil {
+		h.logger.With("operation: ", op).Error(err.Error())
+		return
+	}
+	h.logger.With("apiKeyID", issued.ID, "subject", principal.Subject).Debug("issued api key")
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Revoke an API key by ID
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "API key ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Router /api-keys/{id} [delete]
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	const op = "controller RevokeAPIKey: "
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.logger.With("operation: ", op).Info(err.Error())
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	key, err := h.authService.APIKey(r.Context(), principal.OrganizationID, id)
+	if err != nil {
+		h.fail(w, r, h.logger.With("apiKeyID", id), err)
+		return
+	}
+	owner, _ := strconv.Atoi(key.Subject)
+	if _, ok := h.authorize(w, r, policy.RevokeAPIKey, policy.Target{UserID: owner}); !ok {
+		return
+	}
+
+	if err = h.authService.RevokeAPIKey(r.Context(), principal.OrganizationID, actor(r, principal), id); err != nil {
+		h.fail(w, r, h.logger.With("apiKeyID", id), err)
+		return
+	}
+
+	h.logger.With("apiKeyID", id).Debug("revoked api key")
+
+	w.WriteHeader(http.StatusNoContent)
+}
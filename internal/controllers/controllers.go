@@ -1,17 +1,23 @@
 package controllers
 
 import (
-	"database/sql"
+	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"strconv"
 	"time"
 
+	"timeTracker/internal/auth"
+	"timeTracker/internal/mergepatch"
 	"timeTracker/internal/models"
+	"timeTracker/internal/policy"
+	"timeTracker/internal/ratelimit"
 	"timeTracker/internal/service"
+	"timeTracker/internal/validate"
 
 	"github.com/gorilla/mux"
 )
@@ -19,27 +25,50 @@ import (
 const (
 	InternalServerErrorMessage = "internal server error"
 	BadRequestMessage          = "bad request"
+	NotFoundMessage            = "not found"
 )
 
 type Handler struct {
-	userService *service.UserService
-	logger      *slog.Logger
+	userService        *service.UserService
+	authService        *service.AuthService
+	auditService       *service.AuditService
+	idempotencyService *service.IdempotencyService
+	// limiter limits the request rate of clients; nil disables limiting.
+	limiter *ratelimit.Limiter
+	// bulkWriteTimeout replaces the server's write timeout for responses
+	// waiting on bulk database operations; zero keeps the server's and a
+	// negative value lifts it.
+	bulkWriteTimeout time.Duration
+	logger           *slog.Logger
 }
 
-func NewHandler(userService *service.UserService, logger *slog.Logger) *Handler {
-	return &Handler{userService: userService, logger: logger}
+func NewHandler(userService *service.UserService, authService *service.AuthService, auditService *service.AuditService,
+	idempotencyService *service.IdempotencyService, limiter *ratelimit.Limiter, bulkWriteTimeout time.Duration,
+	logger *slog.Logger) *Handler {
+	return &Handler{userService: userService, authService: authService, auditService: auditService,
+		idempotencyService: idempotencyService, limiter: limiter, bulkWriteTimeout: bulkWriteTimeout, logger: logger}
 }
 
 func (h *Handler) Router() *mux.Router {
 	r := mux.NewRouter()
-
-	r.HandleFunc("/users", h.Users).Methods("GET")
-	r.HandleFunc("/users/{id}/workload", h.GetUserWorkload).Methods("GET")
-	r.HandleFunc("/users/{id}/tasks/{taskId}/start", h.StartUserTask).Methods("POST")
-	r.HandleFunc("/users/{id}/tasks/{taskId}/stop", h.StopUserTask).Methods("POST")
-	r.HandleFunc("/users/{id}", h.DeleteUser).Methods("DELETE")
-	r.HandleFunc("/users/{id}", h.UpdateUser).Methods("PUT")
-	r.HandleFunc("/users", h.AddUser).Methods("POST")
+	r.Use(traceRequests, instrument, requestID, h.limitAddresses, h.authenticate, h.rateLimit, h.idempotent)
+
+	r.HandleFunc("/users", h.Users).Methods("GET").Name("Users")
+	r.HandleFunc("/users/{id}", h.GetUser).Methods("GET").Name("GetUser")
+	r.HandleFunc("/users/{id}/workload", h.GetUserWorkload).Methods("GET").Name("GetUserWorkload")
+	r.HandleFunc("/users/{id}/tasks/{taskId}/start", h.StartUserTask).Methods("POST").Name("StartUserTask")
+	r.HandleFunc("/users/{id}/tasks/{taskId}/stop", h.StopUserTask).Methods("POST").Name("StopUserTask")
+	r.HandleFunc("/users/{id}", h.DeleteUser).Methods("DELETE").Name("DeleteUser")
+	r.HandleFunc("/users/{id}/restore", h.RestoreUser).Methods("POST").Name("RestoreUser")
+	r.HandleFunc("/users/{id}/personal-data", h.ExportPersonalData).Methods("GET").Name("ExportPersonalData")
+	r.HandleFunc("/users/{id}/personal-data", h.ErasePersonalData).Methods("DELETE").Name("ErasePersonalData")
+	r.HandleFunc("/users/{id}", h.UpdateUser).Methods("PUT").Name("UpdateUser")
+	r.HandleFunc("/users/{id}", h.PatchUser).Methods("PATCH").Name("PatchUser")
+	r.HandleFunc("/users", h.AddUser).Methods("POST").Name("AddUser")
+	r.HandleFunc("/api-keys", h.IssueAPIKey).Methods("POST").Name("IssueAPIKey")
+	r.HandleFunc("/api-keys/{id}", h.RevokeAPIKey).Methods("DELETE").Name("RevokeAPIKey")
+	r.HandleFunc("/audit", h.AuditEvents).Methods("GET").Name("AuditEvents")
+	r.HandleFunc("/search", h.Search).Methods("GET").Name("Search")
 
 	return r
 }
@@ -49,69 +78,150 @@ func (h *Handler) Router() *mux.Router {
 // @description app for tracking time
 // @host localhost:8080
 
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT or API key as "Bearer <token>"
+
 // @tag.name users
 // @tag.description User management operations
 
+// @tag.name auth
+// @tag.description API key management
+
+// @tag.name audit
+// @tag.description Audit trail of changes
+
+// @tag.name personal-data
+// @tag.description Data subject requests under GDPR and 152-FZ
+
+// @tag.name search
+// @tag.description Full-text and fuzzy search
+
 // Users godoc
 // @Summary Get users
-// @Description Get a list of users with pagination and filtering
+// @Description Get a list of users with pagination and filtering. Admins see all users, managers their team and employees only themselves.
+// @Description Passport numbers and addresses of other users are masked unless the caller has the personal_data:view privilege.
+// @Description Pages are selected by number or, for stable paging through large listings, by the after and before cursors of the Link header. The next link of every page carries a cursor, so following it switches to keyset paging.
+// @Description Filters are written field=value or field[operator]=value with the operators eq, prefix, contains, in (comma-separated values), gt, gte, lt and lte, and combined with AND.
 // @Tags users
 // @Accept json
 // @Produce json
-// @Param page query int true "Page number"
-// @Param limit query int true "Number of items per page"
-// @Param surname query string false "Filter by surname"
-// @Param name query string false "Filter by name"
-// @Param passport_number query string false "Filter by passport number"
-// @Param patronymic query string false "Filter by patronymic"
-// @Param address query string false "Filter by address"
+// @Security BearerAuth
+// @Param page query int false "Page number, starting at 1" default(1)
+// @Param limit query int false "Number of items per page, at most 100" default(20)
+// @Param after query string false "Cursor of the last user of the previous page, from the next link"
+// @Param before query string false "Cursor of the first user of the next page, from the prev link"
+// @Param sort query string false "Comma-separated fields to sort by, prefixed with - for descending order, e.g. surname,-created_at"
+// @Param surname query string false "Filter by surname prefix; surname[eq], surname[contains] and surname[in] also work"
+// @Param name query string false "Filter by name prefix; name[eq], name[contains] and name[in] also work"
+// @Param patronymic query string false "Filter by patronymic prefix; patronymic[eq], patronymic[contains] and patronymic[in] also work"
+// @Param passport_number query string false "Filter by passport number (exact match); passport_number[in] also works"
+// @Param role query string false "Filter by role; role[in] also works"
+// @Param team query string false "Filter by team prefix; team[eq] and team[in] also work"
+// @Param created_at[gte] query string false "Only users created at or after this time; also gt, lte, lt and eq"
+// @Param updated_at[gte] query string false "Only users updated at or after this time; also gt, lte, lt and eq"
+// @Param include_deleted query bool false "Also list soft-deleted users (admins only)"
+// @Param If-None-Match header string false "ETag of a previous response; 304 is returned if the page is unchanged"
 // @Success 200 {array} models.User
-// @Failure 400 {object} string "Bad Request"
-// @Failure 500 {object} string "Internal Server Error"
+// @Success 304 "Not Modified"
+// @Header 200 {string} Link "Links to the first, prev, next and, for numbered pages, last pages"
+// @Header 200 {integer} X-Total-Count "Number of users matching the filters"
+// @Header 200 {string} ETag "Weak entity tag of the page"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 422 {object} Problem "Validation failed"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
 // @Router /users [get]
 func (h *Handler) Users(w http.ResponseWriter, r *http.Request) {
 	const op = "controller GetUsers: "
-	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	list, err := parseUsersQuery(r)
 	if err != nil {
-		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		h.fail(w, r, h.logger.With("operation: ", op), err)
 		return
 	}
-	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
-	if err != nil {
-		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+
+	principal, ok := h.authorize(w, r, policy.ListUsers, policy.Target{})
+	if !ok {
 		return
 	}
 
-	filters := filters(r)
+	query := list.query()
+	if query.IncludeDeleted {
+		if _, ok := h.authorize(w, r, policy.ListDeleted, policy.Target{}); !ok {
+			return
+		}
+	}
+	query.Filters = append(query.Filters, scopeFilters(principal)...)
 
-	users, err := h.userService.GetUsers(page, limit, filters)
+	page := list.pagination()
+	result, err := h.userService.GetUsers(r.Context(), principal.OrganizationID, page, query)
 	if err != nil {
-		h.logger.With("operation: ", op).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		h.fail(w, r, h.logger.With("operation: ", op), err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err = json.NewEncoder(w).Encode(users); err != nil {
+	users := make([]models.User, len(result.Users))
+	for i, user := range result.Users {
+		users[i] = presentUser(principal, user)
+	}
+
+	writePageLinks(w, r, page, result.Total, result.Next, result.Prev)
+	if err = writeCacheable(w, r, users); err != nil {
 		h.logger.With("operation: ", op).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, InternalServerErrorMessage)
 		return
 	}
-	h.logger.Debug(fmt.Sprintf("return all users with page=%d limit=%d", page, limit))
+	h.logger.Debug(fmt.Sprintf("return all users with page=%d limit=%d", page.Page, page.Limit))
 }
 
-func filters(r *http.Request) map[string]string {
-	surname := r.URL.Query().Get("surname")
-	name := r.URL.Query().Get("name")
-	passport_number := r.URL.Query().Get("passport_number")
-	patronymic := r.URL.Query().Get("patronymic")
-	address := r.URL.Query().Get("address")
+// GetUser godoc
+// @Summary Get a user
+// @Description Get a user by ID. The ETag of the response is the version of the user, to be sent back in If-Match when changing the user.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param If-None-Match header string false "ETag of a previous response; 304 is returned if the user is unchanged"
+// @Success 200 {object} models.User
+// @Success 304 "Not Modified"
+// @Header 200 {string} ETag "Version of the user"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Router /users/{id} [get]
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	const op = "controller GetUser: "
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.logger.With("operation: ", op).Info(err.Error())
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
+		return
+	}
+
+	principal, user, ok := h.authorizeUser(w, r, h.logger.With("operation: ", op, "userID", id), policy.ViewUser, id)
+	if !ok {
+		return
+	}
+
+	if notModified(w, r, userETag(principal, user)) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
 
-	return map[string]string{"surname": surname, "name": name,
-		"passport_number": passport_number, "patronymic": patronymic,
-		"address": address}
+	if err = json.NewEncoder(w).Encode(presentUser(principal, user)); err != nil {
+		h.logger.With("userID", id).Error(err.Error())
+		return
+	}
+	h.logger.With("userID", id).Debug("return user")
 }
 
 // GetUserWorkload godoc
@@ -120,52 +230,56 @@ func filters(r *http.Request) map[string]string {
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security BearerAuth
 // @Param id path int true "User ID"
 // @Param start query string true "Start date (YYYY-MM-DD)"
 // @Param end query string true "End date (YYYY-MM-DD)"
+// @Param If-None-Match header string false "ETag of a previous response; 304 is returned if the workload is unchanged"
 // @Success 200 {array} models.Workload
-// @Failure 400 {object} string "Bad Request"
-// @Failure 500 {object} string "Internal Server Error"
+// @Success 304 "Not Modified"
+// @Header 200 {string} ETag "Weak entity tag of the workload"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 422 {object} Problem "Validation failed"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
 // @Router /users/{id}/workload [get]
 func (h *Handler) GetUserWorkload(w http.ResponseWriter, r *http.Request) {
 	const op = "controller GetUserWorkLoad: "
 	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
 		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
 		return
 	}
-	start, err := time.Parse("2006-01-02", r.URL.Query().Get("start"))
+	query, err := parseWorkloadQuery(r)
 	if err != nil {
-		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		h.fail(w, r, h.logger.With("operation: ", op), err)
 		return
 	}
-	end, err := time.Parse("2006-01-02", r.URL.Query().Get("end"))
-	if err != nil {
-		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+	start, end := query.Start, query.End
+
+	principal, _, ok := h.authorizeUser(w, r, h.logger.With("operation: ", op, "userID", id), policy.ViewWorkload, id)
+	if !ok {
 		return
 	}
 
-	workload, err := h.userService.GetUserWorkload(id, start, end)
+	workload, err := h.userService.GetUserWorkload(r.Context(), principal.OrganizationID, id, start, end)
 	if err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			h.logger.With("id", id,
-				"start", start,
-				"end", end).Error(err.Error())
-			http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
-		}
+		h.fail(w, r, h.logger.With("id", id,
+			"start", start,
+			"end", end), err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-
-	if err = json.NewEncoder(w).Encode(workload); err != nil {
+	if err = writeCacheable(w, r, workload); err != nil {
 		h.logger.With("id", id,
 			"start", start,
 			"end", end).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, InternalServerErrorMessage)
 	}
 
 	h.logger.With("userID", id).Debug("return user's workload")
@@ -177,44 +291,66 @@ func (h *Handler) GetUserWorkload(w http.ResponseWriter, r *http.Request) {
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security BearerAuth
 // @Param id path int true "User ID"
 // @Param taskId path int true "Task ID"
+// @Param If-Match header string false "ETag of the task the change is based on"
+// @Param Idempotency-Key header string false "Unique key making retries safe; the first response is replayed for retries"
 // @Success 200 {object} models.Task
-// @Failure 400 {object} string "Bad Request"
-// @Failure 500 {object} string "Internal Server Error"
+// @Header 200 {string} ETag "Version of the task"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 409 {object} Problem "Task already active"
+// @Failure 412 {object} Problem "Task modified since the given version"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
 // @Router /users/{id}/tasks/{taskId}/start [post]
 func (h *Handler) StartUserTask(w http.ResponseWriter, r *http.Request) {
 	const op = "controller StartUserTask: "
 	userId, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
 		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
 		return
 	}
 	taskId, err := strconv.Atoi(mux.Vars(r)["taskId"])
 	if err != nil {
 		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
 		return
 	}
 
-	task, err := h.userService.StartUserTask(userId, taskId)
+	version, err := ifMatch(r)
 	if err != nil {
-		h.logger.With(
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	principal, ok := h.authorize(w, r, policy.StartTask, policy.Target{UserID: userId})
+	if !ok {
+		return
+	}
+
+	task, err := h.userService.StartUserTask(r.Context(), principal.OrganizationID, actor(r, principal), userId, taskId, version)
+	if err != nil {
+		h.fail(w, r, h.logger.With(
 			"userID", userId,
 			"taskID", taskId,
-		).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		), err)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(task.Version))
 	w.Header().Set("Content-Type", "application/json")
 	if err = json.NewEncoder(w).Encode(task); err != nil {
 		h.logger.With(
 			"userID", userId,
 			"taskID", taskId,
 		).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, InternalServerErrorMessage)
 	}
 
 	h.logger.With("userID", userId,
@@ -227,43 +363,65 @@ func (h *Handler) StartUserTask(w http.ResponseWriter, r *http.Request) {
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security BearerAuth
 // @Param id path int true "User ID"
 // @Param taskId path int true "Task ID"
+// @Param If-Match header string false "ETag of the task the change is based on"
+// @Param Idempotency-Key header string false "Unique key making retries safe; the first response is replayed for retries"
 // @Success 200 {object} models.Task
-// @Failure 400 {object} string "Bad Request"
-// @Failure 500 {object} string "Internal Server Error"
+// @Header 200 {string} ETag "Version of the task"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 409 {object} Problem "Task not active"
+// @Failure 412 {object} Problem "Task modified since the given version"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
 // @Router /users/{id}/tasks/{taskId}/stop [post]
 func (h *Handler) StopUserTask(w http.ResponseWriter, r *http.Request) {
 	const op = "controller StopUserTask: "
 	userId, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
 		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
 		return
 	}
 	taskId, err := strconv.Atoi(mux.Vars(r)["taskId"])
 	if err != nil {
 		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
 		return
 	}
 
-	task, err := h.userService.StopUserTask(userId, taskId)
+	version, err := ifMatch(r)
 	if err != nil {
-		h.logger.With("operation: ", op,
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	principal, ok := h.authorize(w, r, policy.StopTask, policy.Target{UserID: userId})
+	if !ok {
+		return
+	}
+
+	task, err := h.userService.StopUserTask(r.Context(), principal.OrganizationID, actor(r, principal), userId, taskId, version)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op,
 			"taskID", task.ID,
-			"userID", task.UserID).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+			"userID", task.UserID), err)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(task.Version))
 	w.Header().Set("Content-Type", "application/json")
 
 	if err = json.NewEncoder(w).Encode(task); err != nil {
 		h.logger.With("operation: ", op,
 			"taskID", task.ID,
 			"userID", task.UserID).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, InternalServerErrorMessage)
 	}
 
 	h.logger.With("userID", userId,
@@ -272,28 +430,46 @@ func (h *Handler) StopUserTask(w http.ResponseWriter, r *http.Request) {
 
 // DeleteUser godoc
 // @Summary Delete a user
-// @Description Delete a user by ID
+// @Description Soft-delete a user by ID. The user and their time entries are kept until restored or purged by the retention job.
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security BearerAuth
 // @Param id path int true "User ID"
+// @Param If-Match header string false "ETag of the user the deletion is based on"
 // @Success 204 "No Content"
-// @Failure 400 {object} string "Bad Request"
-// @Failure 500 {object} string "Internal Server Error"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 412 {object} Problem "User modified since the given version"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
 // @Router /users/{id} [delete]
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	const op = "controller DeleteUser: "
 	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
 		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
 		return
 	}
 
-	err = h.userService.DeleteUser(id)
+	version, err := ifMatch(r)
 	if err != nil {
-		h.logger.With("userID", id).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	principal, ok := h.authorize(w, r, policy.DeleteUser, policy.Target{UserID: id})
+	if !ok {
+		return
+	}
+
+	err = h.userService.DeleteUser(r.Context(), principal.OrganizationID, actor(r, principal), id, version)
+	if err != nil {
+		h.fail(w, r, h.logger.With("userID", id), err)
 		return
 	}
 
@@ -302,47 +478,230 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RestoreUser godoc
+// @Summary Restore a user
+// @Description Restore a soft-deleted user by ID
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param If-Match header string false "ETag of the deleted user the restoration is based on"
+// @Param Idempotency-Key header string false "Unique key making retries safe; the first response is replayed for retries"
+// @Success 200 {object} models.User
+// @Header 200 {string} ETag "Version of the user"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 409 {object} Problem "Passport number taken"
+// @Failure 412 {object} Problem "User modified since the given version"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Router /users/{id}/restore [post]
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	const op = "controller RestoreUser: "
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.logger.With("operation: ", op).Info(err.Error())
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
+		return
+	}
+
+	version, err := ifMatch(r)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	principal, ok := h.authorize(w, r, policy.RestoreUser, policy.Target{UserID: id})
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.RestoreUser(r.Context(), principal.OrganizationID, actor(r, principal), id, version)
+	if err != nil {
+		h.fail(w, r, h.logger.With("userID", id), err)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(principal, user))
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(presentUser(principal, user)); err != nil {
+		h.logger.With("userID", id).Error(err.Error())
+		return
+	}
+	h.logger.With("userID", id).Debug("restored user")
+}
+
 // UpdateUser godoc
-// @Summary Update a user
-// @Description Update a user's information
+// @Summary Replace a user
+// @Description Replace every editable field of a user. Optional fields left out are cleared. Replacements based on a masked user, as seen without the personal_data:view privilege, are rejected; use PATCH instead.
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security BearerAuth
 // @Param id path int true "User ID"
-// @Param user body models.User true "Updated user information"
+// @Param user body replaceUserRequest true "Complete user information"
+// @Param If-Match header string false "ETag of the user the replacement is based on"
 // @Success 200 {object} models.User
-// @Failure 400 {object} string "Bad Request"
-// @Failure 500 {object} string "Internal Server Error"
+// @Header 200 {string} ETag "Version of the user"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 409 {object} Problem "Passport number taken"
+// @Failure 412 {object} Problem "User modified since the given version"
+// @Failure 413 {object} Problem "Request Entity Too Large"
+// @Failure 422 {object} Problem "Validation failed"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
 // @Router /users/{id} [put]
 func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	const op = "controller UpdateUser: "
 	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
 		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
 		return
 	}
 
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	version, err := ifMatchReplacement(r)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	principal, ok := h.authorize(w, r, policy.UpdateUser, policy.Target{UserID: id})
+	if !ok {
+		return
+	}
+
+	var req replaceUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyProblem(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	h.replaceUser(w, r, op, principal, id, version, req)
+}
+
+// PatchUser godoc
+// @Summary Update a user
+// @Description Update some fields of a user with a JSON Merge Patch (RFC 7396). Members set to null are cleared; the result is validated like a full replacement.
+// @Description Without If-Match the patch still fails with 412 if the user changes while it is applied.
+// @Tags users
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param patch body replaceUserRequest true "Fields to change, null to clear"
+// @Param If-Match header string false "ETag of the user the patch is based on"
+// @Success 200 {object} models.User
+// @Header 200 {string} ETag "Version of the user"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 409 {object} Problem "Passport number taken"
+// @Failure 412 {object} Problem "User modified since the given version"
+// @Failure 413 {object} Problem "Request Entity Too Large"
+// @Failure 415 {object} Problem "Unsupported Media Type"
+// @Failure 422 {object} Problem "Validation failed"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Router /users/{id} [patch]
+func (h *Handler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	const op = "controller PatchUser: "
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
 		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != mergepatch.ContentType &&
+		mediaType != "application/json" {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "expected "+mergepatch.ContentType)
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	principal, ok := h.authorize(w, r, policy.UpdateUser, policy.Target{UserID: id})
+	if !ok {
 		return
 	}
 
-	user.ID = id
-	updatedUser, err := h.userService.UpdateUser(user)
+	user, err := h.userService.User(r.Context(), principal.OrganizationID, id)
 	if err != nil {
-		h.logger.With("userID", id).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		h.fail(w, r, h.logger.With("userID", id), err)
+		return
+	}
+	// The patch applies to the version just read, so a concurrent change
+	// before it is written fails instead of being overwritten.
+	if version == 0 {
+		version = user.Version
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyProblem(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+	doc, err := json.Marshal(userDocument(user))
+	if err != nil {
+		h.fail(w, r, h.logger.With("userID", id), err)
+		return
+	}
+	merged, err := mergepatch.Apply(doc, patch)
+	if err != nil {
+		h.logger.With("operation: ", op).Info(err.Error())
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
 		return
 	}
 
+	// Unknown members would be silently dropped otherwise, hiding typos and
+	// attempts to change read-only fields such as id.
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+	var req replaceUserRequest
+	if err := decoder.Decode(&req); err != nil {
+		h.logger.With("operation: ", op).Info(err.Error())
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.replaceUser(w, r, op, principal, id, version, req)
+}
+
+func (h *Handler) replaceUser(w http.ResponseWriter, r *http.Request, op string, principal auth.Principal, id, version int,
+	req replaceUserRequest) {
+	if err := validate.Struct(&req); err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	user := req.user(id)
+	user.Version = version
+	updatedUser, err := h.userService.ReplaceUser(r.Context(), principal.OrganizationID, actor(r, principal), user)
+	if err != nil {
+		h.fail(w, r, h.logger.With("userID", id), err)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(principal, updatedUser))
 	w.Header().Set("Content-Type", "application/json")
 
-	if err = json.NewEncoder(w).Encode(updatedUser); err != nil {
+	if err = json.NewEncoder(w).Encode(presentUser(principal, updatedUser)); err != nil {
 		h.logger.With("userID", id).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, InternalServerErrorMessage)
 		return
 	}
 	h.logger.With("userID", id).Debug("updated user")
@@ -354,33 +713,52 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 // @Tags users
 // @Accept json
 // @Produce json
-// @Param user body models.User true "New user information"
+// @Security BearerAuth
+// @Param user body createUserRequest true "New user information"
+// @Param Idempotency-Key header string false "Unique key making retries safe; the first response is replayed for retries"
 // @Success 201 {object} models.User
-// @Failure 400 {object} string "Bad Request"
-// @Failure 500 {object} string "Internal Server Error"
+// @Header 201 {string} ETag "Version of the user"
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 409 {object} Problem "Passport number taken"
+// @Failure 413 {object} Problem "Request Entity Too Large"
+// @Failure 422 {object} Problem "Validation failed"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Failure 502 {object} Problem "Enrichment service failed"
 // @Router /users [post]
 func (h *Handler) AddUser(w http.ResponseWriter, r *http.Request) {
 	const op = "controller AddUser: "
-	var newUser models.User
-	if err := json.NewDecoder(r.Body).Decode(&newUser); err != nil {
-		h.logger.With("operation: ", op).Info(err.Error())
-		http.Error(w, BadRequestMessage, http.StatusBadRequest)
+	principal, ok := h.authorize(w, r, policy.CreateUser, policy.Target{})
+	if !ok {
+		return
+	}
+
+	var req createUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyProblem(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+	if err := validate.Struct(&req); err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
 		return
 	}
 
-	enrichedUser, err := h.userService.AddUser(newUser)
+	enrichedUser, err := h.userService.AddUser(r.Context(), principal.OrganizationID, actor(r, principal), req.user())
 	if err != nil {
-		h.logger.With("userID", newUser.ID).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+		h.fail(w, r, h.logger.With("operation: ", op), err)
 		return
 	}
 
+	w.Header().Set("ETag", userETag(principal, enrichedUser))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 
-	if err = json.NewEncoder(w).Encode(enrichedUser); err != nil {
-		h.logger.With("userID", newUser.ID).Error(err.Error())
-		http.Error(w, InternalServerErrorMessage, http.StatusInternalServerError)
+	if err = json.NewEncoder(w).Encode(presentUser(principal, enrichedUser)); err != nil {
+		h.logger.With("userID", enrichedUser.ID).Error(err.Error())
+		writeProblem(w, r, http.StatusInternalServerError, InternalServerErrorMessage)
 		return
 	}
 	h.logger.With("userID", enrichedUser.ID).Debug("created user")
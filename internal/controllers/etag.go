@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/auth"
+	"timeTracker/internal/models"
+	"timeTracker/internal/policy"
+)
+
+// maskedSuffix marks the entity tag of a user whose personal data was masked,
+// since that representation differs from the full one of the same version.
+const maskedSuffix = "-masked"
+
+// versionETag returns the strong entity tag of a versioned resource.
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// userETag returns the entity tag of the user as presented to the principal.
+func userETag(p auth.Principal, u models.User) string {
+	if policy.ViewPersonalData(p, policy.Target{UserID: u.ID, Team: u.Team}) {
+		return versionETag(u.Version)
+	}
+	return `"` + strconv.Itoa(u.Version) + maskedSuffix + `"`
+}
+
+// ifMatch returns the version named by the If-Match header of a mutation, or
+// zero when the header is absent or "*" and the write is unconditional.
+func ifMatch(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	// Weak tags never match under the strong comparison If-Match requires.
+	tag, ok := strings.CutPrefix(header, `"`)
+	tag, closed := strings.CutSuffix(tag, `"`)
+	if !ok || !closed || strings.Contains(tag, `"`) {
+		return 0, apperr.PreconditionFailed("invalid_if_match", "If-Match must be a single strong entity tag")
+	}
+	version, err := strconv.Atoi(strings.TrimSuffix(tag, maskedSuffix))
+	if err != nil || version < 1 {
+		return 0, apperr.PreconditionFailed("version_mismatch", "If-Match does not name a version of this resource")
+	}
+
+	return version, nil
+}
+
+// ifMatchReplacement is ifMatch for full replacements, which must not be
+// based on a masked representation: echoing its passport number or address
+// would overwrite the real ones.
+func ifMatchReplacement(r *http.Request) (int, error) {
+	if strings.HasSuffix(strings.TrimSpace(r.Header.Get("If-Match")), maskedSuffix+`"`) {
+		return 0, apperr.PreconditionFailed("masked_representation",
+			"If-Match names a masked representation; a replacement needs the full user")
+	}
+	return ifMatch(r)
+}
+
+// notModified reports whether the If-None-Match header of the request matches
+// etag, in which case it writes a 304 response. Comparison is weak, as RFC
+// 9110 requires for If-None-Match.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeCacheable encodes v as JSON under a weak entity tag derived from the
+// body, so that clients polling a collection can revalidate cheaply. It
+// writes a 304 without a body when the client's copy is current.
+func writeCacheable(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	sum := sha256.Sum256(body)
+	if notModified(w, r, `W/"`+base64.RawURLEncoding.EncodeToString(sum[:16])+`"`) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	return err
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/auth"
+	"timeTracker/internal/models"
+	"timeTracker/internal/repository"
+	"timeTracker/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+// errorCode returns the code of a domain error, or "" for nil.
+func errorCode(t *testing.T, err error) string {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	var appErr *apperr.Error
+	if !errors.As(err, &appErr) {
+		t.Fatalf("got %v, want a domain error", err)
+	}
+	return appErr.Code
+}
+
+func requestWithHeader(name, value string) *http.Request {
+	r := httptest.NewRequest(http.MethodPut, "/users/1", nil)
+	if value != "" {
+		r.Header.Set(name, value)
+	}
+	return r
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header      string
+		version     int
+		code        string
+		replacement string
+	}{
+		{"", 0, "", ""},
+		{"*", 0, "", ""},
+		{`"3"`, 3, "", ""},
+		{` "3" `, 3, "", ""},
+		{`"3-masked"`, 3, "", "masked_representation"},
+		{`W/"3"`, 0, "invalid_if_match", "invalid_if_match"},
+		{`"3", "4"`, 0, "invalid_if_match", "invalid_if_match"},
+		{`3`, 0, "invalid_if_match", "invalid_if_match"},
+		{`"three"`, 0, "version_mismatch", "version_mismatch"},
+		{`"0"`, 0, "version_mismatch", "version_mismatch"},
+	}
+	for _, tt := range tests {
+		version, err := ifMatch(requestWithHeader("If-Match", tt.header))
+		if version != tt.version || errorCode(t, err) != tt.code {
+			t.Errorf("ifMatch(%q) = %d, %v, want %d, %q", tt.header, version, err, tt.version, tt.code)
+		}
+
+		_, err = ifMatchReplacement(requestWithHeader("If-Match", tt.header))
+		if errorCode(t, err) != tt.replacement {
+			t.Errorf("ifMatchReplacement(%q) = %v, want %q", tt.header, err, tt.replacement)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		want   bool
+	}{
+		{"", `"3"`, false},
+		{`"3"`, `"3"`, true},
+		{`"2"`, `"3"`, false},
+		{`"1", "3"`, `"3"`, true},
+		{`"1","2"`, `"3"`, false},
+		{"*", `"3"`, true},
+		{`W/"3"`, `"3"`, true},
+		{`"abc"`, `W/"abc"`, true},
+		{`W/"abc"`, `W/"abd"`, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		got := notModified(w, requestWithHeader("If-None-Match", tt.header), tt.etag)
+		if got != tt.want || w.Header().Get("ETag") != tt.etag {
+			t.Errorf("notModified(%q, %q) = %v with ETag %q", tt.header, tt.etag, got, w.Header().Get("ETag"))
+		}
+		if wantStatus := map[bool]int{true: http.StatusNotModified, false: http.StatusOK}[tt.want]; w.Code != wantStatus {
+			t.Errorf("notModified(%q, %q) wrote %d", tt.header, tt.etag, w.Code)
+		}
+	}
+}
+
+func TestWriteCacheable(t *testing.T) {
+	get := func(ifNoneMatch string, v interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		if err := writeCacheable(w, requestWithHeader("If-None-Match", ifNoneMatch), v); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	first := get("", []int{1, 2})
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) || first.Body.String() != "[1,2]\n" {
+		t.Fatalf("first response %d %q %q", first.Code, etag, first.Body)
+	}
+	if w := get(etag, []int{1, 2}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("revalidation got %d %q", w.Code, w.Body)
+	}
+	if w := get(strings.TrimPrefix(etag, "W/"), []int{1, 2}); w.Code != http.StatusNotModified {
+		t.Errorf("revalidation with the strong form of the tag got %d", w.Code)
+	}
+	if w := get(etag, []int{1, 2, 3}); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("changed collection got %d with ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestDeleteUserIfMatch(t *testing.T) {
+	repo := repository.NewMemoryRepo()
+	user, err := repo.AddUser(context.Background(), 1, models.Actor{RequestID: "test"}, models.User{
+		PassportNumber: "1234 567890", Surname: "Ivanov", Name: "Ivan", Address: "1 Main St", Role: models.RoleEmployee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(service.NewUserService(repo, "", 0), nil, nil, nil, nil, 0,
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	admin := auth.Principal{Subject: "0", OrganizationID: 1, Role: models.RoleAdmin}
+
+	deleteUser := func(ifMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodDelete, "/users/"+strconv.Itoa(user.ID), nil)
+		r.Header.Set("If-Match", ifMatch)
+		r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(user.ID)})
+		r = r.WithContext(auth.WithPrincipal(r.Context(), admin))
+		w := httptest.NewRecorder()
+		h.DeleteUser(w, r)
+		return w
+	}
+
+	if w := deleteUser(versionETag(user.Version + 1)); w.Code != http.StatusPreconditionFailed ||
+		!strings.Contains(w.Body.String(), "version_mismatch") {
+		t.Errorf("stale If-Match got %d %s", w.Code, w.Body)
+	}
+	if w := deleteUser(`W/` + versionETag(user.Version)); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("weak If-Match got %d %s", w.Code, w.Body)
+	}
+	if w := deleteUser(versionETag(user.Version)); w.Code != http.StatusNoContent {
+		t.Errorf("current If-Match got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/models"
+)
+
+const (
+	includeDeletedParam = "include_deleted"
+	maxFilterValues     = 100
+	maxFilterLength     = 100
+)
+
+// usersListParams are the query parameters of the user listing that are not
+// filters.
+var usersListParams = []string{"page", "limit", "after", "before", "sort", includeDeletedParam}
+
+// parseFilters reads every query parameter other than params as a filter,
+// written field=value or field[op]=value. A bare field uses the first
+// operator of its spec and in takes comma-separated values. Filters on
+// fields or with operators missing from fields are reported as errors.
+func parseFilters(values url.Values, params []string, fields map[string]models.FieldSpec) ([]models.Filter, []apperr.FieldError) {
+	var filters []models.Filter
+	var errs []apperr.FieldError
+
+	for key, vs := range values {
+		if slices.Contains(params, key) {
+			continue
+		}
+		name, op, ok := parseFilterKey(key)
+		if !ok {
+			errs = append(errs, apperr.FieldError{Field: key, Code: "invalid_format",
+				Message: "must be written as field or field[operator]"})
+			continue
+		}
+		spec, ok := fields[name]
+		if !ok {
+			errs = append(errs, apperr.FieldError{Field: key, Code: "unknown_field", Message: "cannot be filtered on"})
+			continue
+		}
+		if op == "" {
+			op = spec.Ops[0]
+		}
+		if !slices.Contains(spec.Ops, op) {
+			errs = append(errs, apperr.FieldError{Field: key, Code: "unsupported_operator",
+				Message: "supports only " + joinOps(spec.Ops)})
+			continue
+		}
+
+		for _, v := range vs {
+			if v == "" {
+				continue
+			}
+			filter := models.Filter{Field: name, Op: op, Values: []string{v}}
+			if op == models.FilterIn {
+				filter.Values = strings.Split(v, ",")
+			}
+			if fieldErr, ok := checkFilterValues(key, spec.Type, filter.Values); !ok {
+				errs = append(errs, fieldErr)
+				continue
+			}
+			filters = append(filters, filter)
+		}
+	}
+
+	// Map iteration order is random; sorting keeps the generated SQL the
+	// same for the same request.
+	slices.SortFunc(filters, func(a, b models.Filter) int {
+		return strings.Compare(a.Field+string(a.Op), b.Field+string(b.Op))
+	})
+	return filters, errs
+}
+
+func parseFilterKey(key string) (string, models.FilterOp, bool) {
+	name, rest, bracketed := strings.Cut(key, "[")
+	if !bracketed {
+		return key, "", true
+	}
+	op, ok := strings.CutSuffix(rest, "]")
+	if !ok || op == "" || name == "" {
+		return "", "", false
+	}
+	return name, models.FilterOp(op), true
+}
+
+func checkFilterValues(key string, fieldType models.FieldType, values []string) (apperr.FieldError, bool) {
+	if len(values) > maxFilterValues {
+		return apperr.FieldError{Field: key, Code: "too_long",
+			Message: "must have at most " + strconv.Itoa(maxFilterValues) + " values"}, false
+	}
+	for _, v := range values {
+		switch fieldType {
+		case models.FieldInt:
+			if _, err := strconv.Atoi(v); err != nil {
+				return apperr.FieldError{Field: key, Code: "invalid_format", Message: "must be an integer"}, false
+			}
+		case models.FieldTime:
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				if _, err = time.Parse(dateLayout, v); err != nil {
+					return apperr.FieldError{Field: key, Code: "invalid_format",
+						Message: "must be in RFC 3339 or YYYY-MM-DD format"}, false
+				}
+			}
+		default:
+			if len([]rune(v)) > maxFilterLength {
+				return apperr.FieldError{Field: key, Code: "too_long",
+					Message: "must be at most " + strconv.Itoa(maxFilterLength) + " characters"}, false
+			}
+		}
+	}
+	return apperr.FieldError{}, true
+}
+
+// parseSort reads a comma-separated list of fields, each prefixed with - for
+// descending order, such as surname,-created_at.
+func parseSort(s string, fields map[string]models.FieldSpec) ([]models.SortField, []apperr.FieldError) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var sort []models.SortField
+	var errs []apperr.FieldError
+	seen := make(map[string]bool)
+	for _, term := range strings.Split(s, ",") {
+		field := models.SortField{Field: strings.TrimPrefix(term, "-"), Desc: strings.HasPrefix(term, "-")}
+		if spec, ok := fields[field.Field]; !ok || !spec.Sortable {
+			errs = append(errs, apperr.FieldError{Field: "sort", Code: "unknown_field",
+				Message: "cannot sort by " + strconv.Quote(field.Field)})
+			continue
+		}
+		if seen[field.Field] {
+			errs = append(errs, apperr.FieldError{Field: "sort", Code: "duplicate_field",
+				Message: "sorts by " + field.Field + " more than once"})
+			continue
+		}
+		seen[field.Field] = true
+		sort = append(sort, field)
+	}
+	return sort, errs
+}
+
+func joinOps(ops []models.FilterOp) string {
+	names := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = string(op)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/models"
+)
+
+var testFields = map[string]models.FieldSpec{
+	"id": {Type: models.FieldInt, Sortable: true,
+		Ops: []models.FilterOp{models.FilterEq, models.FilterIn, models.FilterGt}},
+	"surname": {Type: models.FieldText, Sortable: true,
+		Ops: []models.FilterOp{models.FilterPrefix, models.FilterEq, models.FilterContains, models.FilterIn}},
+	"role": {Type: models.FieldText, Ops: []models.FilterOp{models.FilterEq, models.FilterIn}},
+	"created_at": {Type: models.FieldTime, Sortable: true,
+		Ops: []models.FilterOp{models.FilterGte, models.FilterLt}},
+}
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []models.Filter
+	}{
+		{"", nil},
+		{"page=2&limit=10&sort=-id", nil},
+		{"surname=iv", []models.Filter{{Field: "surname", Op: models.FilterPrefix, Values: []string{"iv"}}}},
+		{"surname[eq]=Ivanov", []models.Filter{{Field: "surname", Op: models.FilterEq, Values: []string{"Ivanov"}}}},
+		{"role[in]=admin,manager", []models.Filter{{Field: "role", Op: models.FilterIn, Values: []string{"admin", "manager"}}}},
+		{"id[gt]=5&id=7", []models.Filter{
+			{Field: "id", Op: models.FilterEq, Values: []string{"7"}},
+			{Field: "id", Op: models.FilterGt, Values: []string{"5"}},
+		}},
+		{"created_at[gte]=2024-01-01&created_at[lt]=2024-02-01T00:00:00Z", []models.Filter{
+			{Field: "created_at", Op: models.FilterGte, Values: []string{"2024-01-01"}},
+			{Field: "created_at", Op: models.FilterLt, Values: []string{"2024-02-01T00:00:00Z"}},
+		}},
+		{"surname[contains]=ov&surname[contains]=an", []models.Filter{
+			{Field: "surname", Op: models.FilterContains, Values: []string{"ov"}},
+			{Field: "surname", Op: models.FilterContains, Values: []string{"an"}},
+		}},
+		{"surname=&role[eq]=", nil},
+	}
+
+	for _, tt := range tests {
+		values, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, errs := parseFilters(values, usersListParams, testFields)
+		if len(errs) != 0 {
+			t.Errorf("parseFilters(%q) errors: %+v", tt.query, errs)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFilters(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseFiltersErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		field string
+		code  string
+	}{
+		{"surname[eq=Ivanov", "surname[eq", "invalid_format"},
+		{"surname[]=Ivanov", "surname[]", "invalid_format"},
+		{"[eq]=Ivanov", "[eq]", "invalid_format"},
+		{"address=Main", "address", "unknown_field"},
+		{"role[prefix]=adm", "role[prefix]", "unsupported_operator"},
+		{"id=abc", "id", "invalid_format"},
+		{"id[in]=1,two", "id[in]", "invalid_format"},
+		{"created_at[gte]=yesterday", "created_at[gte]", "invalid_format"},
+		{"surname=" + strings.Repeat("я", maxFilterLength+1), "surname", "too_long"},
+		{"role[in]=" + strings.Repeat("admin,", maxFilterValues) + "admin", "role[in]", "too_long"},
+	}
+
+	for _, tt := range tests {
+		values, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters, errs := parseFilters(values, usersListParams, testFields)
+		if len(filters) != 0 || len(errs) != 1 || errs[0].Field != tt.field || errs[0].Code != tt.code {
+			t.Errorf("parseFilters(%.40q) = %+v, %+v, want one %s error on %s", tt.query, filters, errs, tt.code, tt.field)
+		}
+	}
+}
+
+func TestParseFiltersKeepsValidFilters(t *testing.T) {
+	values := url.Values{"surname": {"iv"}, "unknown": {"x"}}
+	filters, errs := parseFilters(values, usersListParams, testFields)
+	if len(filters) != 1 || filters[0].Field != "surname" {
+		t.Errorf("filters = %+v, want the surname filter", filters)
+	}
+	if len(errs) != 1 || errs[0].Code != "unknown_field" {
+		t.Errorf("errors = %+v, want unknown_field", errs)
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		sort  string
+		want  []models.SortField
+		codes []string
+	}{
+		{"", nil, nil},
+		{"surname", []models.SortField{{Field: "surname"}}, nil},
+		{"-created_at,id", []models.SortField{{Field: "created_at", Desc: true}, {Field: "id"}}, nil},
+		{"role", nil, []string{"unknown_field"}},
+		{"surname,-surname", []models.SortField{{Field: "surname"}}, []string{"duplicate_field"}},
+		{"nope,,id", []models.SortField{{Field: "id"}}, []string{"unknown_field", "unknown_field"}},
+	}
+
+	for _, tt := range tests {
+		got, errs := parseSort(tt.sort, testFields)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSort(%q) = %+v, want %+v", tt.sort, got, tt.want)
+		}
+		var codes []string
+		for _, e := range errs {
+			codes = append(codes, e.Code)
+		}
+		if !slices.Equal(codes, tt.codes) {
+			t.Errorf("parseSort(%q) errors %v, want %v", tt.sort, codes, tt.codes)
+		}
+	}
+}
+
+func TestParseUsersQuery(t *testing.T) {
+	tests := []struct {
+		query  string
+		page   models.Pagination
+		fields []string
+	}{
+		{"", models.Pagination{Page: defaultPage, Limit: defaultPageLimit}, nil},
+		{"page=3&limit=50", models.Pagination{Page: 3, Limit: 50}, nil},
+		{"after=abc", models.Pagination{Limit: defaultPageLimit, After: "abc"}, nil},
+		{"limit=101", models.Pagination{}, []string{"limit"}},
+		{"after=abc&before=def", models.Pagination{}, []string{"before"}},
+		{"page=2&after=abc", models.Pagination{}, []string{"page"}},
+		{"surname[eq]=Ivanov&address=x&sort=-nope", models.Pagination{}, []string{"address", "sort"}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users?"+tt.query, nil)
+		req, err := parseUsersQuery(r)
+		if tt.fields == nil {
+			if err != nil {
+				t.Errorf("parseUsersQuery(%q): %v", tt.query, err)
+			} else if req.pagination() != tt.page {
+				t.Errorf("parseUsersQuery(%q) pagination = %+v, want %+v", tt.query, req.pagination(), tt.page)
+			}
+			continue
+		}
+
+		e, ok := apperr.As(err)
+		if !ok {
+			t.Errorf("parseUsersQuery(%q) error = %v, want a validation error", tt.query, err)
+			continue
+		}
+		var fields []string
+		for _, f := range e.Fields {
+			fields = append(fields, f.Field)
+		}
+		slices.Sort(fields)
+		if !slices.Equal(fields, tt.fields) {
+			t.Errorf("parseUsersQuery(%q) failed on %v, want %v", tt.query, fields, tt.fields)
+		}
+	}
+}
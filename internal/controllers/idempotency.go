@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/auth"
+	"timeTracker/internal/requestid"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// ReplayedHeader is set on responses replayed for a retried request.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotent makes POST requests carrying an Idempotency-Key safe to retry:
+// the first response to a key is stored and sent again for retries of the
+// same request instead of running it twice. Server errors are not stored, so
+// that a retry gets another chance.
+func (h *Handler) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const op = "controller idempotent: "
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger := h.logger.With("operation: ", op, "idempotencyKey", key)
+		if len(key) > maxIdempotencyKeyLength {
+			h.fail(w, r, logger, apperr.Validation("invalid idempotency key", apperr.FieldError{
+				Field:   IdempotencyKeyHeader,
+				Code:    "max",
+				Message: "must be at most 255 characters long",
+			}))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyProblem(w, r, logger, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		principal, _ := auth.PrincipalFromContext(r.Context())
+		record, replay, err := h.idempotencyService.Begin(r.Context(), principal.OrganizationID, principal.Subject, key,
+			fingerprint(r, body))
+		if err != nil {
+			h.fail(w, r, logger, err)
+			return
+		}
+		if replay {
+			for name, values := range record.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(ReplayedHeader, "true")
+			w.WriteHeader(record.Status)
+			if _, err = w.Write(record.Body); err != nil {
+				logger.Error(err.Error())
+			}
+			logger.Debug("replayed response")
+			return
+		}
+
+		// The outcome is stored even if the client has gone away meanwhile,
+		// since that is when it is most likely to retry.
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			if !completed {
+				if err := h.idempotencyService.Release(context.WithoutCancel(r.Context()), record); err != nil {
+					logger.Error(err.Error())
+				}
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		record.Status = recorder.status
+		record.Header = storedHeader(w.Header())
+		record.Body = recorder.body.Bytes()
+		if err = h.idempotencyService.Complete(context.WithoutCancel(r.Context()), record); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		completed = true
+	})
+}
+
+// fingerprint identifies a request by its method, target and body.
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// storedHeader copies the response header to replay, leaving out the request
+// ID so that every retry can be told apart in the logs.
+func storedHeader(header http.Header) http.Header {
+	stored := header.Clone()
+	stored.Del(requestid.Header)
+	return stored
+}
+
+// responseRecorder passes a response through while keeping a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"timeTracker/internal/auth"
+	"timeTracker/internal/repository"
+	"timeTracker/internal/requestid"
+	"timeTracker/internal/service"
+)
+
+// countingHandler answers every request with 201 and the number of requests
+// it has handled so far, or with status if it is set.
+type countingHandler struct {
+	calls  int
+	status int
+	during func()
+}
+
+func (c *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.calls++
+	if c.during != nil {
+		c.during()
+	}
+	status := c.status
+	if status == 0 {
+		status = http.StatusCreated
+	}
+	w.Header().Set(requestid.Header, "request-"+strconv.Itoa(c.calls))
+	w.Header().Set("Location", "/users/"+strconv.Itoa(c.calls))
+	w.WriteHeader(status)
+	io.WriteString(w, `{"call":`+strconv.Itoa(c.calls)+`}`)
+}
+
+func newIdempotentHandler(next http.Handler) http.Handler {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := NewHandler(nil, nil, nil, service.NewIdempotencyService(repository.NewMemoryRepo(), 0), nil, 0, logger)
+	return h.idempotent(next)
+}
+
+func postWithKey(t *testing.T, handler http.Handler, subject, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+	r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{OrganizationID: 1, Subject: subject}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	next := &countingHandler{}
+	handler := newIdempotentHandler(next)
+
+	first := postWithKey(t, handler, "user:1", "key-1", `{"surname":"Ivanov"}`)
+	if first.Code != http.StatusCreated || first.Header().Get(ReplayedHeader) != "" {
+		t.Fatalf("first request: %d %v", first.Code, first.Header())
+	}
+
+	retry := postWithKey(t, handler, "user:1", "key-1", `{"surname":"Ivanov"}`)
+	if next.calls != 1 {
+		t.Fatalf("retry ran the request again: %d calls", next.calls)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() ||
+		retry.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("replay %d %s %v differs from %d %s %v", retry.Code, retry.Body, retry.Header(),
+			first.Code, first.Body, first.Header())
+	}
+	if retry.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("replay lacks the %s header", ReplayedHeader)
+	}
+	if retry.Header().Get(requestid.Header) != "" {
+		t.Errorf("replay repeats the request ID %q", retry.Header().Get(requestid.Header))
+	}
+
+	// Keys belong to the subject that used them, and requests without one
+	// are never replayed.
+	if w := postWithKey(t, handler, "user:2", "key-1", `{"surname":"Ivanov"}`); w.Code != http.StatusCreated ||
+		next.calls != 2 {
+		t.Errorf("another subject's request got %d after %d calls", w.Code, next.calls)
+	}
+	postWithKey(t, handler, "user:1", "", `{"surname":"Ivanov"}`)
+	postWithKey(t, handler, "user:1", "", `{"surname":"Ivanov"}`)
+	if next.calls != 4 {
+		t.Errorf("requests without a key ran %d times in total, want 4", next.calls)
+	}
+}
+
+func TestIdempotentConflicts(t *testing.T) {
+	next := &countingHandler{}
+	handler := newIdempotentHandler(next)
+
+	postWithKey(t, handler, "user:1", "key-1", `{"surname":"Ivanov"}`)
+	reused := postWithKey(t, handler, "user:1", "key-1", `{"surname":"Petrov"}`)
+	if reused.Code != http.StatusUnprocessableEntity || !strings.Contains(reused.Body.String(), "idempotency_key_reused") {
+		t.Errorf("reusing a key for another body: %d %s", reused.Code, reused.Body)
+	}
+
+	var inFlight *httptest.ResponseRecorder
+	next.during = func() {
+		next.during = nil
+		inFlight = postWithKey(t, handler, "user:1", "key-2", `{}`)
+	}
+	postWithKey(t, handler, "user:1", "key-2", `{}`)
+	if inFlight == nil || inFlight.Code != http.StatusConflict {
+		t.Errorf("retry during the request got %v, want 409", inFlight)
+	}
+	if next.calls != 2 {
+		t.Errorf("handler ran %d times, want 2", next.calls)
+	}
+
+	long := postWithKey(t, handler, "user:1", strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`)
+	if long.Code != http.StatusUnprocessableEntity || next.calls != 2 {
+		t.Errorf("overlong key: %d after %d calls", long.Code, next.calls)
+	}
+}
+
+func TestIdempotentServerErrorsAreRetried(t *testing.T) {
+	next := &countingHandler{status: http.StatusServiceUnavailable}
+	handler := newIdempotentHandler(next)
+
+	if w := postWithKey(t, handler, "user:1", "key-1", `{}`); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first request got %d", w.Code)
+	}
+	next.status = 0
+	retry := postWithKey(t, handler, "user:1", "key-1", `{}`)
+	if retry.Code != http.StatusCreated || retry.Header().Get(ReplayedHeader) != "" || next.calls != 2 {
+		t.Errorf("retry after a server error got %d, replayed %q, after %d calls",
+			retry.Code, retry.Header().Get(ReplayedHeader), next.calls)
+	}
+
+	// Client errors are final and replayed like successes.
+	next.status = http.StatusBadRequest
+	postWithKey(t, handler, "user:1", "key-2", `{}`)
+	next.status = 0
+	if w := postWithKey(t, handler, "user:1", "key-2", `{}`); w.Code != http.StatusBadRequest || next.calls != 3 {
+		t.Errorf("retry after a client error got %d after %d calls", w.Code, next.calls)
+	}
+}
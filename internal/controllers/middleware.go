@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"timeTracker/internal/auth"
+	"timeTracker/internal/models"
+	"timeTracker/internal/pii"
+	"timeTracker/internal/policy"
+	"timeTracker/internal/requestid"
+)
+
+// requestID tags every request with an ID, reusing the one set by a proxy if
+// present, and echoes it back so clients can correlate audit records.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if id == "" || len(id) > 100 {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.WithID(r.Context(), id)))
+	})
+}
+
+// extendWriteDeadline gives a response that waits on a bulk database
+// operation bulkWriteTimeout to be written instead of the server's write
+// timeout, which is sized for ordinary requests and would cut it off.
+func (h *Handler) extendWriteDeadline(w http.ResponseWriter, logger *slog.Logger) {
+	if h.bulkWriteTimeout == 0 {
+		return
+	}
+	var deadline time.Time
+	if h.bulkWriteTimeout > 0 {
+		deadline = time.Now().Add(h.bulkWriteTimeout)
+	}
+	if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+		logger.Warn("cannot extend the write deadline: " + err.Error())
+	}
+}
+
+func actor(r *http.Request, p auth.Principal) models.Actor {
+	return models.Actor{UserID: p.UserID, RequestID: requestid.FromContext(r.Context())}
+}
+
+// presentUser masks the personal data of a user unless the principal may see
+// it in full.
+func presentUser(p auth.Principal, u models.User) models.User {
+	if policy.ViewPersonalData(p, policy.Target{UserID: u.ID, Team: u.Team}) {
+		return u
+	}
+	return pii.MaskUser(u)
+}
+
+// scopeFilters turns the policy's user scope of the principal into listing
+// filters.
+func scopeFilters(p auth.Principal) []models.Filter {
+	var filters []models.Filter
+	for field, value := range policy.UserScope(p) {
+		filters = append(filters, models.Filter{Field: field, Op: models.FilterEq, Values: []string{value}})
+	}
+	return filters
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExtendWriteDeadline(t *testing.T) {
+	const writeTimeout = 100 * time.Millisecond
+
+	for _, tt := range []struct {
+		name             string
+		bulkWriteTimeout time.Duration
+		wantBody         bool
+	}{
+		{"server timeout", 0, false},
+		{"extended", 2 * time.Second, true},
+		{"lifted", -1, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(nil, nil, nil, nil, nil, tt.bulkWriteTimeout, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h.extendWriteDeadline(w, h.logger)
+				time.Sleep(3 * writeTimeout)
+				io.WriteString(w, "exported")
+			})
+			server := httptest.NewUnstartedServer(instrument(slow))
+			server.Config.WriteTimeout = writeTimeout
+			server.Start()
+			defer server.Close()
+
+			body := ""
+			resp, err := http.Get(server.URL)
+			if err == nil {
+				b, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				body = string(b)
+			}
+			if got := body == "exported"; got != tt.wantBody {
+				t.Errorf("response body %q, error %v", body, err)
+			}
+		})
+	}
+}
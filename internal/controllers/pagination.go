@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"timeTracker/internal/models"
+)
+
+const (
+	defaultPage      = 1
+	defaultPageLimit = 20
+
+	TotalCountHeader = "X-Total-Count"
+)
+
+// writePageLinks sets the total count and the RFC 8288 Link header pointing
+// at the first, previous, next and, when paging by number, last pages. The
+// next link always carries a cursor, so following it from a numbered page
+// continues with keyset paging.
+func writePageLinks(w http.ResponseWriter, r *http.Request, page models.Pagination, total int, next, prev string) {
+	w.Header().Set(TotalCountHeader, strconv.Itoa(total))
+
+	var links []string
+	link := func(rel string, params map[string]string) {
+		q := r.URL.Query()
+		for _, name := range []string{"page", "after", "before"} {
+			q.Del(name)
+		}
+		q.Set("limit", strconv.Itoa(page.Limit))
+		for name, value := range params {
+			q.Set(name, value)
+		}
+		u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel))
+	}
+
+	if page.After != "" || page.Before != "" {
+		link("first", nil)
+		if prev != "" {
+			link("prev", map[string]string{"before": prev})
+		}
+		if next != "" {
+			link("next", map[string]string{"after": next})
+		}
+	} else {
+		last := (total + page.Limit - 1) / page.Limit
+		if last < 1 {
+			last = 1
+		}
+		link("first", map[string]string{"page": "1"})
+		if page.Page > 1 {
+			link("prev", map[string]string{"page": strconv.Itoa(min(page.Page-1, last))})
+		}
+		if next != "" {
+			link("next", map[string]string{"after": next})
+		}
+		link("last", map[string]string{"page": strconv.Itoa(last)})
+	}
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
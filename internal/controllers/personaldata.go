@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"timeTracker/internal/pii"
+	"timeTracker/internal/policy"
+
+	"github.com/gorilla/mux"
+)
+
+// ExportPersonalData godoc
+// @Summary Export personal data
+// @Description Export everything stored about a user: profile, tasks, time entries, audit events and data requests
+// @Tags personal-data
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.PersonalData
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Router /users/{id}/personal-data [get]
+func (h *Handler) ExportPersonalData(w http.ResponseWriter, r *http.Request) {
+	const op = "controller ExportPersonalData: "
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.logger.With("operation: ", op).Info(err.Error())
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
+		return
+	}
+
+	principal, ok := h.authorize(w, r, policy.ExportData, policy.Target{UserID: id})
+	if !ok {
+		return
+	}
+
+	h.extendWriteDeadline(w, h.logger.With("operation: ", op))
+	data, err := h.userService.ExportPersonalData(r.Context(), principal.OrganizationID, actor(r, principal), id)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op, "userID", id), err)
+		return
+	}
+
+	if !policy.ViewPersonalData(principal, policy.Target{UserID: id}) {
+		data.User = pii.MaskUser(data.User)
+		for i := range data.AuditEvents {
+			data.AuditEvents[i].Before = pii.MaskSnapshot(data.AuditEvents[i].Before)
+			data.AuditEvents[i].After = pii.MaskSnapshot(data.AuditEvents[i].After)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-personal-data.json"`, id))
+
+	if err = json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.With("operation: ", op, "userID", id).Error(err.Error())
+		return
+	}
+	h.logger.With("userID", id).Info("exported personal data")
+}
+
+// ErasePersonalData godoc
+// @Summary Erase personal data
+// @Description Anonymise the personal fields of a user, including past audit snapshots, while keeping tasks and time entries for accounting
+// @Tags personal-data
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.DataRequest
+// @Failure 400 {object} Problem "Bad Request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Router /users/{id}/personal-data [delete]
+func (h *Handler) ErasePersonalData(w http.ResponseWriter, r *http.Request) {
+	const op = "controller ErasePersonalData: "
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.logger.With("operation: ", op).Info(err.Error())
+		writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
+		return
+	}
+
+	principal, ok := h.authorize(w, r, policy.EraseData, policy.Target{UserID: id})
+	if !ok {
+		return
+	}
+
+	h.extendWriteDeadline(w, h.logger.With("operation: ", op))
+	request, err := h.userService.ErasePersonalData(r.Context(), principal.OrganizationID, actor(r, principal), id)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op, "userID", id), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(request); err != nil {
+		h.logger.With("operation: ", op, "userID", id).Error(err.Error())
+		return
+	}
+	h.logger.With("userID", id, "dataRequestID", request.ID).Info("erased personal data")
+}
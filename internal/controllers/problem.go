@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/auth"
+	"timeTracker/internal/repository"
+	"timeTracker/internal/requestid"
+)
+
+const (
+	RequestTooLargeMessage = "request body too large"
+	TimeoutMessage         = "the operation timed out"
+
+	ProblemContentType = "application/problem+json"
+	problemTypeBase    = "https://time-tracker/problems/"
+)
+
+// Problem is an RFC 7807 problem details response body.
+type Problem struct {
+	Type      string              `json:"type" example:"https://time-tracker/problems/user_not_found"`
+	Title     string              `json:"title" example:"Not Found"`
+	Status    int                 `json:"status" example:"404"`
+	Detail    string              `json:"detail,omitempty" example:"user not found"`
+	Instance  string              `json:"instance,omitempty" example:"/users/42"`
+	Code      string              `json:"code" example:"user_not_found"`
+	RequestID string              `json:"requestId,omitempty" example:"4f9c1d1e6b2a4c7e"`
+	Errors    []apperr.FieldError `json:"errors,omitempty"`
+}
+
+// statusCodes are the default problem codes of responses that do not stem
+// from a domain error.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+// kindStatuses maps domain error kinds to HTTP statuses.
+var kindStatuses = map[apperr.Kind]int{
+	apperr.KindInvalid:      http.StatusBadRequest,
+	apperr.KindNotFound:     http.StatusNotFound,
+	apperr.KindConflict:     http.StatusConflict,
+	apperr.KindPrecondition: http.StatusPreconditionFailed,
+	apperr.KindValidation:   http.StatusUnprocessableEntity,
+	apperr.KindUpstream:     http.StatusBadGateway,
+}
+
+// writeBodyProblem answers a request whose body could not be read, telling a
+// body over the server's size limit apart from a malformed one.
+func writeBodyProblem(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error) {
+	logger.Info(err.Error())
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, RequestTooLargeMessage)
+		return
+	}
+	writeProblem(w, r, http.StatusBadRequest, BadRequestMessage)
+}
+
+// writeProblem writes a problem response with the default code of status.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string, fields ...apperr.FieldError) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = "error"
+	}
+	writeProblemCode(w, r, status, code, detail, fields...)
+}
+
+func writeProblemCode(w http.ResponseWriter, r *http.Request, status int, code, detail string,
+	fields ...apperr.FieldError) {
+	problem := Problem{
+		Type:      problemTypeBase + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: requestid.FromContext(r.Context()),
+		Errors:    fields,
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
+// fail maps an error returned by a service to a problem response. Domain
+// errors are the client's fault and logged at info level; anything else is
+// logged as an error and hidden behind a 500.
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error) {
+	if e, ok := apperr.As(err); ok {
+		status, known := kindStatuses[e.Kind]
+		if known && status < http.StatusInternalServerError {
+			logger.Info(err.Error())
+			writeProblemCode(w, r, status, e.Code, e.Message, e.Fields...)
+			return
+		}
+		if known {
+			logger.Error(err.Error())
+			writeProblemCode(w, r, status, e.Code, e.Message)
+			return
+		}
+	}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		logger.Info(err.Error())
+		writeProblem(w, r, http.StatusNotFound, NotFoundMessage)
+	case errors.Is(err, auth.ErrUnauthenticated):
+		logger.Info(err.Error())
+		writeProblem(w, r, http.StatusUnauthorized, UnauthorizedMessage)
+	case repository.IsTimeout(err):
+		logger.Error(err.Error())
+		writeProblem(w, r, http.StatusGatewayTimeout, TimeoutMessage)
+	default:
+		logger.Error(err.Error())
+		writeProblem(w, r, http.StatusInternalServerError, InternalServerErrorMessage)
+	}
+}
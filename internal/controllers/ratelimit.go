@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"timeTracker/internal/auth"
+	"timeTracker/internal/ratelimit"
+
+	"github.com/gorilla/mux"
+)
+
+const TooManyRequestsMessage = "too many requests"
+
+// exportRoutes are the route templates limited as exports rather than reads,
+// because each of them collects a lot of data.
+var exportRoutes = map[string]bool{
+	"/users/{id}/personal-data": true,
+}
+
+// limitAddresses limits the requests of every remote address before they
+// are authenticated, so that requests with bad credentials, and the lookups
+// they cost, are throttled as well.
+func (h *Handler) limitAddresses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.take(w, r, ratelimit.ClassAddress, addressKey(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// rateLimit limits the requests of every authenticated client per route
+// class.
+func (h *Handler) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.take(w, r, routeClass(r), clientKey(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// take takes a token for the client from the bucket of the class and tells
+// whether the request may go on. Requests over the limit get a 429 with
+// Retry-After. Should the limiter's store fail, requests are let through
+// rather than taking the API down with it.
+func (h *Handler) take(w http.ResponseWriter, r *http.Request, class ratelimit.Class, client string) bool {
+	const op = "controller rateLimit: "
+	if h.limiter == nil {
+		return true
+	}
+
+	result, err := h.limiter.Take(r.Context(), class, client)
+	if err != nil {
+		h.logger.With("operation: ", op).Error(err.Error())
+		return true
+	}
+	if result.Limit.Burst == 0 {
+		return true
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit.Burst, ceilSeconds(result.Limit.Window())))
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit.Burst))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	if !result.Allowed {
+		header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		h.logger.With("client", client, "class", class).Info("rate limited")
+		writeProblem(w, r, http.StatusTooManyRequests, TooManyRequestsMessage)
+		return false
+	}
+	return true
+}
+
+// routeClass tells which limit applies to the matched route.
+func routeClass(r *http.Request) ratelimit.Class {
+	if route := mux.CurrentRoute(r); route != nil && r.Method == http.MethodGet {
+		if template, err := route.GetPathTemplate(); err == nil && exportRoutes[template] {
+			return ratelimit.ClassExport
+		}
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ratelimit.ClassRead
+	}
+	return ratelimit.ClassWrite
+}
+
+// clientKey identifies the principal a request is counted against: the API
+// key it was made with or the subject of its token. Requests without a
+// principal are counted against their remote address.
+func clientKey(r *http.Request) string {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	switch {
+	case ok && principal.Method == auth.MethodAPIKey:
+		return "key:" + strconv.Itoa(principal.APIKeyID)
+	case ok && principal.Subject != "":
+		return "sub:" + principal.Subject
+	}
+	return addressKey(r)
+}
+
+// addressKey identifies the remote address of a request.
+func addressKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"timeTracker/internal/auth"
+	"timeTracker/internal/ratelimit"
+)
+
+func TestLimitAddresses(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[ratelimit.Class]ratelimit.Limit{
+		ratelimit.ClassAddress: ratelimit.PerMinute(1, 2),
+	})
+	h := NewHandler(nil, nil, nil, nil, limiter, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// The limit applies before authentication, so it is the next handler
+	// that would reject the bad credentials.
+	calls := 0
+	handler := h.limitAddresses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Authorization", "Bearer guess")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request("192.0.2.1:1234"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d got %d", i+1, w.Code)
+		}
+	}
+	w := request("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || calls != 2 {
+		t.Errorf("request over the limit got %d, Retry-After %q, after %d calls", w.Code,
+			w.Header().Get("Retry-After"), calls)
+	}
+	if w = request("192.0.2.2:1234"); w.Code != http.StatusUnauthorized {
+		t.Errorf("another address got %d", w.Code)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		want      string
+	}{
+		{"api key", &auth.Principal{Method: auth.MethodAPIKey, APIKeyID: 7, Subject: "user:1"}, "key:7"},
+		{"token", &auth.Principal{Method: auth.MethodJWT, Subject: "user:1"}, "sub:user:1"},
+		{"anonymous", nil, "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		if tt.principal != nil {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), *tt.principal))
+		}
+		if got := clientKey(r); got != tt.want {
+			t.Errorf("%s: clientKey = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/models"
+	"timeTracker/internal/pii"
+	"timeTracker/internal/repository"
+	"timeTracker/internal/validate"
+)
+
+const dateLayout = "2006-01-02"
+
+func init() {
+	validate.Register("role", "invalid_value", "must be one of admin, manager, employee",
+		func(v reflect.Value, _ string) bool { return models.Role(v.String()).Valid() })
+	validate.Register("privilege", "invalid_value", "must only contain known privileges",
+		func(v reflect.Value, _ string) bool { return models.ValidPrivilege(v.String()) })
+	validate.Register("unmasked", "masked_value", "must be the full value rather than its masked form",
+		func(v reflect.Value, _ string) bool { return !pii.IsMaskedAddress(v.String()) })
+}
+
+type createUserRequest struct {
+	PassportNumber string      `json:"passportNumber" validate:"required,passport" example:"1234 567890"`
+	Role           models.Role `json:"role" validate:"omitempty,role" example:"employee"`
+	Team           string      `json:"team" validate:"max=100" example:"backend"`
+	Privileges     []string    `json:"privileges" validate:"privilege"`
+}
+
+func (req createUserRequest) user() models.User {
+	return models.User{PassportNumber: req.PassportNumber, Role: req.Role, Team: req.Team, Privileges: req.Privileges}
+}
+
+// replaceUserRequest is the complete editable representation of a user. PUT
+// replaces a user with it and PATCH merges a patch into it.
+type replaceUserRequest struct {
+	PassportNumber string      `json:"passportNumber" validate:"required,passport" example:"1234 567890"`
+	Surname        string      `json:"surname" validate:"required,max=100" example:"Иванов"`
+	Name           string      `json:"name" validate:"required,max=100" example:"Иван"`
+	Patronymic     string      `json:"patronymic,omitempty" validate:"max=100" example:"Иванович"`
+	Address        string      `json:"address" validate:"required,unmasked" example:"г. Москва, ул. Ленина, д. 5, кв. 1"`
+	Role           models.Role `json:"role" validate:"required,role" example:"employee"`
+	Team           string      `json:"team,omitempty" validate:"max=100" example:"backend"`
+	Privileges     []string    `json:"privileges,omitempty" validate:"privilege"`
+}
+
+func userDocument(u models.User) replaceUserRequest {
+	return replaceUserRequest{PassportNumber: u.PassportNumber, Surname: u.Surname, Name: u.Name,
+		Patronymic: u.Patronymic, Address: u.Address, Role: u.Role, Team: u.Team, Privileges: u.Privileges}
+}
+
+func (req replaceUserRequest) user(id int) models.User {
+	return models.User{ID: id, PassportNumber: req.PassportNumber, Surname: req.Surname, Name: req.Name,
+		Patronymic: req.Patronymic, Address: req.Address, Role: req.Role, Team: req.Team, Privileges: req.Privileges}
+}
+
+type usersQuery struct {
+	Page           int    `json:"page" validate:"omitempty,min=1"`
+	Limit          int    `json:"limit" validate:"omitempty,min=1,max=100"`
+	After          string `json:"after" validate:"max=200"`
+	Before         string `json:"before" validate:"max=200"`
+	Sort           string `json:"sort" validate:"max=200"`
+	IncludeDeleted bool   `json:"include_deleted"`
+	filters        []models.Filter
+	sort           []models.SortField
+}
+
+func parseUsersQuery(r *http.Request) (usersQuery, error) {
+	q := newQuery(r)
+	req := usersQuery{
+		Page:           q.int("page"),
+		Limit:          q.int("limit"),
+		After:          q.string("after"),
+		Before:         q.string("before"),
+		Sort:           q.string("sort"),
+		IncludeDeleted: q.string(includeDeletedParam) == "true",
+	}
+	if req.After != "" && req.Before != "" {
+		q.errs = append(q.errs, apperr.FieldError{Field: "before", Code: "conflicting",
+			Message: "cannot be combined with after"})
+	}
+	if req.Page != 0 && (req.After != "" || req.Before != "") {
+		q.errs = append(q.errs, apperr.FieldError{Field: "page", Code: "conflicting",
+			Message: "cannot be combined with a cursor"})
+	}
+
+	fields := repository.UserFields()
+	var errs []apperr.FieldError
+	req.filters, errs = parseFilters(q.values, usersListParams, fields)
+	q.errs = append(q.errs, errs...)
+	req.sort, errs = parseSort(req.Sort, fields)
+	q.errs = append(q.errs, errs...)
+
+	if err := validate.Struct(&req, q.errs...); err != nil {
+		return req, err
+	}
+
+	if req.Limit == 0 {
+		req.Limit = defaultPageLimit
+	}
+	if req.Page == 0 && req.After == "" && req.Before == "" {
+		req.Page = defaultPage
+	}
+	return req, nil
+}
+
+func (req usersQuery) pagination() models.Pagination {
+	return models.Pagination{Page: req.Page, Limit: req.Limit, After: req.After, Before: req.Before}
+}
+
+func (req usersQuery) query() models.UserQuery {
+	return models.UserQuery{Filters: req.filters, Sort: req.sort, IncludeDeleted: req.IncludeDeleted}
+}
+
+type workloadQuery struct {
+	Start time.Time `json:"start" validate:"required"`
+	End   time.Time `json:"end" validate:"required,gtefield=Start"`
+}
+
+func parseWorkloadQuery(r *http.Request) (workloadQuery, error) {
+	q := newQuery(r)
+	req := workloadQuery{
+		Start: q.time("start", dateLayout),
+		End:   q.time("end", dateLayout),
+	}
+
+	return req, validate.Struct(&req, q.errs...)
+}
+
+type auditQuery struct {
+	Page       int       `json:"page" validate:"omitempty,min=1"`
+	Limit      int       `json:"limit" validate:"omitempty,min=1,max=100"`
+	ActorID    int       `json:"actor_id" validate:"min=0"`
+	Action     string    `json:"action" validate:"max=100"`
+	EntityType string    `json:"entity_type" validate:"max=100"`
+	EntityID   int       `json:"entity_id" validate:"min=0"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to" validate:"gtefield=From"`
+}
+
+func parseAuditQuery(r *http.Request) (models.AuditFilter, error) {
+	q := newQuery(r)
+	req := auditQuery{
+		Page:       q.int("page"),
+		Limit:      q.int("limit"),
+		ActorID:    q.int("actor_id"),
+		Action:     q.string("action"),
+		EntityType: q.string("entity_type"),
+		EntityID:   q.int("entity_id"),
+		From:       q.time("from", time.RFC3339),
+		To:         q.time("to", time.RFC3339),
+	}
+
+	if req.Page == 0 {
+		req.Page = defaultPage
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultPageLimit
+	}
+
+	filter := models.AuditFilter{ActorID: req.ActorID, Action: req.Action, EntityType: req.EntityType,
+		EntityID: req.EntityID, From: req.From, To: req.To, Page: req.Page, Limit: req.Limit}
+
+	return filter, validate.Struct(&req, q.errs...)
+}
+
+// query reads typed query parameters. Malformed values are recorded as field
+// errors, so that they are reported together with the validation failures.
+type query struct {
+	values url.Values
+	errs   []apperr.FieldError
+}
+
+func newQuery(r *http.Request) *query {
+	return &query{values: r.URL.Query()}
+}
+
+func (q *query) string(name string) string {
+	return q.values.Get(name)
+}
+
+func (q *query) int(name string) int {
+	v := q.values.Get(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		q.errs = append(q.errs, apperr.FieldError{Field: name, Code: "invalid_format", Message: "must be an integer"})
+	}
+	return n
+}
+
+func (q *query) time(name, layout string) time.Time {
+	v := q.values.Get(name)
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(layout, v)
+	if err != nil {
+		format := "YYYY-MM-DD"
+		if layout == time.RFC3339 {
+			format = "RFC 3339"
+		}
+		q.errs = append(q.errs, apperr.FieldError{Field: name, Code: "invalid_format", Message: "must be in " + format + " format"})
+	}
+	return t
+}
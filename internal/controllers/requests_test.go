@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/validate"
+)
+
+func TestRequestTags(t *testing.T) {
+	for _, req := range []interface{}{
+		&createUserRequest{},
+		&replaceUserRequest{},
+		&usersQuery{},
+		&workloadQuery{},
+		&auditQuery{},
+		&searchQuery{},
+		&issueAPIKeyRequest{},
+	} {
+		if err := validate.CheckTags(req); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRequestRules(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		req   interface{}
+		field string
+		code  string
+	}{
+		{"valid user", &createUserRequest{PassportNumber: "1234 567890", Role: "employee"}, "", ""},
+		{"missing passport", &createUserRequest{}, "passportNumber", "required"},
+		{"malformed passport", &createUserRequest{PassportNumber: "1234-567890"}, "passportNumber", "invalid_format"},
+		{"unknown role", &createUserRequest{PassportNumber: "1234 567890", Role: "root"}, "role", "invalid_value"},
+		{"unknown privilege", &createUserRequest{PassportNumber: "1234 567890", Privileges: []string{"everything"}},
+			"privileges", "invalid_value"},
+		{"long surname", &replaceUserRequest{PassportNumber: "1234 567890", Surname: strings.Repeat("a", 101),
+			Name: "Ivan", Address: "1 Main St", Role: "employee"}, "surname", "too_long"},
+		{"page below one", &usersQuery{Page: -1}, "page", "too_small"},
+		{"limit above 100", &usersQuery{Limit: 101}, "limit", "too_large"},
+		{"limit at 100", &usersQuery{Page: 1, Limit: 100}, "", ""},
+		{"search limit above 50", &searchQuery{Q: "ivan", Limit: 51}, "limit", "too_large"},
+		{"short search", &searchQuery{Q: "i"}, "q", "too_short"},
+		{"end before start", &workloadQuery{Start: start, End: start.AddDate(0, 0, -1)}, "end", "out_of_order"},
+		{"same day", &workloadQuery{Start: start, End: start}, "", ""},
+		{"audit to before from", &auditQuery{From: start, To: start.Add(-time.Second)}, "to", "out_of_order"},
+	}
+	for _, tt := range tests {
+		err := validate.Struct(tt.req)
+		if tt.field == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		var appErr *apperr.Error
+		if !errors.As(err, &appErr) || len(appErr.Fields) == 0 ||
+			appErr.Fields[0].Field != tt.field || appErr.Fields[0].Code != tt.code {
+			t.Errorf("%s: got %v, want %s %s", tt.name, err, tt.field, tt.code)
+		}
+	}
+}
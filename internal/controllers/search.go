@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timeTracker/internal/models"
+	"timeTracker/internal/policy"
+	"timeTracker/internal/validate"
+)
+
+const defaultSearchLimit = 10
+
+type searchQuery struct {
+	Q     string `json:"q" validate:"required,min=2,max=100"`
+	Limit int    `json:"limit" validate:"omitempty,min=1,max=50"`
+}
+
+// Search godoc
+// @Summary Search users and tasks
+// @Description Find users by surname, name or patronymic and tasks by description, matching whole words as well as misspellings.
+// @Description Callers with the personal_data:view privilege also find users whose address contains every word of q; addresses are encrypted at rest and only match whole words.
+// @Description Results are ranked best first and scoped like the user list. The field of a hit tells whether a user matched by name or by address.
+// @Description Highlights are the HTML-escaped text with matching words wrapped in <mark> tags.
+// @Tags search
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Text to search for"
+// @Param limit query int false "Maximum number of users and of tasks returned, at most 50" default(10)
+// @Success 200 {object} models.SearchResults
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Forbidden"
+// @Failure 422 {object} Problem "Validation failed"
+// @Failure 429 {object} Problem "Too Many Requests"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 504 {object} Problem "Gateway Timeout"
+// @Router /search [get]
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	const op = "controller Search: "
+	q := newQuery(r)
+	req := searchQuery{Q: q.string("q"), Limit: q.int("limit")}
+	if err := validate.Struct(&req, q.errs...); err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultSearchLimit
+	}
+
+	principal, ok := h.authorize(w, r, policy.ListUsers, policy.Target{})
+	if !ok {
+		return
+	}
+
+	scope := models.UserQuery{Filters: scopeFilters(principal)}
+	results, err := h.userService.Search(r.Context(), principal.OrganizationID, req.Q, scope,
+		policy.SearchPersonalData(principal), req.Limit)
+	if err != nil {
+		h.fail(w, r, h.logger.With("operation: ", op), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(results); err != nil {
+		h.logger.With("operation: ", op).Error(err.Error())
+		return
+	}
+	h.logger.With("users", len(results.Users), "tasks", len(results.Tasks)).Debug("searched")
+}
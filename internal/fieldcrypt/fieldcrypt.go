@@ -0,0 +1,203 @@
+// Package fieldcrypt implements envelope encryption of individual column
+// values. Every value is sealed with its own random data key, which in turn
+// is wrapped with one of the configured key-encryption keys, so rotating a
+// key only requires re-wrapping data keys.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks encrypted values: enc.<key id>.<wrapped data key>.<ciphertext>.
+const prefix = "enc."
+
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+type Keyring struct {
+	keys     map[string]cipher.AEAD
+	activeID string
+	indexKey []byte
+}
+
+// NewKeyring parses keys given as comma-separated "id:base64" pairs of 32-byte
+// AES keys. New values are encrypted with activeID; the others are only used
+// to decrypt. indexKey is the base64 HMAC key for blind indexes.
+func NewKeyring(keys, activeID, indexKey string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD), activeID: activeID}
+
+	for _, pair := range strings.Split(keys, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" || strings.Contains(id, ".") {
+			return nil, fmt.Errorf("invalid encryption key entry %q, want id:base64", pair)
+		}
+		aead, err := newAEAD(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+
+	if _, ok := k.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not configured", activeID)
+	}
+
+	var err error
+	if k.indexKey, err = base64.StdEncoding.DecodeString(indexKey); err != nil || len(k.indexKey) < 32 {
+		return nil, errors.New("blind index key must be at least 32 base64-encoded bytes")
+	}
+
+	return k, nil
+}
+
+func newAEAD(encoded string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt seals plaintext under a fresh data key wrapped with the active key.
+// Empty values are left as they are.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(k.keys[k.activeID], dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.activeID + "." + base64.RawStdEncoding.EncodeToString(wrapped) + "." +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Values that are not encrypted,
+// such as rows written before encryption was enabled, are returned as is.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := open(kek, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("error unwrapping data key: %w", err)
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(aead, ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether value is stored in plaintext or under a key
+// other than the active one.
+func (k *Keyring) NeedsRotation(value string) bool {
+	if value == "" {
+		return false
+	}
+	if !IsEncrypted(value) {
+		return true
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ".")
+
+	return id != k.activeID
+}
+
+// BlindIndex returns a keyed hash of the normalised value, allowing exact
+// matches and uniqueness checks without decrypting. Whitespace is ignored so
+// that "1234 567890" and "1234567890" match.
+func (k *Keyring) BlindIndex(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(strings.Join(strings.Fields(value), "")))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
@@ -0,0 +1,133 @@
+package fieldcrypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKeyValue, indexKey := newKey(t), newKey(t), newKey(t)
+
+	before, err := NewKeyring("k1:"+oldKey, "k1", indexKey)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	sealed, err := before.Encrypt("1234 567890")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsEncrypted(sealed) || strings.Contains(sealed, "567890") {
+		t.Fatalf("Encrypt returned %q, want ciphertext", sealed)
+	}
+	if before.NeedsRotation(sealed) {
+		t.Error("value under the active key needs rotation")
+	}
+
+	// k2 becomes active while k1 is kept to decrypt existing values.
+	rotated, err := NewKeyring("k1:"+oldKey+", k2:"+newKeyValue, "k2", indexKey)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if !rotated.NeedsRotation(sealed) {
+		t.Error("value under a retired key does not need rotation")
+	}
+	plaintext, err := rotated.Decrypt(sealed)
+	if err != nil || plaintext != "1234 567890" {
+		t.Fatalf("Decrypt under retired key = %q, %v", plaintext, err)
+	}
+
+	resealed, err := rotated.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.HasPrefix(resealed, "enc.k2.") || rotated.NeedsRotation(resealed) {
+		t.Errorf("resealed value %q is not under the active key", resealed)
+	}
+
+	// Once k1 is dropped, only values re-encrypted under k2 can be read.
+	retired, err := NewKeyring("k2:"+newKeyValue, "k2", indexKey)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if _, err = retired.Decrypt(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt under dropped key: err = %v, want ErrUnknownKey", err)
+	}
+	if plaintext, err = retired.Decrypt(resealed); err != nil || plaintext != "1234 567890" {
+		t.Errorf("Decrypt = %q, %v", plaintext, err)
+	}
+
+	if before.BlindIndex("1234 567890") != retired.BlindIndex("1234567890") {
+		t.Error("blind index changed with the encryption key or whitespace")
+	}
+}
+
+func TestNeedsRotation(t *testing.T) {
+	keys, err := NewKeyring("k1:"+newKey(t), "k1", newKey(t))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"", false},
+		{"1234 567890", true},
+		{"enc.k0.wrapped.ciphertext", true},
+		{"enc.k1.wrapped.ciphertext", false},
+	}
+	for _, tt := range tests {
+		if got := keys.NeedsRotation(tt.value); got != tt.want {
+			t.Errorf("NeedsRotation(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	keys, err := NewKeyring("k1:"+newKey(t), "k1", newKey(t))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	sealed, err := keys.Encrypt("Moscow, Lenina 1")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-2] + "BB"
+	}
+	if _, err = keys.Decrypt(tampered); err == nil {
+		t.Error("Decrypt accepted a tampered ciphertext")
+	}
+}
+
+func TestNewKeyringRejectsInvalidKeys(t *testing.T) {
+	key, indexKey := newKey(t), newKey(t)
+
+	tests := []struct {
+		name, keys, active, index string
+	}{
+		{"missing id", ":" + key, "k1", indexKey},
+		{"short key", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "k1", indexKey},
+		{"unknown active key", "k1:" + key, "k2", indexKey},
+		{"short index key", "k1:" + key, "k1", base64.StdEncoding.EncodeToString([]byte("short"))},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyring(tt.keys, tt.active, tt.index); err == nil {
+			t.Errorf("%s: NewKeyring succeeded", tt.name)
+		}
+	}
+}
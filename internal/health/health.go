@@ -0,0 +1,157 @@
+// Package health runs the checks behind the liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailing  = "failing"
+	StatusDraining = "draining"
+)
+
+// checkTimeout bounds every check, so that a hanging dependency fails the
+// probe instead of stalling it.
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of a single check. Error is logged but never
+// served, as it may describe the infrastructure.
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"-"`
+	Critical bool   `json:"critical"`
+}
+
+// Report is the outcome of all checks. Its status is failing if a critical
+// check failed and degraded if only others did.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name     string
+	check    Check
+	critical bool
+}
+
+// Checker runs a set of checks concurrently.
+type Checker struct {
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add registers a check. Only critical checks make the application unready
+// when they fail; the others merely degrade the report.
+func (c *Checker) Add(name string, critical bool, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check, critical: critical})
+}
+
+// Drain makes every later report unready, so that load balancers stop
+// sending requests while the server shuts down.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+func (c *Checker) Run(ctx context.Context) Report {
+	if c.draining.Load() {
+		return Report{Status: StatusDraining}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			result := Result{Status: StatusOK, Critical: nc.critical}
+			if err := nc.check(ctx); err != nil {
+				result.Status, result.Error = StatusFailing, err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = result
+			switch {
+			case result.Status == StatusOK:
+			case nc.critical:
+				report.Status = StatusFailing
+			case report.Status == StatusOK:
+				report.Status = StatusDegraded
+			}
+		}(nc)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// Cached wraps a check so that it runs at most once per ttl, for
+// dependencies that should not be called on every probe.
+func Cached(check Check, ttl time.Duration) Check {
+	var mu sync.Mutex
+	var checkedAt time.Time
+	var last error
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+			return last
+		}
+		last, checkedAt = check(ctx), time.Now()
+		return last
+	}
+}
+
+// LiveHandler answers the liveness probe: a process able to serve it is
+// alive, so it checks nothing.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+// ReadyHandler answers the readiness probe with the status of every check,
+// failing with 503 when a critical check fails or the server is draining.
+// Why a check failed is only logged.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		for name, result := range report.Checks {
+			if result.Error != "" {
+				log.Printf("Readiness check %s failed: %s", name, result.Error)
+			}
+		}
+		status := http.StatusOK
+		if report.Status == StatusFailing || report.Status == StatusDraining {
+			status = http.StatusServiceUnavailable
+		}
+		writeReport(w, status, report)
+	})
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,49 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyHandler(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("database", true, func(ctx context.Context) error { return nil })
+	checker.Add("enrichment", false, func(ctx context.Context) error {
+		return errors.New("dial tcp 10.0.0.7:8081: connection refused")
+	})
+
+	ready := func() (int, Report, string) {
+		w := httptest.NewRecorder()
+		checker.ReadyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		body := w.Body.String()
+		var report Report
+		if err := json.Unmarshal([]byte(body), &report); err != nil {
+			t.Fatalf("invalid report %s: %v", body, err)
+		}
+		return w.Code, report, body
+	}
+
+	status, report, body := ready()
+	if status != http.StatusOK || report.Status != StatusDegraded || report.Checks["enrichment"].Status != StatusFailing {
+		t.Errorf("degraded probe got %d %s", status, body)
+	}
+	if strings.Contains(body, "10.0.0.7") || strings.Contains(body, "error") {
+		t.Errorf("probe reveals why a check failed: %s", body)
+	}
+
+	checker.Add("migrations", true, func(ctx context.Context) error { return errors.New("schema is at version 3") })
+	if status, report, body = ready(); status != http.StatusServiceUnavailable || report.Status != StatusFailing ||
+		strings.Contains(body, "version 3") {
+		t.Errorf("failing probe got %d %s", status, body)
+	}
+
+	checker.Drain()
+	if status, report, _ = ready(); status != http.StatusServiceUnavailable || report.Status != StatusDraining {
+		t.Errorf("draining probe got %d %+v", status, report)
+	}
+}
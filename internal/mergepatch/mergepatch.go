@@ -0,0 +1,56 @@
+// Package mergepatch applies JSON Merge Patch documents as defined by
+// RFC 7396: objects in the patch are merged recursively, null removes a
+// member and any other value replaces the target's.
+package mergepatch
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ContentType is the media type of merge patch request bodies.
+const ContentType = "application/merge-patch+json"
+
+// ErrNotObject is returned for a patch that is not a JSON object. Such
+// patches are valid but replace the whole document, which no resource
+// accepts.
+var ErrNotObject = errors.New("merge patch must be a JSON object")
+
+// Apply returns doc with patch merged into it.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	patchObject, ok := p.(map[string]interface{})
+	if !ok {
+		return nil, ErrNotObject
+	}
+
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merge(target, patchObject))
+}
+
+func merge(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = merge(targetObject[name], value)
+	}
+	return targetObject
+}
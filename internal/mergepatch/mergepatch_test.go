@@ -0,0 +1,70 @@
+package mergepatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestApply runs the examples of RFC 7396, appendix A, whose patches are
+// objects.
+func TestApply(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{`{"a":"b"}`, `{}`, `{"a":"b"}`},
+		{`null`, `{"a":"b"}`, `{"a":"b"}`},
+		{`["c"]`, `{"a":"b"}`, `{"a":"b"}`},
+	}
+
+	for _, tt := range tests {
+		got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("Apply(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if !jsonEqual(t, got, []byte(tt.want)) {
+			t.Errorf("Apply(%s, %s) = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestApplyRejectsNonObjectPatches(t *testing.T) {
+	for _, patch := range []string{`["a","b"]`, `"c"`, `null`, `42`} {
+		if _, err := Apply([]byte(`{"a":"b"}`), []byte(patch)); !errors.Is(err, ErrNotObject) {
+			t.Errorf("Apply with patch %s: err = %v, want ErrNotObject", patch, err)
+		}
+	}
+}
+
+func TestApplyInvalidJSON(t *testing.T) {
+	if _, err := Apply([]byte(`{"a":"b"}`), []byte(`{"a":`)); err == nil {
+		t.Error("Apply accepted a malformed patch")
+	}
+	if _, err := Apply([]byte(`{"a":`), []byte(`{"a":"b"}`)); err == nil {
+		t.Error("Apply accepted a malformed document")
+	}
+}
+
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var x, y interface{}
+	if err := json.Unmarshal(a, &x); err != nil {
+		t.Fatalf("invalid JSON %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b, err)
+	}
+	return reflect.DeepEqual(x, y)
+}
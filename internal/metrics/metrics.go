@@ -0,0 +1,128 @@
+// Package metrics collects Prometheus metrics of the application and serves
+// them for scraping.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "timetracker"
+
+// Registry holds every metric of the application. A registry of its own
+// keeps tests and tools importing the packages from registering globally.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route template and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	enrichmentDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "enrichment_request_duration_seconds",
+		Help:      "Latency of passport lookups in the enrichment API by outcome.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"outcome"})
+	enrichmentFailures = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "enrichment_failures_total",
+		Help:      "Failed passport lookups in the enrichment API by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{Namespace: namespace}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveHTTP records a served request. Route is the template of the matched
+// route rather than the path, to keep the number of series bounded.
+func ObserveHTTP(route, method string, status int, elapsed time.Duration) {
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(route, method).Observe(elapsed.Seconds())
+}
+
+// ObserveEnrichment records a call to the enrichment API and, when it
+// failed, the code of the domain error it failed with.
+func ObserveEnrichment(elapsed time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+		reason := "unknown"
+		if e, ok := apperr.As(err); ok {
+			reason = e.Code
+		}
+		enrichmentFailures.WithLabelValues(reason).Inc()
+	}
+	enrichmentDuration.WithLabelValues(outcome).Observe(elapsed.Seconds())
+}
+
+// RegisterDB exports the connection pool statistics of a database.
+func RegisterDB(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterTimerStats exports figures about timers, read from stats whenever
+// the metrics are scraped.
+func RegisterTimerStats(stats func(ctx context.Context) (models.TimerStats, error)) {
+	Registry.MustRegister(&timerCollector{stats: stats})
+}
+
+var (
+	runningTimersDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "running_timers"),
+		"Tasks with a time entry that has not been stopped yet.", nil, nil)
+	entriesPerMinuteDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "time_entries_created_per_minute"),
+		"Time entries created during the last minute.", nil, nil)
+)
+
+// collectTimeout bounds the queries run while collecting a scrape.
+const collectTimeout = 5 * time.Second
+
+type timerCollector struct {
+	stats func(ctx context.Context) (models.TimerStats, error)
+}
+
+func (c *timerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- runningTimersDesc
+	ch <- entriesPerMinuteDesc
+}
+
+func (c *timerCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	stats, err := c.stats(ctx)
+	if err != nil {
+		log.Printf("Failed to collect timer stats: %v", err)
+		ch <- prometheus.NewInvalidMetric(runningTimersDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(runningTimersDesc, prometheus.GaugeValue, float64(stats.Running))
+	ch <- prometheus.MustNewConstMetric(entriesPerMinuteDesc, prometheus.GaugeValue, float64(stats.Started))
+}
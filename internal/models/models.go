@@ -1,16 +1,49 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleManager  Role = "manager"
+	RoleEmployee Role = "employee"
+)
+
+func (r Role) Valid() bool {
+	return r == RoleAdmin || r == RoleManager || r == RoleEmployee
+}
+
+// Privileges are granted to users individually, independently of their role.
+const (
+	// PrivilegeViewPersonalData allows seeing passport numbers and addresses
+	// of other users unmasked.
+	PrivilegeViewPersonalData = "personal_data:view"
+)
+
+func ValidPrivilege(privilege string) bool {
+	return privilege == PrivilegeViewPersonalData
+}
 
 type User struct {
-	ID             int       `json:"id" example:"1"`
-	PassportNumber string    `json:"passportNumber" example:"1234 5678"`
-	Surname        string    `json:"surname" example:"Smith"`
-	Name           string    `json:"name" example:"John"`
-	Patronymic     string    `json:"patronymic" example:"Michael"`
-	Address        string    `json:"address" example:"123 Main St, City"`
-	CreatedAt      time.Time `json:"createdAt" example:"2023-07-03"`
-	UpdatedAt      time.Time `json:"updatedAt" example:"2023-07-03"`
+	ID             int        `json:"id" example:"1"`
+	OrganizationID int        `json:"organizationId" example:"1"`
+	PassportNumber string     `json:"passportNumber" example:"1234 5678"`
+	Surname        string     `json:"surname" example:"Smith"`
+	Name           string     `json:"name" example:"John"`
+	Patronymic     string     `json:"patronymic" example:"Michael"`
+	Address        string     `json:"address" example:"123 Main St, City"`
+	Role           Role       `json:"role" example:"employee" enums:"admin,manager,employee"`
+	Team           string     `json:"team,omitempty" example:"backend"`
+	Privileges     []string   `json:"privileges,omitempty" example:"personal_data:view"`
+	CreatedAt      time.Time  `json:"createdAt" example:"2023-07-03T09:00:00Z"`
+	UpdatedAt      time.Time  `json:"updatedAt" example:"2023-07-03T09:00:00Z"`
+	Version        int        `json:"version" example:"1"`
+	DeletedAt      *time.Time `json:"deletedAt,omitempty" example:"2023-07-03T09:00:00Z"`
+	ErasedAt       *time.Time `json:"erasedAt,omitempty" example:"2023-07-03T09:00:00Z"`
 }
 
 type Workload struct {
@@ -24,18 +57,27 @@ type Task struct {
 	ID          int       `json:"id" example:"1"`
 	UserID      int       `json:"userId" example:"1"`
 	Description string    `json:"description" example:"Project planning"`
-	StartTime   time.Time `json:"startTime" example:"2023-07-03"`
-	EndTime     time.Time `json:"endTime,omitempty" example:"2023-07-03"`
-	CreatedAt   time.Time `json:"createdAt" example:"2023-07-03"`
+	StartTime   time.Time `json:"startTime" example:"2023-07-03T09:00:00Z"`
+	EndTime     time.Time `json:"endTime,omitempty" example:"2023-07-03T17:00:00Z"`
+	CreatedAt   time.Time `json:"createdAt" example:"2023-07-03T09:00:00Z"`
+	Version     int       `json:"version" example:"1"`
 }
 
 type TimeEntry struct {
-	ID        int            `json:"id" example:"1"`
-	TaskID    int            `json:"taskId" example:"1"`
-	StartTime time.Time      `json:"startTime" example:"2023-07-03"`
-	EndTime   time.Time     `json:"endTime,omitempty" example:"2023-07-03"`
-	Duration  time.Duration `json:"duration,omitempty" example:"8h30m"`
-	CreatedAt time.Time      `json:"createdAt" example:"2023-07-03"`
+	ID        int           `json:"id" example:"1"`
+	TaskID    int           `json:"taskId" example:"1"`
+	StartTime time.Time     `json:"startTime" example:"2023-07-03T09:00:00Z"`
+	EndTime   time.Time     `json:"endTime,omitempty" example:"2023-07-03T17:00:00Z"`
+	Duration  time.Duration `json:"duration,omitempty" example:"30600000000000" swaggertype:"integer"`
+	CreatedAt time.Time     `json:"createdAt" example:"2023-07-03T09:00:00Z"`
+	Version   int           `json:"version" example:"1"`
+}
+
+// TimerStats are figures about time tracking across all organizations.
+// Started counts the time entries started since the time asked about.
+type TimerStats struct {
+	Running int
+	Started int
 }
 
 type People struct {
@@ -44,3 +86,192 @@ type People struct {
 	Patronymic string `json:"patronymic" example:"Michael"`
 	Address    string `json:"address" example:"123 Main St, City"`
 }
+
+type APIKey struct {
+	ID        int        `json:"id" example:"1"`
+	Name      string     `json:"name" example:"payroll export"`
+	Prefix    string     `json:"prefix" example:"tt_3q2x7w0A"`
+	Subject   string     `json:"subject" example:"42"`
+	CreatedAt time.Time  `json:"createdAt" example:"2023-07-03T09:00:00Z"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" example:"2023-07-03T09:00:00Z"`
+}
+
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key" example:"tt_3q2x7w0AtVbq1c9Yj0b1o6fJzE0o0b1o6fJzE0o0b1"`
+}
+
+// Actor identifies who performs a write so that it can be audited.
+type Actor struct {
+	UserID    int
+	RequestID string
+}
+
+// IdempotencyRecord is a request sent with an Idempotency-Key and, once it
+// has completed, the response replayed when the request is retried. Status
+// is zero while the request is in progress.
+type IdempotencyRecord struct {
+	OrganizationID int
+	Subject        string
+	Key            string
+	Fingerprint    string
+	Status         int
+	Header         map[string][]string
+	Body           []byte
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+type AuditEvent struct {
+	ID             int64           `json:"id" example:"1"`
+	OrganizationID int             `json:"organizationId" example:"1"`
+	ActorID        *int            `json:"actorId" example:"1"`
+	Action         string          `json:"action" example:"user.update"`
+	EntityType     string          `json:"entityType" example:"user"`
+	EntityID       int             `json:"entityId" example:"42"`
+	Before         json.RawMessage `json:"before" swaggertype:"object"`
+	After          json.RawMessage `json:"after" swaggertype:"object"`
+	RequestID      string          `json:"requestId" example:"4f6c0e7d9a1b2c3d4e5f60718293a4b5"`
+	CreatedAt      time.Time       `json:"createdAt" example:"2023-07-03T09:00:00Z"`
+}
+
+type AuditFilter struct {
+	ActorID    int
+	Action     string
+	EntityType string
+	EntityID   int
+	From       time.Time
+	To         time.Time
+	Page       int
+	Limit      int
+}
+
+// Pagination selects a page of a listing, either by page number or, when
+// After or Before holds a cursor, by keyset relative to a previous page.
+type Pagination struct {
+	Page   int
+	Limit  int
+	After  string
+	Before string
+}
+
+// FilterOp is the comparison of a listing filter.
+type FilterOp string
+
+const (
+	FilterEq       FilterOp = "eq"
+	FilterPrefix   FilterOp = "prefix"
+	FilterContains FilterOp = "contains"
+	FilterIn       FilterOp = "in"
+	FilterGt       FilterOp = "gt"
+	FilterGte      FilterOp = "gte"
+	FilterLt       FilterOp = "lt"
+	FilterLte      FilterOp = "lte"
+)
+
+// FieldType tells how the values of a filterable field are written.
+type FieldType string
+
+const (
+	FieldText FieldType = "text"
+	FieldInt  FieldType = "int"
+	FieldTime FieldType = "time"
+)
+
+// FieldSpec describes how a listing may be filtered and sorted by a field.
+// The first operator is used when a filter names none.
+type FieldSpec struct {
+	Type     FieldType
+	Ops      []FilterOp
+	Sortable bool
+}
+
+// Filter restricts a listing to rows whose field compares to the values
+// with the operator. Only FilterIn takes more than one value.
+type Filter struct {
+	Field  string
+	Op     FilterOp
+	Values []string
+}
+
+// SortField orders a listing by a field.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// UserQuery selects and orders the users of a listing. Filters are combined
+// with AND.
+type UserQuery struct {
+	Filters        []Filter
+	Sort           []SortField
+	IncludeDeleted bool
+}
+
+// UserPage is a page of users out of Total matching ones. Next and Prev are
+// cursors of the neighbouring pages in keyset mode and empty otherwise or
+// when there is no such page.
+type UserPage struct {
+	Users []User
+	Total int
+	Next  string
+	Prev  string
+}
+
+const (
+	SearchHitUser = "user"
+	SearchHitTask = "task"
+
+	SearchFieldName        = "name"
+	SearchFieldAddress     = "address"
+	SearchFieldDescription = "description"
+)
+
+// SearchHit is a user or task matching a search. Field names what matched:
+// the full name or address of a user or the description of a task.
+// Highlight is the matched text, HTML-escaped, with the matching words
+// wrapped in <mark> tags.
+type SearchHit struct {
+	Type      string  `json:"type" example:"user"`
+	Field     string  `json:"field" example:"name"`
+	ID        int     `json:"id" example:"1"`
+	UserID    int     `json:"userId" example:"1"`
+	Text      string  `json:"text" example:"Иванов Иван Иванович"`
+	Highlight string  `json:"highlight" example:"<mark>Иванов</mark> Иван Иванович"`
+	Rank      float64 `json:"rank" example:"0.87"`
+}
+
+// SearchResults are the users and tasks matching a search, best first.
+type SearchResults struct {
+	Query string      `json:"query" example:"Иванов"`
+	Users []SearchHit `json:"users"`
+	Tasks []SearchHit `json:"tasks"`
+}
+
+const (
+	DataRequestExport  = "export"
+	DataRequestErasure = "erasure"
+)
+
+// DataRequest is a data subject request made under GDPR or 152-FZ.
+type DataRequest struct {
+	ID             int        `json:"id" example:"1"`
+	OrganizationID int        `json:"organizationId" example:"1"`
+	UserID         int        `json:"userId" example:"42"`
+	Type           string     `json:"type" example:"erasure" enums:"export,erasure"`
+	RequestedBy    *int       `json:"requestedBy" example:"1"`
+	RequestID      string     `json:"requestId" example:"4f6c0e7d9a1b2c3d4e5f60718293a4b5"`
+	RequestedAt    time.Time  `json:"requestedAt" example:"2023-07-03T09:00:00Z"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty" example:"2023-07-03T09:00:00Z"`
+}
+
+// PersonalData is everything stored about a user, as handed out on a data
+// subject access request.
+type PersonalData struct {
+	GeneratedAt  time.Time     `json:"generatedAt" example:"2023-07-03T09:00:00Z"`
+	User         User          `json:"user"`
+	Tasks        []Task        `json:"tasks"`
+	TimeEntries  []TimeEntry   `json:"timeEntries"`
+	AuditEvents  []AuditEvent  `json:"auditEvents"`
+	DataRequests []DataRequest `json:"dataRequests"`
+}
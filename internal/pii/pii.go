@@ -0,0 +1,92 @@
+// Package pii masks personal data for callers and log records that are not
+// entitled to see it in full.
+package pii
+
+import (
+	"encoding/json"
+	"strings"
+	"timeTracker/internal/models"
+)
+
+const (
+	passportVisiblePrefix = 2
+	passportVisibleSuffix = 3
+	addressVisibleRunes   = 10
+)
+
+// MaskPassportNumber keeps the first two and last three digits, so
+// "1234 345678" becomes "12** ***678".
+func MaskPassportNumber(passport string) string {
+	runes := []rune(passport)
+	digits := 0
+	for _, r := range runes {
+		if r != ' ' {
+			digits++
+		}
+	}
+
+	seen := 0
+	for i, r := range runes {
+		if r == ' ' {
+			continue
+		}
+		if seen >= passportVisiblePrefix && seen < digits-passportVisibleSuffix {
+			runes[i] = '*'
+		}
+		seen++
+	}
+
+	return string(runes)
+}
+
+// MaskAddress truncates an address to its first few characters.
+func MaskAddress(address string) string {
+	runes := []rune(address)
+	if len(runes) <= addressVisibleRunes {
+		return strings.Repeat("*", len(runes))
+	}
+
+	return strings.TrimSpace(string(runes[:addressVisibleRunes])) + "…"
+}
+
+// IsMaskedAddress reports whether address looks like the output of
+// MaskAddress, as when a client echoes a masked representation back.
+func IsMaskedAddress(address string) bool {
+	if strings.HasSuffix(address, "…") {
+		return true
+	}
+	return address != "" && strings.Trim(address, "*") == ""
+}
+
+// MaskUser returns a copy of u with its passport number and address masked.
+func MaskUser(u models.User) models.User {
+	u.PassportNumber = MaskPassportNumber(u.PassportNumber)
+	u.Address = MaskAddress(u.Address)
+	return u
+}
+
+// MaskSnapshot masks the personal fields of a JSON user snapshot, such as the
+// before and after states of an audit event.
+func MaskSnapshot(raw json.RawMessage) json.RawMessage {
+	if raw == nil {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw
+	}
+	if v, ok := fields["passportNumber"].(string); ok {
+		fields["passportNumber"] = MaskPassportNumber(v)
+	}
+	if v, ok := fields["address"].(string); ok {
+		fields["address"] = MaskAddress(v)
+	}
+
+	masked, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+
+	return masked
+}
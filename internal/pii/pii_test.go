@@ -0,0 +1,21 @@
+package pii
+
+import "testing"
+
+func TestIsMaskedAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{address, false},
+		{MaskAddress(address), true},
+		{"Tver", false},
+		{MaskAddress("Tver"), true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsMaskedAddress(tt.address); got != tt.want {
+			t.Errorf("IsMaskedAddress(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package pii
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"timeTracker/internal/models"
+)
+
+// redactedKeys are attribute keys whose values are masked in log records,
+// compared case-insensitively with underscores removed.
+var redactedKeys = map[string]func(string) string{
+	"passportnumber": MaskPassportNumber,
+	"passport":       MaskPassportNumber,
+	"address":        MaskAddress,
+}
+
+type redactingHandler struct {
+	next slog.Handler
+}
+
+// NewRedactingHandler wraps next so that passport numbers and addresses never
+// reach it, whether logged as plain attributes, inside groups or as part of a
+// models.User.
+func NewRedactingHandler(next slog.Handler) slog.Handler {
+	return &redactingHandler{next: next}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redact(a))
+		return true
+	})
+
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redact(a)
+	}
+
+	return &redactingHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redact(a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindGroup:
+		attrs := value.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, attr := range attrs {
+			redacted[i] = redact(attr)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case models.User:
+			return slog.Any(a.Key, MaskUser(v))
+		case *models.User:
+			if v != nil {
+				return slog.Any(a.Key, MaskUser(*v))
+			}
+		}
+	}
+
+	if mask, ok := redactedKeys[strings.ReplaceAll(strings.ToLower(a.Key), "_", "")]; ok {
+		return slog.String(a.Key, mask(value.String()))
+	}
+
+	return slog.Attr{Key: a.Key, Value: value}
+}
@@ -0,0 +1,95 @@
+package pii
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"timeTracker/internal/models"
+)
+
+const (
+	passport = "1234 567890"
+	address  = "Moscow, Lenina street 1, apartment 5"
+)
+
+func newTestLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return slog.New(NewRedactingHandler(slog.NewJSONHandler(&buf, nil))), &buf
+}
+
+func TestRedactingHandler(t *testing.T) {
+	user := models.User{ID: 1, Surname: "Ivanov", PassportNumber: passport, Address: address}
+
+	tests := []struct {
+		name string
+		log  func(l *slog.Logger)
+	}{
+		{"plain attributes", func(l *slog.Logger) {
+			l.Info("user", "passportNumber", passport, "address", address)
+		}},
+		{"key spellings", func(l *slog.Logger) {
+			l.Info("user", "passport_number", passport, "Passport", passport, "ADDRESS", address)
+		}},
+		{"with attributes", func(l *slog.Logger) {
+			l.With("passportNumber", passport).With("address", address).Info("user")
+		}},
+		{"group", func(l *slog.Logger) {
+			l.Info("user", slog.Group("user", "passportNumber", passport, slog.Group("home", "address", address)))
+		}},
+		{"with group", func(l *slog.Logger) {
+			l.WithGroup("user").Info("user", "passportNumber", passport, "address", address)
+		}},
+		{"user value", func(l *slog.Logger) {
+			l.Info("user", "user", user)
+		}},
+		{"user pointer", func(l *slog.Logger) {
+			l.Info("user", "user", &user)
+		}},
+		{"log valuer", func(l *slog.Logger) {
+			l.Info("user", "passportNumber", valuer(passport))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, buf := newTestLogger()
+			tt.log(logger)
+
+			out := buf.String()
+			if strings.Contains(out, "567890") || strings.Contains(out, "Lenina street") {
+				t.Errorf("personal data reached the log: %s", out)
+			}
+			if !strings.Contains(out, "12** ***890") && !strings.Contains(out, "Moscow, Le…") {
+				t.Errorf("masked value missing from the log: %s", out)
+			}
+		})
+	}
+}
+
+func TestRedactingHandlerKeepsOtherAttributes(t *testing.T) {
+	logger, buf := newTestLogger()
+	logger.With("userID", 7).Info("updated user", "surname", "Ivanov", "passportNumber", passport)
+
+	out := buf.String()
+	for _, want := range []string{`"userID":7`, `"surname":"Ivanov"`, `"msg":"updated user"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log %s does not contain %s", out, want)
+		}
+	}
+}
+
+func TestRedactingHandlerNilUser(t *testing.T) {
+	logger, buf := newTestLogger()
+	logger.Info("user", "user", (*models.User)(nil))
+
+	if !strings.Contains(buf.String(), `"user":null`) {
+		t.Errorf("nil user logged as %s", buf.String())
+	}
+}
+
+type valuer string
+
+func (v valuer) LogValue() slog.Value {
+	return slog.StringValue(string(v))
+}
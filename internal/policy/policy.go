@@ -0,0 +1,130 @@
+package policy
+
+import (
+	"strconv"
+	"timeTracker/internal/auth"
+	"timeTracker/internal/models"
+)
+
+type Action string
+
+const (
+	ListUsers    Action = "users:list"
+	ViewUser     Action = "users:view"
+	CreateUser   Action = "users:create"
+	UpdateUser   Action = "users:update"
+	DeleteUser   Action = "users:delete"
+	ViewWorkload Action = "workload:view"
+	StartTask    Action = "tasks:start"
+	StopTask     Action = "tasks:stop"
+	IssueAPIKey  Action = "api_keys:issue"
+	RevokeAPIKey Action = "api_keys:revoke"
+	ViewAudit    Action = "audit:view"
+	RestoreUser  Action = "users:restore"
+	ListDeleted  Action = "users:list_deleted"
+	ExportData   Action = "personal_data:export"
+	EraseData    Action = "personal_data:erase"
+)
+
+// Target describes the user a request acts upon. Team is only consulted for
+// actions that managers may perform on members of their team.
+type Target struct {
+	UserID int
+	Team   string
+}
+
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+func allow() Decision {
+	return Decision{Allowed: true}
+}
+
+func deny(reason string) Decision {
+	return Decision{Reason: reason}
+}
+
+// Evaluate decides whether the principal may perform the action on the
+// target. It has no side effects and does not touch HTTP or the database.
+func Evaluate(p auth.Principal, action Action, target Target) Decision {
+	if p.Role == models.RoleAdmin {
+		return allow()
+	}
+
+	switch action {
+	case ListUsers, IssueAPIKey:
+		return allow()
+	case CreateUser, UpdateUser, DeleteUser, RestoreUser, ListDeleted:
+		return deny("only admins can manage users")
+	case ExportData:
+		if target.UserID == p.UserID {
+			return allow()
+		}
+		return deny("personal data can only be exported by its subject or an admin")
+	case EraseData:
+		return deny("only admins can erase personal data")
+	case ViewAudit:
+		return deny("only admins can view the audit log")
+	case ViewUser:
+		if target.UserID == p.UserID || sameTeam(p, target) {
+			return allow()
+		}
+		if p.Role == models.RoleManager {
+			return deny("managers can only view members of their team")
+		}
+		return deny("employees can only view themselves")
+	case ViewWorkload:
+		if target.UserID == p.UserID || sameTeam(p, target) {
+			return allow()
+		}
+		if p.Role == models.RoleManager {
+			return deny("managers can only view the workload of their team")
+		}
+		return deny("employees can only view their own workload")
+	case StartTask, StopTask:
+		if target.UserID == p.UserID {
+			return allow()
+		}
+		return deny("tasks can only be started or stopped by their owner")
+	case RevokeAPIKey:
+		if target.UserID == p.UserID {
+			return allow()
+		}
+		return deny("api keys can only be revoked by their owner")
+	}
+
+	return deny("unknown action " + string(action))
+}
+
+// UserScope returns the filters restricting which users the principal may
+// list: admins see everyone, managers their team and employees themselves.
+func UserScope(p auth.Principal) map[string]string {
+	switch {
+	case p.Role == models.RoleAdmin:
+		return nil
+	case p.Role == models.RoleManager && p.Team != "":
+		return map[string]string{"team": p.Team}
+	}
+
+	return map[string]string{"id": strconv.Itoa(p.UserID)}
+}
+
+// ViewPersonalData reports whether the principal sees the passport number and
+// address of the target unmasked. Unlike the actions above this is not
+// implied by the admin role and needs an explicit privilege.
+func ViewPersonalData(p auth.Principal, target Target) bool {
+	return target.UserID == p.UserID || p.HasPrivilege(models.PrivilegeViewPersonalData)
+}
+
+// SearchPersonalData reports whether the principal may find users by their
+// address. A match reveals what the address of any user in scope contains,
+// so this always needs the privilege.
+func SearchPersonalData(p auth.Principal) bool {
+	return p.HasPrivilege(models.PrivilegeViewPersonalData)
+}
+
+func sameTeam(p auth.Principal, target Target) bool {
+	return p.Role == models.RoleManager && p.Team != "" && p.Team == target.Team
+}
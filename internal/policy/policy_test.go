@@ -0,0 +1,139 @@
+package policy
+
+import (
+	"maps"
+	"testing"
+	"timeTracker/internal/auth"
+	"timeTracker/internal/models"
+)
+
+var (
+	admin    = auth.Principal{UserID: 1, Role: models.RoleAdmin, Team: "backend"}
+	manager  = auth.Principal{UserID: 2, Role: models.RoleManager, Team: "backend"}
+	employee = auth.Principal{UserID: 3, Role: models.RoleEmployee, Team: "backend"}
+)
+
+// outcome lists whether an action is allowed on the principal themselves, on
+// a member of their team and on a user of another team.
+type outcome struct {
+	self, teammate, other bool
+}
+
+func TestEvaluate(t *testing.T) {
+	everywhere := outcome{true, true, true}
+	nowhere := outcome{}
+	selfOnly := outcome{self: true}
+	team := outcome{self: true, teammate: true}
+
+	tests := []struct {
+		action   Action
+		manager  outcome
+		employee outcome
+	}{
+		{ListUsers, everywhere, everywhere},
+		{IssueAPIKey, everywhere, everywhere},
+		{CreateUser, nowhere, nowhere},
+		{UpdateUser, nowhere, nowhere},
+		{DeleteUser, nowhere, nowhere},
+		{RestoreUser, nowhere, nowhere},
+		{ListDeleted, nowhere, nowhere},
+		{ViewAudit, nowhere, nowhere},
+		{EraseData, nowhere, nowhere},
+		{ExportData, selfOnly, selfOnly},
+		{ViewUser, team, selfOnly},
+		{ViewWorkload, team, selfOnly},
+		{StartTask, selfOnly, selfOnly},
+		{StopTask, selfOnly, selfOnly},
+		{RevokeAPIKey, selfOnly, selfOnly},
+		{Action("users:unknown"), nowhere, nowhere},
+	}
+
+	for _, tt := range tests {
+		for _, c := range []struct {
+			principal auth.Principal
+			want      outcome
+		}{
+			{admin, everywhere},
+			{manager, tt.manager},
+			{employee, tt.employee},
+		} {
+			p := c.principal
+			targets := []struct {
+				name   string
+				target Target
+				want   bool
+			}{
+				{"self", Target{UserID: p.UserID, Team: p.Team}, c.want.self},
+				{"teammate", Target{UserID: 10, Team: p.Team}, c.want.teammate},
+				{"other", Target{UserID: 11, Team: "frontend"}, c.want.other},
+			}
+			for _, target := range targets {
+				decision := Evaluate(p, tt.action, target.target)
+				if decision.Allowed != target.want {
+					t.Errorf("%s %s on %s: allowed = %v, want %v", p.Role, tt.action, target.name,
+						decision.Allowed, target.want)
+				}
+				if !decision.Allowed && decision.Reason == "" {
+					t.Errorf("%s %s on %s: denied without a reason", p.Role, tt.action, target.name)
+				}
+			}
+		}
+	}
+}
+
+func TestEvaluateManagerWithoutTeam(t *testing.T) {
+	p := auth.Principal{UserID: 2, Role: models.RoleManager}
+	for _, action := range []Action{ViewUser, ViewWorkload} {
+		if Evaluate(p, action, Target{UserID: 10}).Allowed {
+			t.Errorf("manager without a team may %s users without a team", action)
+		}
+	}
+}
+
+func TestViewPersonalData(t *testing.T) {
+	privileged := employee
+	privileged.Privileges = []string{models.PrivilegeViewPersonalData}
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		target    Target
+		want      bool
+	}{
+		{"employee on self", employee, Target{UserID: employee.UserID}, true},
+		{"employee on other", employee, Target{UserID: 10}, false},
+		{"manager on teammate", manager, Target{UserID: 10, Team: manager.Team}, false},
+		{"admin without privilege", admin, Target{UserID: 10}, false},
+		{"privileged employee on other", privileged, Target{UserID: 10}, true},
+	}
+
+	for _, tt := range tests {
+		if got := ViewPersonalData(tt.principal, tt.target); got != tt.want {
+			t.Errorf("%s: ViewPersonalData = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if SearchPersonalData(employee) || SearchPersonalData(admin) || !SearchPersonalData(privileged) {
+		t.Error("SearchPersonalData does not follow the personal_data:view privilege")
+	}
+}
+
+func TestUserScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		want      map[string]string
+	}{
+		{"admin", admin, nil},
+		{"manager", manager, map[string]string{"team": "backend"}},
+		{"manager without team", auth.Principal{UserID: 2, Role: models.RoleManager},
+			map[string]string{"id": "2"}},
+		{"employee", employee, map[string]string{"id": "3"}},
+	}
+
+	for _, tt := range tests {
+		if got := UserScope(tt.principal); !maps.Equal(got, tt.want) {
+			t.Errorf("%s: UserScope = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
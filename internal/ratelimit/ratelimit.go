@@ -0,0 +1,117 @@
+// Package ratelimit implements token-bucket rate limiting with pluggable
+// storage of the buckets.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Class groups routes sharing a limit.
+type Class string
+
+const (
+	// ClassAddress limits all requests from a remote address before they
+	// are authenticated.
+	ClassAddress Class = "address"
+	ClassRead    Class = "read"
+	ClassWrite   Class = "write"
+	ClassExport  Class = "export"
+)
+
+// Limit is a token bucket holding up to Burst tokens and refilled at Rate
+// tokens per second. Every request takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute returns a limit of n requests a minute with bursts of burst.
+func PerMinute(n, burst int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: burst}
+}
+
+// Window is the time an empty bucket takes to fill up.
+func (l Limit) Window() time.Duration {
+	return seconds(float64(l.Burst) / l.Rate)
+}
+
+// Bucket is the state of a token bucket as of UpdatedAt. The zero Bucket is
+// a bucket never taken from, which is full.
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// RetryAfter is how long until a token is available, zero when allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Take refills the bucket for the time passed since it was last updated and
+// takes a token from it if there is one.
+func (l Limit) Take(b Bucket, now time.Time) (Bucket, Result) {
+	tokens := float64(l.Burst)
+	if !b.UpdatedAt.IsZero() {
+		elapsed := math.Max(0, now.Sub(b.UpdatedAt).Seconds())
+		tokens = math.Min(float64(l.Burst), b.Tokens+elapsed*l.Rate)
+	}
+
+	result := Result{Limit: l}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / l.Rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((float64(l.Burst) - tokens) / l.Rate)
+
+	return Bucket{Tokens: tokens, UpdatedAt: now}, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Store keeps the buckets of all clients and takes tokens from them
+// atomically.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// StoreFunc adapts a function to a Store.
+type StoreFunc func(ctx context.Context, key string, limit Limit) (Result, error)
+
+func (f StoreFunc) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	return f(ctx, key, limit)
+}
+
+// Limiter applies per-class limits to clients. Classes without a limit are
+// not limited.
+type Limiter struct {
+	store  Store
+	limits map[Class]Limit
+}
+
+func NewLimiter(store Store, limits map[Class]Limit) *Limiter {
+	return &Limiter{store: store, limits: limits}
+}
+
+// Take takes a token from the client's bucket of the class. The result is
+// allowed and has a zero limit when the class is unlimited.
+func (l *Limiter) Take(ctx context.Context, class Class, client string) (Result, error) {
+	limit, ok := l.limits[class]
+	if !ok || limit.Rate <= 0 || limit.Burst <= 0 {
+		return Result{Allowed: true}, nil
+	}
+
+	return l.store.Take(ctx, string(class)+":"+client, limit)
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"timeTracker/internal/models"
+)
+
+type APIKeyRepository interface {
+	AddAPIKey(ctx context.Context, orgID int, actor models.Actor, key models.APIKey, keyHash string) (models.APIKey, error)
+	// APIKeyByHash is used to authenticate a request and therefore is not
+	// scoped to an organization.
+	APIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error)
+	APIKey(ctx context.Context, orgID, id int) (models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, orgID int, actor models.Actor, id int) error
+}
+
+func (p *postgresRepo) AddAPIKey(ctx context.Context, orgID int, actor models.Actor, key models.APIKey,
+	keyHash string) (models.APIKey, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return key, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (organization_id, name, prefix, key_hash, subject)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, orgID, key.Name, key.Prefix, keyHash, key.Subject).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return key, err
+	}
+
+	if err = p.audit(ctx, tx, orgID, actor, AuditAPIKeyIssue, EntityAPIKey, key.ID, nil, key); err != nil {
+		return key, err
+	}
+
+	return key, tx.Commit()
+}
+
+func (p *postgresRepo) APIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	query := `
+		SELECT id, name, prefix, subject, created_at
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL`
+
+	var key models.APIKey
+	err := p.db.QueryRowContext(ctx, query, keyHash).Scan(&key.ID, &key.Name, &key.Prefix, &key.Subject, &key.CreatedAt)
+	if err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+func (p *postgresRepo) APIKey(ctx context.Context, orgID, id int) (models.APIKey, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	query := `
+		SELECT id, name, prefix, subject, created_at, revoked_at
+		FROM api_keys
+		WHERE id = $1 AND organization_id = $2`
+
+	var key models.APIKey
+	err := p.db.QueryRowContext(ctx, query, id, orgID).Scan(&key.ID, &key.Name, &key.Prefix, &key.Subject, &key.CreatedAt, &key.RevokedAt)
+	if err != nil {
+		return key, notFound(err, errAPIKeyNotFound)
+	}
+
+	return key, nil
+}
+
+func (p *postgresRepo) RevokeAPIKey(ctx context.Context, orgID int, actor models.Actor, id int) error {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE api_keys SET revoked_at = $1
+		WHERE id = $2 AND organization_id = $3 AND revoked_at IS NULL
+		RETURNING id, name, prefix, subject, created_at, revoked_at`
+
+	var key models.APIKey
+	err = tx.QueryRowContext(ctx, query, time.Now(), id, orgID).
+		Scan(&key.ID, &key.Name, &key.Prefix, &key.Subject, &key.CreatedAt, &key.RevokedAt)
+	if err != nil {
+		return notFound(err, errAPIKeyNotFound)
+	}
+
+	before := key
+	before.RevokedAt = nil
+	if err = p.audit(ctx, tx, orgID, actor, AuditAPIKeyRevoke, EntityAPIKey, id, before, key); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"timeTracker/internal/fieldcrypt"
+	"timeTracker/internal/models"
+)
+
+const (
+	AuditUserCreate     = "user.create"
+	AuditUserUpdate     = "user.update"
+	AuditUserDelete     = "user.delete"
+	AuditUserRestore    = "user.restore"
+	AuditUserPurge      = "user.purge"
+	AuditTimeEntryStart = "time_entry.start"
+	AuditTimeEntryStop  = "time_entry.stop"
+	AuditAPIKeyIssue    = "api_key.issue"
+	AuditAPIKeyRevoke   = "api_key.revoke"
+
+	EntityUser      = "user"
+	EntityTimeEntry = "time_entry"
+	EntityAPIKey    = "api_key"
+)
+
+type AuditRepository interface {
+	AuditEvents(ctx context.Context, orgID int, filter models.AuditFilter) ([]models.AuditEvent, error)
+}
+
+// audit appends an event to the audit log within tx, so the record is only
+// persisted if the change it describes is committed.
+func (p *postgresRepo) audit(ctx context.Context, tx *sql.Tx, orgID int, actor models.Actor, action, entityType string, entityID int,
+	before, after interface{}) error {
+	beforeJSON, err := p.snapshot(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := p.snapshot(after)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_log (organization_id, actor_id, action, entity_type, entity_id, before, after, request_id)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, NULLIF($8, ''))`
+
+	_, err = tx.ExecContext(ctx, query, orgID, actor.UserID, action, entityType, entityID, beforeJSON, afterJSON, actor.RequestID)
+	if err != nil {
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+
+	return nil
+}
+
+// snapshot encodes v for an audit log column, encrypting user fields that are
+// encrypted at rest. It returns a string rather than []byte because lib/pq
+// sends byte slices as bytea.
+func (s sealer) snapshot(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if u, ok := v.(models.User); ok {
+		sealed, err := s.sealUser(u)
+		if err != nil {
+			return nil, err
+		}
+		v = sealed
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+func (p *postgresRepo) AuditEvents(ctx context.Context, orgID int, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	query := `
+		SELECT id, organization_id, actor_id, action, entity_type, entity_id, before, after,
+			COALESCE(request_id, ''), created_at
+		FROM audit_log
+		WHERE organization_id = $1`
+
+	params := []interface{}{orgID}
+	where := func(condition string, value interface{}) {
+		params = append(params, value)
+		query += fmt.Sprintf(" AND "+condition, len(params))
+	}
+	if filter.ActorID != 0 {
+		where("actor_id = $%d", filter.ActorID)
+	}
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if filter.EntityType != "" {
+		where("entity_type = $%d", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		where("entity_id = $%d", filter.EntityID)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $%d", filter.To)
+	}
+
+	params = append(params, filter.Limit, (filter.Page-1)*filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", len(params)-1, len(params))
+
+	rows, err := p.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var e models.AuditEvent
+		var before, after []byte
+		err := rows.Scan(&e.ID, &e.OrganizationID, &e.ActorID, &e.Action, &e.EntityType, &e.EntityID,
+			&before, &after, &e.RequestID, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if e.Before, err = p.openSnapshot(before); err != nil {
+			return nil, err
+		}
+		if e.After, err = p.openSnapshot(after); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// openSnapshot decrypts the encrypted fields of a user snapshot.
+func (s sealer) openSnapshot(raw []byte) (json.RawMessage, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw, nil
+	}
+	for _, field := range []string{"passportNumber", "address"} {
+		value, ok := fields[field].(string)
+		if !ok || !fieldcrypt.IsEncrypted(value) {
+			continue
+		}
+		plaintext, err := s.keys.Decrypt(value)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting audit snapshot: %w", err)
+		}
+		fields[field] = plaintext
+	}
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/models"
+)
+
+// cursor holds the sort key of the row a keyset page starts after or ends
+// before, together with the order it belongs to. It is handed out
+// base64-encoded so that clients treat it as opaque.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+func encodeCursor(sort []models.SortField, u models.User) string {
+	c := cursor{Sort: sortKey(sort), Values: make([]string, len(sort))}
+	for i, s := range sort {
+		c.Values[i] = userFields[s.Field].value(u)
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor returns the sort key values of a cursor, which must have
+// been issued for the same order. The values are checked against the types
+// of their fields so that a tampered cursor is rejected before the database
+// fails to cast it.
+func decodeCursor(s string, sort []models.SortField) ([]string, error) {
+	var c cursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(raw, &c)
+	}
+	if err != nil || len(c.Values) != len(sort) {
+		return nil, apperr.Invalid("invalid_cursor", "cursor is malformed")
+	}
+	if c.Sort != sortKey(sort) {
+		return nil, apperr.Invalid("invalid_cursor", "cursor belongs to a different sort order")
+	}
+	for i, s := range sort {
+		if !validCursorValue(userFields[s.Field].Type, c.Values[i]) {
+			return nil, apperr.Invalid("invalid_cursor", "cursor is malformed")
+		}
+	}
+	return c.Values, nil
+}
+
+// validCursorValue reports whether v is a value of a field of type t.
+func validCursorValue(t models.FieldType, v string) bool {
+	var err error
+	switch t {
+	case models.FieldInt:
+		_, err = strconv.Atoi(v)
+	case models.FieldTime:
+		_, err = parseTime(v)
+	}
+	return err == nil
+}
+
+func sortKey(sort []models.SortField) string {
+	terms := make([]string, len(sort))
+	for i, s := range sort {
+		terms[i] = s.Field
+		if s.Desc {
+			terms[i] = "-" + s.Field
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// finishPage turns the users fetched for a page, one more than its limit and
+// in reverse order when paging backwards, into the page and the cursors of
+// its neighbours. Numbered pages get a next cursor too, which is where
+// keyset paging starts.
+func finishPage(result models.UserPage, page models.Pagination, sort []models.SortField) models.UserPage {
+	backwards := page.Before != ""
+	more := len(result.Users) > page.Limit
+	if more {
+		result.Users = result.Users[:page.Limit]
+	}
+	if backwards {
+		slices.Reverse(result.Users)
+	}
+	if len(result.Users) == 0 {
+		return result
+	}
+
+	first := encodeCursor(sort, result.Users[0])
+	last := encodeCursor(sort, result.Users[len(result.Users)-1])
+	switch {
+	case page.After != "":
+		result.Prev = first
+		if more {
+			result.Next = last
+		}
+	case backwards:
+		result.Next = last
+		if more {
+			result.Prev = first
+		}
+	case more:
+		result.Next = last
+	}
+
+	return result
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"encoding/base64"
+	"slices"
+	"testing"
+	"time"
+	"timeTracker/internal/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	user := models.User{ID: 42, Surname: "Иванов, \"младший\"", Team: "", CreatedAt: created}
+	sort := []models.SortField{{Field: "surname", Desc: true}, {Field: "team"}, {Field: "created_at"}, {Field: "id"}}
+
+	values, err := decodeCursor(encodeCursor(sort, user), sort)
+	if err != nil {
+		t.Fatalf("decodeCursor: %v", err)
+	}
+	if want := []string{user.Surname, "", created.Format(time.RFC3339Nano), "42"}; !slices.Equal(values, want) {
+		t.Errorf("decodeCursor = %q, want %q", values, want)
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	bySurname := []models.SortField{{Field: "surname"}, {Field: "id"}}
+	cursor := encodeCursor(bySurname, models.User{ID: 1, Surname: "Ivanov"})
+
+	tests := []struct {
+		name   string
+		cursor string
+		sort   []models.SortField
+	}{
+		{"empty", "", bySurname},
+		{"not base64", "not a cursor!", bySurname},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("surname")), bySurname},
+		{"wrong number of values", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"surname,id","v":["Ivanov"]}`)), bySurname},
+		{"other direction", cursor, []models.SortField{{Field: "surname", Desc: true}, {Field: "id"}}},
+		{"other fields", cursor, []models.SortField{{Field: "name"}, {Field: "id"}}},
+		{"id not a number", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"surname,id","v":["Ivanov","1 OR 1=1"]}`)), bySurname},
+		{"malformed time", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"created_at,id","v":["yesterday","1"]}`)),
+			[]models.SortField{{Field: "created_at"}, {Field: "id"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor, tt.sort)
+			wantError(t, err, "invalid_cursor")
+		})
+	}
+}
+
+func TestFinishPage(t *testing.T) {
+	sort := []models.SortField{{Field: "id"}}
+	fetched := func(ids ...int) models.UserPage {
+		var page models.UserPage
+		for _, id := range ids {
+			page.Users = append(page.Users, models.User{ID: id})
+		}
+		return page
+	}
+	cursor := func(id int) string {
+		return encodeCursor(sort, models.User{ID: id})
+	}
+
+	tests := []struct {
+		name       string
+		fetched    models.UserPage
+		page       models.Pagination
+		ids        []int
+		next, prev string
+	}{
+		{"numbered with more", fetched(1, 2, 3), models.Pagination{Page: 1, Limit: 2}, []int{1, 2}, cursor(2), ""},
+		{"numbered last", fetched(5), models.Pagination{Page: 3, Limit: 2}, []int{5}, "", ""},
+		{"after with more", fetched(3, 4, 5), models.Pagination{Limit: 2, After: cursor(2)}, []int{3, 4}, cursor(4), cursor(3)},
+		{"after last", fetched(5), models.Pagination{Limit: 2, After: cursor(4)}, []int{5}, "", cursor(5)},
+		{"before with more", fetched(4, 3, 2), models.Pagination{Limit: 2, Before: cursor(5)}, []int{3, 4}, cursor(4), cursor(3)},
+		{"before first", fetched(2, 1), models.Pagination{Limit: 2, Before: cursor(3)}, []int{1, 2}, cursor(2), ""},
+		{"empty", fetched(), models.Pagination{Limit: 2, After: cursor(5)}, nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := finishPage(tt.fetched, tt.page, sort)
+			var ids []int
+			for _, u := range got.Users {
+				ids = append(ids, u.ID)
+			}
+			if !slices.Equal(ids, tt.ids) || got.Next != tt.next || got.Prev != tt.prev {
+				t.Errorf("finishPage = %v next %q prev %q, want %v next %q prev %q",
+					ids, got.Next, got.Prev, tt.ids, tt.next, tt.prev)
+			}
+		})
+	}
+}
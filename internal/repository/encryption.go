@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"timeTracker/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// EncryptPersonalData brings every stored passport number and address, and
+// their copies in audit snapshots, under the active encryption key and
+// recomputes blind indexes of passport numbers and address words. It works
+// in batches of batchSize rows, each in its own transaction, is idempotent
+// and can be re-run after a failure. It returns the number of rows
+// rewritten.
+func (p *postgresRepo) EncryptPersonalData(ctx context.Context, batchSize int) (int, error) {
+	total := 0
+	for _, encryptBatch := range []func(ctx context.Context, after int64, batchSize int) (int, int64, error){
+		p.encryptUserBatch,
+		p.encryptAuditBatch,
+	} {
+		var after int64
+		for {
+			rewritten, last, err := encryptBatch(ctx, after, batchSize)
+			total += rewritten
+			if err != nil {
+				return total, err
+			}
+			if last == after {
+				break
+			}
+			after = last
+		}
+	}
+
+	return total, nil
+}
+
+// UnindexedUsers counts users whose passport number or address has no blind
+// index yet, such as those stored before encryption or address search were
+// added. Lookups, the uniqueness check and search miss them until
+// EncryptPersonalData has run.
+func (p *postgresRepo) UnindexedUsers(ctx context.Context) (int, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	var count int
+	err := p.db.QueryRowContext(ctx, `
+		SELECT count(*) FROM users
+		WHERE passport_number IS NOT NULL AND passport_number_index IS NULL
+			OR address_tokens IS NULL AND erased_at IS NULL`).Scan(&count)
+	return count, err
+}
+
+func (p *postgresRepo) encryptUserBatch(ctx context.Context, after int64, batchSize int) (int, int64, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Bulk)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, after, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, COALESCE(passport_number, ''), COALESCE(passport_number_index, ''), address, address_tokens,
+			erased_at IS NOT NULL
+		FROM users WHERE id > $1 ORDER BY id LIMIT $2 FOR UPDATE`, after, batchSize)
+	if err != nil {
+		return 0, after, err
+	}
+
+	type row struct {
+		id                       int64
+		passport, index, address string
+		tokens                   []string
+		erased                   bool
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.passport, &r.index, &r.address, pq.Array(&r.tokens), &r.erased); err != nil {
+			rows.Close()
+			return 0, after, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, after, err
+	}
+
+	rewritten, last := 0, after
+	for _, r := range batch {
+		last = r.id
+		passport, err := p.keys.Decrypt(r.passport)
+		if err != nil {
+			return 0, after, fmt.Errorf("error decrypting passport number of user %d: %w", r.id, err)
+		}
+		index := p.keys.BlindIndex(passport)
+		address, err := p.keys.Decrypt(r.address)
+		if err != nil {
+			return 0, after, fmt.Errorf("error decrypting address of user %d: %w", r.id, err)
+		}
+		// Erased users keep no address tokens, so they can never be found
+		// by their former address.
+		var tokens []string
+		if !r.erased {
+			tokens = p.addressTokens(address)
+		}
+		if !p.keys.NeedsRotation(r.passport) && !p.keys.NeedsRotation(r.address) && index == r.index &&
+			(r.tokens == nil) == (tokens == nil) && slices.Equal(tokens, r.tokens) {
+			continue
+		}
+
+		sealed, err := p.sealUser(models.User{PassportNumber: passport, Address: address})
+		if err != nil {
+			return 0, after, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET passport_number = NULLIF($1, ''), passport_number_index = NULLIF($2, ''), address = $3,
+				address_tokens = $4
+			WHERE id = $5`, sealed.PassportNumber, index, sealed.Address, pq.Array(tokens), r.id)
+		if err != nil {
+			return 0, after, err
+		}
+		rewritten++
+	}
+
+	return rewritten, last, tx.Commit()
+}
+
+func (p *postgresRepo) encryptAuditBatch(ctx context.Context, after int64, batchSize int) (int, int64, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Bulk)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, after, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `SET LOCAL audit_log.redaction = 'on'`); err != nil {
+		return 0, after, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, before, after FROM audit_log
+		WHERE entity_type = $1 AND id > $2 ORDER BY id LIMIT $3`, EntityUser, after, batchSize)
+	if err != nil {
+		return 0, after, err
+	}
+
+	type row struct {
+		id            int64
+		before, after []byte
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.before, &r.after); err != nil {
+			rows.Close()
+			return 0, after, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, after, err
+	}
+
+	rewritten, last := 0, after
+	for _, r := range batch {
+		last = r.id
+		before, beforeChanged, err := p.resealSnapshot(r.before)
+		if err != nil {
+			return 0, after, fmt.Errorf("error re-encrypting audit event %d: %w", r.id, err)
+		}
+		afterSnapshot, afterChanged, err := p.resealSnapshot(r.after)
+		if err != nil {
+			return 0, after, fmt.Errorf("error re-encrypting audit event %d: %w", r.id, err)
+		}
+		if !beforeChanged && !afterChanged {
+			continue
+		}
+
+		if _, err = tx.ExecContext(ctx, `UPDATE audit_log SET before = $1, after = $2 WHERE id = $3`, before, afterSnapshot, r.id); err != nil {
+			return 0, after, err
+		}
+		rewritten++
+	}
+
+	return rewritten, last, tx.Commit()
+}
+
+// resealSnapshot re-encrypts the personal fields of a stored user snapshot
+// under the active key, reporting whether anything changed.
+func (p *postgresRepo) resealSnapshot(raw []byte) (interface{}, bool, error) {
+	if raw == nil {
+		return nil, false, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	for _, field := range []string{"passportNumber", "address"} {
+		value, ok := fields[field].(string)
+		if !ok || !p.keys.NeedsRotation(value) {
+			continue
+		}
+		plaintext, err := p.keys.Decrypt(value)
+		if err != nil {
+			return nil, false, err
+		}
+		if fields[field], err = p.keys.Encrypt(plaintext); err != nil {
+			return nil, false, err
+		}
+		changed = true
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return string(b), changed, nil
+}
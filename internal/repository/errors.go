@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"timeTracker/internal/apperr"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	// uniqueViolation is the SQLSTATE Postgres reports for a duplicate key.
+	uniqueViolation = "23505"
+	// queryCanceled is the SQLSTATE of a statement cancelled because its
+	// context was done.
+	queryCanceled = "57014"
+)
+
+// uniqueConstraints maps unique indexes to the conflict they represent.
+var uniqueConstraints = map[string]func() *apperr.Error{
+	"users_organization_id_passport_number_index_key": errPassportTaken,
+	"users_organization_id_passport_number_key":       errPassportTaken,
+}
+
+// sqliteUniqueConstraints maps the columns SQLite names in unique violations
+// to the conflict they represent.
+var sqliteUniqueConstraints = map[string]func() *apperr.Error{
+	"users.organization_id, users.passport_number_index": errPassportTaken,
+}
+
+func errUserNotFound() *apperr.Error {
+	return apperr.NotFound("user_not_found", "user not found")
+}
+
+func errTaskNotFound() *apperr.Error {
+	return apperr.NotFound("task_not_found", "task not found or doesn't belong to the user")
+}
+
+func errAPIKeyNotFound() *apperr.Error {
+	return apperr.NotFound("api_key_not_found", "api key not found")
+}
+
+func errPassportTaken() *apperr.Error {
+	return apperr.Conflict("passport_number_taken", "a user with this passport number already exists")
+}
+
+// checkVersion fails when the caller based its write on a version other than
+// the current one. An expected version of zero skips the check.
+func checkVersion(entity string, current, expected int) error {
+	if expected != 0 && current != expected {
+		return apperr.PreconditionFailed("version_mismatch",
+			fmt.Sprintf("%s has been modified: current version is %d", entity, current))
+	}
+	return nil
+}
+
+// notFound replaces sql.ErrNoRows with the given domain error, keeping
+// sql.ErrNoRows in the chain for callers that still check for it.
+func notFound(err error, domain func() *apperr.Error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain().Wrap(err)
+	}
+	return err
+}
+
+// conflict translates unique violations on known indexes into domain errors.
+func conflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		if domain, ok := uniqueConstraints[pqErr.Constraint]; ok {
+			return domain().Wrap(err)
+		}
+	}
+	return err
+}
+
+// sqliteConflict is conflict for the SQLite backend, whose errors name the
+// columns of the violated index rather than the index.
+func sqliteConflict(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		for columns, domain := range sqliteUniqueConstraints {
+			if strings.Contains(sqliteErr.Error(), "UNIQUE constraint failed: "+columns) {
+				return domain().Wrap(err)
+			}
+		}
+	}
+	return err
+}
+
+// IsTimeout reports whether an operation failed because it ran out of time,
+// either before reaching the database or by having its statement cancelled.
+func IsTimeout(err error) bool {
+	var pqErr *pq.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &pqErr) && pqErr.Code == queryCanceled
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"timeTracker/internal/models"
+)
+
+type IdempotencyRepository interface {
+	// ReserveIdempotencyKey stores a new in-progress record, replacing an
+	// expired one with the same key. If an unexpired record exists it is
+	// returned instead and reserved is false.
+	ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (stored models.IdempotencyRecord, reserved bool, err error)
+	// CompleteIdempotencyKey saves the response of a reserved request.
+	CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) error
+	// ReleaseIdempotencyKey drops a reservation so that the request can be
+	// retried, for when it failed without a response worth replaying.
+	ReleaseIdempotencyKey(ctx context.Context, orgID int, subject, key string) error
+	// PurgeIdempotencyKeys removes records of every organization that
+	// expired before the given time.
+	PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int, error)
+}
+
+func (p *postgresRepo) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	query := `
+		INSERT INTO idempotency_keys (organization_id, subject, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (organization_id, subject, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status = NULL, header = NULL, body = NULL,
+			created_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= CURRENT_TIMESTAMP
+		RETURNING created_at`
+
+	err := p.db.QueryRowContext(ctx, query, record.OrganizationID, record.Subject, record.Key, record.Fingerprint,
+		record.ExpiresAt).Scan(&record.CreatedAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return record, false, err
+	}
+
+	stored, err := p.idempotencyRecord(ctx, record.OrganizationID, record.Subject, record.Key)
+	return stored, false, err
+}
+
+func (p *postgresRepo) idempotencyRecord(ctx context.Context, orgID int, subject, key string) (models.IdempotencyRecord, error) {
+	query := `
+		SELECT organization_id, subject, key, fingerprint, COALESCE(status, 0), header, COALESCE(body, ''),
+			created_at, expires_at
+		FROM idempotency_keys
+		WHERE organization_id = $1 AND subject = $2 AND key = $3`
+
+	var r models.IdempotencyRecord
+	var header []byte
+	var body string
+	err := p.db.QueryRowContext(ctx, query, orgID, subject, key).Scan(&r.OrganizationID, &r.Subject, &r.Key, &r.Fingerprint,
+		&r.Status, &header, &body, &r.CreatedAt, &r.ExpiresAt)
+	if err != nil {
+		return r, err
+	}
+
+	if header != nil {
+		if err = json.Unmarshal(header, &r.Header); err != nil {
+			return r, fmt.Errorf("error decoding stored response header: %w", err)
+		}
+	}
+	if body, err = p.keys.Decrypt(body); err != nil {
+		return r, fmt.Errorf("error decrypting stored response body: %w", err)
+	}
+	r.Body = []byte(body)
+
+	return r, nil
+}
+
+func (p *postgresRepo) CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	body, err := p.keys.Encrypt(string(record.Body))
+	if err != nil {
+		return fmt.Errorf("error encrypting response body: %w", err)
+	}
+
+	query := `
+		UPDATE idempotency_keys SET status = $1, header = $2, body = $3
+		WHERE organization_id = $4 AND subject = $5 AND key = $6`
+
+	_, err = p.db.ExecContext(ctx, query, record.Status, header, body, record.OrganizationID, record.Subject, record.Key)
+	return err
+}
+
+func (p *postgresRepo) ReleaseIdempotencyKey(ctx context.Context, orgID int, subject, key string) error {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE organization_id = $1 AND subject = $2 AND key = $3`,
+		orgID, subject, key)
+	return err
+}
+
+func (p *postgresRepo) PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Bulk)
+	defer cancel()
+
+	result, err := p.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < $1`, expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
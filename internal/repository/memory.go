@@ -0,0 +1,1140 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/models"
+	"unicode"
+)
+
+// memoryRepo keeps everything in process memory, for tests and the demo mode.
+// It follows the semantics of postgresRepo, except for the following
+// differences. Text is sorted by bytes rather than by collation, search only
+// approximates full text and trigram matching, and nothing is encrypted
+// since nothing is persisted.
+type memoryRepo struct {
+	mu           sync.RWMutex
+	lastID       map[string]int
+	users        map[int]*models.User
+	tasks        map[int]*memoryTask
+	entries      map[int]*memoryEntry
+	apiKeys      map[int]*memoryAPIKey
+	auditLog     []models.AuditEvent
+	dataRequests map[int]*models.DataRequest
+	idempotency  map[idempotencyKey]models.IdempotencyRecord
+}
+
+type memoryTask struct {
+	models.Task
+	orgID int
+}
+
+type memoryEntry struct {
+	models.TimeEntry
+	orgID int
+	// running is true until the entry is stopped, since a zero EndTime
+	// cannot tell.
+	running bool
+}
+
+type memoryAPIKey struct {
+	models.APIKey
+	orgID int
+	hash  string
+}
+
+type idempotencyKey struct {
+	orgID        int
+	subject, key string
+}
+
+func NewMemoryRepo() *memoryRepo {
+	return &memoryRepo{
+		lastID:       map[string]int{},
+		users:        map[int]*models.User{},
+		tasks:        map[int]*memoryTask{},
+		entries:      map[int]*memoryEntry{},
+		apiKeys:      map[int]*memoryAPIKey{},
+		dataRequests: map[int]*models.DataRequest{},
+		idempotency:  map[idempotencyKey]models.IdempotencyRecord{},
+	}
+}
+
+// now returns the current time at the microsecond precision of Postgres
+// timestamps.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// nextID works like a SERIAL column of the given table.
+func (m *memoryRepo) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+func cloneUser(u models.User) models.User {
+	u.Privileges = slices.Clone(u.Privileges)
+	if u.Privileges == nil {
+		u.Privileges = []string{}
+	}
+	if u.DeletedAt != nil {
+		deletedAt := *u.DeletedAt
+		u.DeletedAt = &deletedAt
+	}
+	if u.ErasedAt != nil {
+		erasedAt := *u.ErasedAt
+		u.ErasedAt = &erasedAt
+	}
+	return u
+}
+
+// audit appends an event to the audit log. Snapshots are stored as Postgres
+// hands them out, with the keys of objects sorted.
+func (m *memoryRepo) audit(orgID int, actor models.Actor, action, entityType string, entityID int, before, after interface{}) error {
+	event := models.AuditEvent{
+		ID:             int64(m.nextID("audit_log")),
+		OrganizationID: orgID,
+		Action:         action,
+		EntityType:     entityType,
+		EntityID:       entityID,
+		RequestID:      actor.RequestID,
+		CreatedAt:      now(),
+	}
+	if actor.UserID != 0 {
+		actorID := actor.UserID
+		event.ActorID = &actorID
+	}
+
+	var err error
+	if event.Before, err = memorySnapshot(before); err != nil {
+		return err
+	}
+	if event.After, err = memorySnapshot(after); err != nil {
+		return err
+	}
+	m.auditLog = append(m.auditLog, event)
+
+	return nil
+}
+
+func memorySnapshot(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return b, nil
+	}
+	return json.Marshal(fields)
+}
+
+// passportTaken tells whether another active user of the organization holds
+// the passport number of u.
+func (m *memoryRepo) passportTaken(u models.User) bool {
+	if u.PassportNumber == "" {
+		return false
+	}
+	for _, other := range m.users {
+		if other.ID != u.ID && other.OrganizationID == u.OrganizationID && other.DeletedAt == nil &&
+			other.PassportNumber == u.PassportNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// activeUser returns a user of the organization that is not soft-deleted.
+func (m *memoryRepo) activeUser(orgID, id int) (*models.User, error) {
+	u, ok := m.users[id]
+	if !ok || u.OrganizationID != orgID || u.DeletedAt != nil {
+		return nil, errUserNotFound().Wrap(sql.ErrNoRows)
+	}
+	return u, nil
+}
+
+func (m *memoryRepo) AddUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	created := cloneUser(user)
+	created.OrganizationID = orgID
+	created.DeletedAt, created.ErasedAt = nil, nil
+	if m.passportTaken(created) {
+		return user, fmt.Errorf("error adding user to database: %w", errPassportTaken())
+	}
+	created.ID = m.nextID("users")
+	created.CreatedAt = now()
+	created.UpdatedAt = created.CreatedAt
+	created.Version = 1
+
+	if err := m.audit(orgID, actor, AuditUserCreate, EntityUser, created.ID, nil, created); err != nil {
+		return user, err
+	}
+	m.users[created.ID] = &created
+
+	return cloneUser(created), nil
+}
+
+func (m *memoryRepo) GetUsers(ctx context.Context, orgID int, page models.Pagination, query models.UserQuery) (models.UserPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result models.UserPage
+
+	sort, err := userSort(query.Sort)
+	if err != nil {
+		return result, err
+	}
+	users, err := m.selectUsers(orgID, query)
+	if err != nil {
+		return result, err
+	}
+	result.Total = len(users)
+
+	backwards := page.Before != ""
+	slices.SortFunc(users, func(a, b models.User) int {
+		return compareUsers(sort, a, b)
+	})
+	if backwards {
+		slices.Reverse(users)
+	}
+
+	if page.After != "" || backwards {
+		c := page.After
+		if backwards {
+			c = page.Before
+		}
+		values, err := decodeCursor(c, sort)
+		if err != nil {
+			return result, err
+		}
+		users = slices.DeleteFunc(users, func(u models.User) bool {
+			order := compareToCursor(sort, u, values)
+			return order == 0 || (order > 0) == backwards
+		})
+	} else {
+		users = users[min((page.Page-1)*page.Limit, len(users)):]
+	}
+
+	// One user more than asked for tells whether there is a further page.
+	result.Users = users[:min(page.Limit+1, len(users))]
+
+	return finishPage(result, page, sort), nil
+}
+
+// selectUsers returns copies of the users of an organization matching a
+// query, in no particular order.
+func (m *memoryRepo) selectUsers(orgID int, query models.UserQuery) ([]models.User, error) {
+	for _, f := range query.Filters {
+		if _, err := checkFilter(f); err != nil {
+			return nil, err
+		}
+	}
+
+	var users []models.User
+	for _, u := range m.users {
+		if u.OrganizationID != orgID || u.DeletedAt != nil && !query.IncludeDeleted {
+			continue
+		}
+		matches, err := matchUser(*u, query.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			users = append(users, cloneUser(*u))
+		}
+	}
+	return users, nil
+}
+
+// matchUser applies checked filters to a user the way userWhere does in SQL:
+// prefix and contains ignore case, the other operators do not.
+func matchUser(u models.User, filters []models.Filter) (bool, error) {
+	for _, f := range filters {
+		value := fieldValue(f.Field, u)
+		matches := false
+		for _, v := range f.Values {
+			switch f.Op {
+			case models.FilterPrefix:
+				matches = strings.HasPrefix(strings.ToLower(value), strings.ToLower(v))
+			case models.FilterContains:
+				matches = strings.Contains(strings.ToLower(value), strings.ToLower(v))
+			default:
+				order, err := compareField(f.Field, value, v)
+				if err != nil {
+					return false, err
+				}
+				switch f.Op {
+				case models.FilterEq, models.FilterIn:
+					matches = order == 0
+				case models.FilterGt:
+					matches = order > 0
+				case models.FilterGte:
+					matches = order >= 0
+				case models.FilterLt:
+					matches = order < 0
+				case models.FilterLte:
+					matches = order <= 0
+				}
+			}
+			if matches {
+				break
+			}
+		}
+		if !matches {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func fieldValue(field string, u models.User) string {
+	if field == "passport_number" {
+		return u.PassportNumber
+	}
+	return userFields[field].value(u)
+}
+
+// compareField compares two values of a field by its type.
+func compareField(field, a, b string) (int, error) {
+	switch userFields[field].Type {
+	case models.FieldInt:
+		x, err := strconv.Atoi(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := strconv.Atoi(b)
+		if err != nil {
+			return 0, err
+		}
+		return cmp.Compare(x, y), nil
+	case models.FieldTime:
+		x, err := parseTime(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := parseTime(b)
+		if err != nil {
+			return 0, err
+		}
+		return x.Compare(y), nil
+	}
+	return strings.Compare(a, b), nil
+}
+
+// parseTime reads a timestamp as Postgres would from a cursor or a filter:
+// RFC 3339 with optional fractional seconds, or a date at midnight UTC.
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Parse(time.DateOnly, s)
+	}
+	return t, nil
+}
+
+// compareUsers orders two users by a total sort order.
+func compareUsers(sort []models.SortField, a, b models.User) int {
+	for _, s := range sort {
+		order, _ := compareField(s.Field, fieldValue(s.Field, a), fieldValue(s.Field, b))
+		if s.Desc {
+			order = -order
+		}
+		if order != 0 {
+			return order
+		}
+	}
+	return 0
+}
+
+// compareToCursor tells whether a user comes before or after the sort key
+// values of a cursor.
+func compareToCursor(sort []models.SortField, u models.User, values []string) int {
+	for i, s := range sort {
+		order, err := compareField(s.Field, fieldValue(s.Field, u), values[i])
+		if err != nil {
+			order = strings.Compare(fieldValue(s.Field, u), values[i])
+		}
+		if s.Desc {
+			order = -order
+		}
+		if order != 0 {
+			return order
+		}
+	}
+	return 0
+}
+
+func (m *memoryRepo) GetUserWorkload(ctx context.Context, orgID, userID int, start, end time.Time) ([]models.Workload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	totals := map[int]time.Duration{}
+	for _, e := range m.entries {
+		task, ok := m.tasks[e.TaskID]
+		if !ok || task.orgID != orgID || task.UserID != userID || e.running ||
+			e.StartTime.Before(start) || e.EndTime.After(end) {
+			continue
+		}
+		totals[task.ID] += e.Duration
+	}
+
+	var taskIDs []int
+	for id := range totals {
+		taskIDs = append(taskIDs, id)
+	}
+	slices.SortFunc(taskIDs, func(a, b int) int {
+		return cmp.Or(cmp.Compare(totals[b], totals[a]), cmp.Compare(a, b))
+	})
+
+	var workloads []models.Workload
+	for _, id := range taskIDs {
+		// Rounded like the SQL of postgresRepo: hours to the nearest hour,
+		// minutes down within the hour of the seconds rounded.
+		seconds := totals[id].Seconds()
+		workloads = append(workloads, models.Workload{
+			TaskID:      id,
+			Description: m.tasks[id].Description,
+			Hours:       int(math.Round(seconds / 3600)),
+			Minutes:     int(math.Round(seconds)) % 3600 / 60,
+		})
+	}
+
+	return workloads, nil
+}
+
+// lockTask returns a task of an active user of the organization.
+func (m *memoryRepo) lockTask(orgID, userID, taskID int) (*memoryTask, error) {
+	task, ok := m.tasks[taskID]
+	if !ok || task.orgID != orgID || task.UserID != userID {
+		return nil, errTaskNotFound().Wrap(sql.ErrNoRows)
+	}
+	if u, ok := m.users[userID]; !ok || u.DeletedAt != nil {
+		return nil, errTaskNotFound().Wrap(sql.ErrNoRows)
+	}
+	return task, nil
+}
+
+// runningEntry returns the time entry of a task that has not been stopped.
+func (m *memoryRepo) runningEntry(taskID int) *memoryEntry {
+	for _, e := range m.entries {
+		if e.TaskID == taskID && e.running {
+			return e
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepo) StartUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID, version int) (models.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, err := m.lockTask(orgID, userID, taskID)
+	if err != nil {
+		return models.Task{}, err
+	}
+	if err = checkVersion("task", task.Version, version); err != nil {
+		return models.Task{}, err
+	}
+	if m.runningEntry(taskID) != nil {
+		return models.Task{}, apperr.Conflict("task_already_active", "task is already active")
+	}
+
+	timeEntry := models.TimeEntry{ID: m.nextID("time_entries"), TaskID: taskID, StartTime: now(), Version: 1}
+	if err = m.audit(orgID, actor, AuditTimeEntryStart, EntityTimeEntry, timeEntry.ID, nil, timeEntry); err != nil {
+		return models.Task{}, err
+	}
+	stored := timeEntry
+	stored.CreatedAt = timeEntry.StartTime
+	m.entries[timeEntry.ID] = &memoryEntry{TimeEntry: stored, orgID: orgID, running: true}
+	task.Version++
+
+	result := task.Task
+	result.StartTime = timeEntry.StartTime
+	return result, nil
+}
+
+func (m *memoryRepo) StopUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID, version int) (models.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, err := m.lockTask(orgID, userID, taskID)
+	if err != nil {
+		return models.Task{}, err
+	}
+	if err = checkVersion("task", task.Version, version); err != nil {
+		return models.Task{}, err
+	}
+	entry := m.runningEntry(taskID)
+	if entry == nil || entry.orgID != orgID {
+		return models.Task{}, apperr.Conflict("task_not_active", "task is not active")
+	}
+
+	timeEntry := models.TimeEntry{ID: entry.ID, TaskID: taskID, StartTime: entry.StartTime, EndTime: now(),
+		Version: entry.Version + 1}
+	timeEntry.Duration = timeEntry.EndTime.Sub(timeEntry.StartTime)
+
+	before := timeEntry
+	before.EndTime, before.Duration, before.Version = time.Time{}, 0, timeEntry.Version-1
+	if err = m.audit(orgID, actor, AuditTimeEntryStop, EntityTimeEntry, timeEntry.ID, before, timeEntry); err != nil {
+		return models.Task{}, err
+	}
+	entry.EndTime, entry.Duration, entry.Version, entry.running = timeEntry.EndTime, timeEntry.Duration, timeEntry.Version, false
+	task.Version++
+
+	result := task.Task
+	result.StartTime = timeEntry.StartTime
+	result.EndTime = timeEntry.EndTime
+	return result, nil
+}
+
+func (m *memoryRepo) DeleteUser(ctx context.Context, orgID int, actor models.Actor, id, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.activeUser(orgID, id)
+	if err != nil {
+		return err
+	}
+	if err = checkVersion("user", u.Version, version); err != nil {
+		return err
+	}
+
+	before := cloneUser(*u)
+	deleted := cloneUser(*u)
+	deletedAt := now()
+	deleted.DeletedAt, deleted.UpdatedAt = &deletedAt, deletedAt
+	deleted.Version++
+	if err = m.audit(orgID, actor, AuditUserDelete, EntityUser, id, before, deleted); err != nil {
+		return err
+	}
+	*u = deleted
+
+	return nil
+}
+
+func (m *memoryRepo) RestoreUser(ctx context.Context, orgID int, actor models.Actor, id, version int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || u.OrganizationID != orgID || u.DeletedAt == nil {
+		return models.User{}, errUserNotFound().Wrap(sql.ErrNoRows)
+	}
+	if err := checkVersion("user", u.Version, version); err != nil {
+		return models.User{}, err
+	}
+
+	restored := cloneUser(*u)
+	restored.DeletedAt, restored.UpdatedAt = nil, now()
+	restored.Version++
+	if m.passportTaken(restored) {
+		return models.User{}, errPassportTaken()
+	}
+	if err := m.audit(orgID, actor, AuditUserRestore, EntityUser, id, nil, restored); err != nil {
+		return models.User{}, err
+	}
+	*u = restored
+
+	return cloneUser(restored), nil
+}
+
+func (m *memoryRepo) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged []*models.User
+	for _, u := range m.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(deletedBefore) {
+			purged = append(purged, u)
+		}
+	}
+	slices.SortFunc(purged, func(a, b *models.User) int { return cmp.Compare(a.ID, b.ID) })
+
+	// The purge is recorded without a snapshot: keeping the personal data in
+	// the audit log would defeat the point of removing it.
+	for _, u := range purged {
+		if err := m.audit(u.OrganizationID, models.Actor{}, AuditUserPurge, EntityUser, u.ID, nil, nil); err != nil {
+			return 0, err
+		}
+		delete(m.users, u.ID)
+		for id, task := range m.tasks {
+			if task.UserID != u.ID {
+				continue
+			}
+			delete(m.tasks, id)
+			for entryID, e := range m.entries {
+				if e.TaskID == id {
+					delete(m.entries, entryID)
+				}
+			}
+		}
+	}
+
+	return len(purged), nil
+}
+
+func (m *memoryRepo) UpdateUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.activeUser(orgID, user.ID)
+	if err != nil {
+		return user, err
+	}
+	if err = checkVersion("user", u.Version, user.Version); err != nil {
+		return user, err
+	}
+
+	before := cloneUser(*u)
+	updated := cloneUser(*u)
+	updated.PassportNumber, updated.Surname, updated.Name = user.PassportNumber, user.Surname, user.Name
+	updated.Patronymic, updated.Address, updated.Role = user.Patronymic, user.Address, user.Role
+	updated.Team, updated.Privileges = user.Team, slices.Clone(privileges(user))
+	updated.UpdatedAt = now()
+	updated.Version++
+	if m.passportTaken(updated) {
+		return user, errPassportTaken()
+	}
+	if err = m.audit(orgID, actor, AuditUserUpdate, EntityUser, user.ID, before, updated); err != nil {
+		return user, err
+	}
+	*u = updated
+
+	return cloneUser(updated), nil
+}
+
+func (m *memoryRepo) User(ctx context.Context, orgID, id int) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, err := m.activeUser(orgID, id)
+	if err != nil {
+		return models.User{}, err
+	}
+	return cloneUser(*u), nil
+}
+
+func (m *memoryRepo) UserIdentity(ctx context.Context, id int) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok || u.DeletedAt != nil {
+		return models.User{}, sql.ErrNoRows
+	}
+	return models.User{ID: u.ID, OrganizationID: u.OrganizationID, Role: u.Role, Team: u.Team,
+		Privileges: slices.Clone(privileges(*u))}, nil
+}
+
+func (m *memoryRepo) TimerStats(ctx context.Context, since time.Time) (models.TimerStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var stats models.TimerStats
+	for _, e := range m.entries {
+		if e.running {
+			stats.Running++
+		}
+		if !e.StartTime.Before(since) {
+			stats.Started++
+		}
+	}
+	return stats, nil
+}
+
+// AddTask creates a task for a user. Tasks are not created through the API,
+// so this is how the demo mode and tests set them up.
+func (m *memoryRepo) AddTask(ctx context.Context, orgID, userID int, description string) (models.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[userID]; !ok || u.OrganizationID != orgID {
+		return models.Task{}, errUserNotFound()
+	}
+	task := models.Task{ID: m.nextID("tasks"), UserID: userID, Description: description, CreatedAt: now(), Version: 1}
+	m.tasks[task.ID] = &memoryTask{Task: task, orgID: orgID}
+
+	return task, nil
+}
+
+func (m *memoryRepo) AddAPIKey(ctx context.Context, orgID int, actor models.Actor, key models.APIKey,
+	keyHash string) (models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.hash == keyHash {
+			return key, errors.New("api key hash already exists")
+		}
+	}
+	key.ID = m.nextID("api_keys")
+	key.CreatedAt = now()
+	key.RevokedAt = nil
+	if err := m.audit(orgID, actor, AuditAPIKeyIssue, EntityAPIKey, key.ID, nil, key); err != nil {
+		return key, err
+	}
+	m.apiKeys[key.ID] = &memoryAPIKey{APIKey: key, orgID: orgID, hash: keyHash}
+
+	return key, nil
+}
+
+func (m *memoryRepo) APIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.apiKeys {
+		if k.hash == keyHash && k.RevokedAt == nil {
+			return k.APIKey, nil
+		}
+	}
+	return models.APIKey{}, sql.ErrNoRows
+}
+
+func (m *memoryRepo) APIKey(ctx context.Context, orgID, id int) (models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok || k.orgID != orgID {
+		return models.APIKey{}, errAPIKeyNotFound().Wrap(sql.ErrNoRows)
+	}
+	return cloneAPIKey(k.APIKey), nil
+}
+
+func cloneAPIKey(k models.APIKey) models.APIKey {
+	if k.RevokedAt != nil {
+		revokedAt := *k.RevokedAt
+		k.RevokedAt = &revokedAt
+	}
+	return k
+}
+
+func (m *memoryRepo) RevokeAPIKey(ctx context.Context, orgID int, actor models.Actor, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok || k.orgID != orgID || k.RevokedAt != nil {
+		return errAPIKeyNotFound().Wrap(sql.ErrNoRows)
+	}
+
+	before := k.APIKey
+	revoked := k.APIKey
+	revokedAt := now()
+	revoked.RevokedAt = &revokedAt
+	if err := m.audit(orgID, actor, AuditAPIKeyRevoke, EntityAPIKey, id, before, revoked); err != nil {
+		return err
+	}
+	k.APIKey = revoked
+
+	return nil
+}
+
+func (m *memoryRepo) AuditEvents(ctx context.Context, orgID int, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matching []models.AuditEvent
+	for i := len(m.auditLog) - 1; i >= 0; i-- {
+		e := m.auditLog[i]
+		if e.OrganizationID != orgID ||
+			filter.ActorID != 0 && (e.ActorID == nil || *e.ActorID != filter.ActorID) ||
+			filter.Action != "" && e.Action != filter.Action ||
+			filter.EntityType != "" && e.EntityType != filter.EntityType ||
+			filter.EntityID != 0 && e.EntityID != filter.EntityID ||
+			!filter.From.IsZero() && e.CreatedAt.Before(filter.From) ||
+			!filter.To.IsZero() && !e.CreatedAt.Before(filter.To) {
+			continue
+		}
+		matching = append(matching, e)
+	}
+
+	offset := min((filter.Page-1)*filter.Limit, len(matching))
+	matching = matching[offset:min(offset+filter.Limit, len(matching))]
+	if len(matching) == 0 {
+		return nil, nil
+	}
+	return slices.Clone(matching), nil
+}
+
+func (m *memoryRepo) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := idempotencyKey{record.OrganizationID, record.Subject, record.Key}
+	if stored, ok := m.idempotency[k]; ok && stored.ExpiresAt.After(time.Now()) {
+		return stored, false, nil
+	}
+
+	record.Status, record.Header, record.Body = 0, nil, nil
+	record.CreatedAt = now()
+	m.idempotency[k] = record
+
+	return record, true, nil
+}
+
+func (m *memoryRepo) CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := idempotencyKey{record.OrganizationID, record.Subject, record.Key}
+	stored, ok := m.idempotency[k]
+	if !ok {
+		return nil
+	}
+	stored.Status, stored.Header, stored.Body = record.Status, record.Header, slices.Clone(record.Body)
+	m.idempotency[k] = stored
+
+	return nil
+}
+
+func (m *memoryRepo) ReleaseIdempotencyKey(ctx context.Context, orgID int, subject, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.idempotency, idempotencyKey{orgID, subject, key})
+	return nil
+}
+
+func (m *memoryRepo) PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for k, record := range m.idempotency {
+		if record.ExpiresAt.Before(expiredBefore) {
+			delete(m.idempotency, k)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *memoryRepo) PersonalData(ctx context.Context, orgID, userID int) (models.PersonalData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data := models.PersonalData{GeneratedAt: time.Now(), Tasks: []models.Task{}, TimeEntries: []models.TimeEntry{},
+		AuditEvents: []models.AuditEvent{}, DataRequests: []models.DataRequest{}}
+
+	u, ok := m.users[userID]
+	if !ok || u.OrganizationID != orgID {
+		return data, errUserNotFound().Wrap(sql.ErrNoRows)
+	}
+	data.User = cloneUser(*u)
+
+	entryIDs := map[int]bool{}
+	for _, task := range m.tasks {
+		if task.orgID == orgID && task.UserID == userID {
+			data.Tasks = append(data.Tasks, task.Task)
+		}
+	}
+	for _, e := range m.entries {
+		if task, ok := m.tasks[e.TaskID]; ok && e.orgID == orgID && task.UserID == userID {
+			data.TimeEntries = append(data.TimeEntries, e.TimeEntry)
+			entryIDs[e.ID] = true
+		}
+	}
+	slices.SortFunc(data.Tasks, func(a, b models.Task) int { return cmp.Compare(a.ID, b.ID) })
+	slices.SortFunc(data.TimeEntries, func(a, b models.TimeEntry) int { return cmp.Compare(a.ID, b.ID) })
+
+	for _, e := range m.auditLog {
+		if e.OrganizationID == orgID && (e.ActorID != nil && *e.ActorID == userID ||
+			e.EntityType == EntityUser && e.EntityID == userID ||
+			e.EntityType == EntityTimeEntry && entryIDs[e.EntityID]) {
+			data.AuditEvents = append(data.AuditEvents, e)
+		}
+	}
+
+	for _, r := range m.dataRequests {
+		if r.OrganizationID == orgID && r.UserID == userID {
+			data.DataRequests = append(data.DataRequests, cloneDataRequest(*r))
+		}
+	}
+	slices.SortFunc(data.DataRequests, func(a, b models.DataRequest) int { return cmp.Compare(a.ID, b.ID) })
+
+	return data, nil
+}
+
+func cloneDataRequest(r models.DataRequest) models.DataRequest {
+	if r.RequestedBy != nil {
+		requestedBy := *r.RequestedBy
+		r.RequestedBy = &requestedBy
+	}
+	if r.CompletedAt != nil {
+		completedAt := *r.CompletedAt
+		r.CompletedAt = &completedAt
+	}
+	return r
+}
+
+func (m *memoryRepo) AddDataRequest(ctx context.Context, orgID int, actor models.Actor, userID int,
+	requestType string) (models.DataRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := models.DataRequest{ID: m.nextID("data_subject_requests"), OrganizationID: orgID, UserID: userID, Type: requestType,
+		RequestID: actor.RequestID, RequestedAt: now()}
+	if actor.UserID != 0 {
+		requestedBy := actor.UserID
+		r.RequestedBy = &requestedBy
+	}
+	m.dataRequests[r.ID] = &r
+
+	return cloneDataRequest(r), nil
+}
+
+func (m *memoryRepo) CompleteDataRequest(ctx context.Context, orgID, id int) (models.DataRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.completeDataRequest(orgID, id)
+}
+
+func (m *memoryRepo) completeDataRequest(orgID, id int) (models.DataRequest, error) {
+	r, ok := m.dataRequests[id]
+	if !ok || r.OrganizationID != orgID || r.CompletedAt != nil {
+		return models.DataRequest{}, sql.ErrNoRows
+	}
+	completedAt := now()
+	r.CompletedAt = &completedAt
+
+	return cloneDataRequest(*r), nil
+}
+
+func (m *memoryRepo) EraseUser(ctx context.Context, orgID int, actor models.Actor, userID,
+	dataRequestID int) (models.DataRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok || u.OrganizationID != orgID || u.ErasedAt != nil {
+		return models.DataRequest{}, apperr.NotFound("user_not_found", "user not found or already erased").Wrap(sql.ErrNoRows)
+	}
+	// Everything below happens together or not at all, as in the
+	// transaction of postgresRepo, so the data request is checked first.
+	if r, ok := m.dataRequests[dataRequestID]; !ok || r.OrganizationID != orgID || r.CompletedAt != nil {
+		return models.DataRequest{}, sql.ErrNoRows
+	}
+
+	erased := cloneUser(*u)
+	erasedAt := now()
+	erased.PassportNumber, erased.Surname, erased.Name, erased.Patronymic, erased.Address = "", ErasedValue, ErasedValue, "", ErasedValue
+	erased.ErasedAt, erased.UpdatedAt = &erasedAt, erasedAt
+	erased.Version++
+
+	redaction := map[string]interface{}{
+		"passportNumber": "",
+		"surname":        ErasedValue,
+		"name":           ErasedValue,
+		"patronymic":     "",
+		"address":        ErasedValue,
+	}
+	for i, e := range m.auditLog {
+		if e.OrganizationID != orgID || e.EntityType != EntityUser || e.EntityID != userID {
+			continue
+		}
+		var err error
+		if m.auditLog[i].Before, err = redactSnapshot(e.Before, redaction); err != nil {
+			return models.DataRequest{}, err
+		}
+		if m.auditLog[i].After, err = redactSnapshot(e.After, redaction); err != nil {
+			return models.DataRequest{}, err
+		}
+	}
+
+	subject := strconv.Itoa(userID)
+	for _, k := range m.apiKeys {
+		if k.orgID == orgID && k.Subject == subject && k.RevokedAt == nil {
+			revokedAt := erasedAt
+			k.RevokedAt = &revokedAt
+		}
+	}
+
+	if err := m.audit(orgID, actor, AuditUserErase, EntityUser, userID, nil, erased); err != nil {
+		return models.DataRequest{}, err
+	}
+	*u = erased
+
+	return m.completeDataRequest(orgID, dataRequestID)
+}
+
+// redactSnapshot overwrites fields of a stored snapshot like the jsonb ||
+// operator does.
+func redactSnapshot(raw json.RawMessage, redaction map[string]interface{}) (json.RawMessage, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for field, value := range redaction {
+		fields[field] = value
+	}
+	return json.Marshal(fields)
+}
+
+func (m *memoryRepo) Search(ctx context.Context, orgID int, text string, scope models.UserQuery, addresses bool,
+	limit int) (models.SearchResults, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := models.SearchResults{Query: text, Users: []models.SearchHit{}, Tasks: []models.SearchHit{}}
+
+	users, err := m.selectUsers(orgID, scope)
+	if err != nil {
+		return results, err
+	}
+	query := searchWords(text)
+	inScope := map[int]bool{}
+	for _, u := range users {
+		inScope[u.ID] = true
+		if u.ErasedAt != nil {
+			continue
+		}
+		fullName := u.Surname + " " + u.Name + " " + u.Patronymic
+		hit, ok := searchHit(models.SearchHitUser, models.SearchFieldName, u.ID, u.ID, fullName, query)
+		if !ok && addresses {
+			hit, ok = addressHit(u.ID, u.Address, query)
+		}
+		if ok {
+			results.Users = append(results.Users, hit)
+		}
+	}
+	for _, task := range m.tasks {
+		if task.orgID != orgID || !inScope[task.UserID] {
+			continue
+		}
+		if hit, ok := searchHit(models.SearchHitTask, models.SearchFieldDescription, task.ID, task.UserID, task.Description, query); ok {
+			results.Tasks = append(results.Tasks, hit)
+		}
+	}
+
+	results.Users = bestHits(results.Users, limit)
+	results.Tasks = bestHits(results.Tasks, limit)
+	return results, nil
+}
+
+// searchWords splits text into lowercase words.
+func searchWords(text string) []string {
+	var words []string
+	for _, span := range wordSpans(text) {
+		words = append(words, strings.ToLower(text[span[0]:span[1]]))
+	}
+	return words
+}
+
+// wordSpans returns the start and end of every run of letters and digits
+// in text.
+func wordSpans(text string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range text {
+		isWordChar := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWordChar && start < 0:
+			start = i
+		case !isWordChar && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// searchHit matches text against the words of a query. Every query word
+// must equal a word of the text, ignoring case, or, standing in for trigram
+// similarity, be one edit away from one. Exact words rank higher.
+func searchHit(hitType, field string, id, userID int, text string, query []string) (models.SearchHit, bool) {
+	if len(query) == 0 {
+		return models.SearchHit{}, false
+	}
+
+	spans := wordSpans(text)
+	marked := make([]bool, len(spans))
+	rank := 0.0
+	for _, q := range query {
+		best := 0.0
+		for i, span := range spans {
+			word := strings.ToLower(text[span[0]:span[1]])
+			score := 0.0
+			switch {
+			case word == q:
+				score = 1
+			case len([]rune(q)) > 3 && withinOneEdit([]rune(word), []rune(q)):
+				score = 0.5
+			}
+			if score > 0 {
+				marked[i] = true
+				best = max(best, score)
+			}
+		}
+		if best == 0 {
+			return models.SearchHit{}, false
+		}
+		rank += best
+	}
+
+	var highlight strings.Builder
+	last := 0
+	for i, span := range spans {
+		if !marked[i] {
+			continue
+		}
+		highlight.WriteString(html.EscapeString(text[last:span[0]]))
+		highlight.WriteString("<mark>" + html.EscapeString(text[span[0]:span[1]]) + "</mark>")
+		last = span[1]
+	}
+	highlight.WriteString(html.EscapeString(text[last:]))
+
+	return models.SearchHit{Type: hitType, Field: field, ID: id, UserID: userID, Text: text, Highlight: highlight.String(),
+		Rank: rank / float64(len(query))}, true
+}
+
+// withinOneEdit tells whether a and b differ by at most one inserted,
+// deleted or replaced rune.
+func withinOneEdit(a, b []rune) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+	if len(a) == len(b) {
+		return slices.Equal(a[min(i+1, len(a)):], b[min(i+1, len(b)):])
+	}
+	return slices.Equal(a[i:], b[i+1:])
+}
+
+// bestHits orders hits by rank, best first, and keeps at most limit of them.
+func bestHits(hits []models.SearchHit, limit int) []models.SearchHit {
+	slices.SortFunc(hits, func(a, b models.SearchHit) int {
+		return cmp.Or(cmp.Compare(b.Rank, a.Rank), cmp.Compare(a.ID, b.ID))
+	})
+	return hits[:min(limit, len(hits))]
+}
@@ -0,0 +1,288 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/models"
+)
+
+const (
+	AuditUserErase = "user.erase"
+
+	// ErasedValue replaces personal fields of erased users.
+	ErasedValue = "[erased]"
+)
+
+type PersonalDataRepository interface {
+	// PersonalData collects everything stored about a user, including a
+	// soft-deleted one.
+	PersonalData(ctx context.Context, orgID, userID int) (models.PersonalData, error)
+	AddDataRequest(ctx context.Context, orgID int, actor models.Actor, userID int, requestType string) (models.DataRequest, error)
+	CompleteDataRequest(ctx context.Context, orgID, id int) (models.DataRequest, error)
+	// EraseUser anonymises the personal fields of a user, redacts them from
+	// the audit log and revokes the user's API keys, leaving tasks and time
+	// entries in place for accounting. The data request is completed in the
+	// same transaction.
+	EraseUser(ctx context.Context, orgID int, actor models.Actor, userID, dataRequestID int) (models.DataRequest, error)
+}
+
+const dataRequestColumns = `id, organization_id, user_id, type, requested_by, COALESCE(request_id, ''),
+	requested_at, completed_at`
+
+func scanDataRequest(row scanner) (models.DataRequest, error) {
+	var r models.DataRequest
+	err := row.Scan(&r.ID, &r.OrganizationID, &r.UserID, &r.Type, &r.RequestedBy, &r.RequestID,
+		&r.RequestedAt, &r.CompletedAt)
+	return r, err
+}
+
+func (p *postgresRepo) PersonalData(ctx context.Context, orgID, userID int) (models.PersonalData, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Bulk)
+	defer cancel()
+
+	data := models.PersonalData{GeneratedAt: time.Now()}
+
+	var err error
+	data.User, err = p.scanUser(p.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1 AND organization_id = $2`,
+		userID, orgID))
+	if err != nil {
+		return data, notFound(err, errUserNotFound)
+	}
+
+	if data.Tasks, err = p.userTasks(ctx, orgID, userID); err != nil {
+		return data, err
+	}
+	if data.TimeEntries, err = p.userTimeEntries(ctx, orgID, userID); err != nil {
+		return data, err
+	}
+	if data.AuditEvents, err = p.userAuditEvents(ctx, orgID, userID); err != nil {
+		return data, err
+	}
+	if data.DataRequests, err = p.userDataRequests(ctx, orgID, userID); err != nil {
+		return data, err
+	}
+
+	return data, nil
+}
+
+func (p *postgresRepo) userTasks(ctx context.Context, orgID, userID int) ([]models.Task, error) {
+	query := `
+		SELECT id, user_id, description, created_at, version
+		FROM tasks
+		WHERE organization_id = $1 AND user_id = $2
+		ORDER BY id`
+
+	rows, err := p.db.QueryContext(ctx, query, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []models.Task{}
+	for rows.Next() {
+		var t models.Task
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Description, &t.CreatedAt, &t.Version); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+
+	return tasks, rows.Err()
+}
+
+func (p *postgresRepo) userTimeEntries(ctx context.Context, orgID, userID int) ([]models.TimeEntry, error) {
+	query := `
+		SELECT te.id, te.task_id, te.start_time, te.end_time, COALESCE(EXTRACT(EPOCH FROM te.duration), 0), te.created_at,
+			te.version
+		FROM time_entries te
+		JOIN tasks t ON t.id = te.task_id
+		WHERE te.organization_id = $1 AND t.user_id = $2
+		ORDER BY te.id`
+
+	rows, err := p.db.QueryContext(ctx, query, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.TimeEntry{}
+	for rows.Next() {
+		var e models.TimeEntry
+		var endTime sql.NullTime
+		var seconds float64
+		if err := rows.Scan(&e.ID, &e.TaskID, &e.StartTime, &endTime, &seconds, &e.CreatedAt, &e.Version); err != nil {
+			return nil, err
+		}
+		e.EndTime = endTime.Time
+		e.Duration = time.Duration(seconds * float64(time.Second))
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func (p *postgresRepo) userAuditEvents(ctx context.Context, orgID, userID int) ([]models.AuditEvent, error) {
+	query := `
+		SELECT id, organization_id, actor_id, action, entity_type, entity_id, before, after,
+			COALESCE(request_id, ''), created_at
+		FROM audit_log
+		WHERE organization_id = $1 AND (
+			actor_id = $2
+			OR (entity_type = 'user' AND entity_id = $2)
+			OR (entity_type = 'time_entry' AND entity_id IN (
+				SELECT te.id FROM time_entries te JOIN tasks t ON t.id = te.task_id WHERE t.user_id = $2)))
+		ORDER BY id`
+
+	rows, err := p.db.QueryContext(ctx, query, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var e models.AuditEvent
+		var before, after []byte
+		err := rows.Scan(&e.ID, &e.OrganizationID, &e.ActorID, &e.Action, &e.EntityType, &e.EntityID,
+			&before, &after, &e.RequestID, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if e.Before, err = p.openSnapshot(before); err != nil {
+			return nil, err
+		}
+		if e.After, err = p.openSnapshot(after); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func (p *postgresRepo) userDataRequests(ctx context.Context, orgID, userID int) ([]models.DataRequest, error) {
+	query := `SELECT ` + dataRequestColumns + ` FROM data_subject_requests
+		WHERE organization_id = $1 AND user_id = $2 ORDER BY id`
+
+	rows, err := p.db.QueryContext(ctx, query, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.DataRequest{}
+	for rows.Next() {
+		r, err := scanDataRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+
+	return requests, rows.Err()
+}
+
+func (p *postgresRepo) AddDataRequest(ctx context.Context, orgID int, actor models.Actor, userID int,
+	requestType string) (models.DataRequest, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	query := `
+		INSERT INTO data_subject_requests (organization_id, user_id, type, requested_by, request_id)
+		VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''))
+		RETURNING ` + dataRequestColumns
+
+	return scanDataRequest(p.db.QueryRowContext(ctx, query, orgID, userID, requestType, actor.UserID, actor.RequestID))
+}
+
+func (p *postgresRepo) CompleteDataRequest(ctx context.Context, orgID, id int) (models.DataRequest, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	return completeDataRequest(ctx, p.db, orgID, id)
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func completeDataRequest(ctx context.Context, q queryRower, orgID, id int) (models.DataRequest, error) {
+	query := `
+		UPDATE data_subject_requests SET completed_at = $1
+		WHERE id = $2 AND organization_id = $3 AND completed_at IS NULL
+		RETURNING ` + dataRequestColumns
+
+	return scanDataRequest(q.QueryRowContext(ctx, query, time.Now(), id, orgID))
+}
+
+func (p *postgresRepo) EraseUser(ctx context.Context, orgID int, actor models.Actor, userID,
+	dataRequestID int) (models.DataRequest, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Bulk)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.DataRequest{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET passport_number = NULL, passport_number_index = NULL, surname = $1, name = $1, patronymic = NULL, address = $1,
+			address_tokens = NULL, erased_at = $2, updated_at = $2, version = version + 1
+		WHERE id = $3 AND organization_id = $4 AND erased_at IS NULL
+		RETURNING ` + userColumns
+
+	erased, err := p.scanUser(tx.QueryRowContext(ctx, query, ErasedValue, time.Now(), userID, orgID))
+	if err != nil {
+		return models.DataRequest{}, notFound(err, func() *apperr.Error {
+			return apperr.NotFound("user_not_found", "user not found or already erased")
+		})
+	}
+
+	if _, err = tx.ExecContext(ctx, `SET LOCAL audit_log.redaction = 'on'`); err != nil {
+		return models.DataRequest{}, err
+	}
+	redact := `
+		UPDATE audit_log
+		SET before = CASE WHEN before IS NULL THEN NULL ELSE before || $1::jsonb END,
+			after = CASE WHEN after IS NULL THEN NULL ELSE after || $1::jsonb END
+		WHERE organization_id = $2 AND entity_type = 'user' AND entity_id = $3`
+	redaction, err := json.Marshal(map[string]string{
+		"passportNumber": "",
+		"surname":        ErasedValue,
+		"name":           ErasedValue,
+		"patronymic":     "",
+		"address":        ErasedValue,
+	})
+	if err != nil {
+		return models.DataRequest{}, err
+	}
+	if _, err = tx.ExecContext(ctx, redact, string(redaction), orgID, userID); err != nil {
+		return models.DataRequest{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE api_keys SET revoked_at = $1 WHERE organization_id = $2 AND subject = $3 AND revoked_at IS NULL`,
+		time.Now(), orgID, erased.ID)
+	if err != nil {
+		return models.DataRequest{}, err
+	}
+
+	if err = p.audit(ctx, tx, orgID, actor, AuditUserErase, EntityUser, userID, nil, erased); err != nil {
+		return models.DataRequest{}, err
+	}
+
+	request, err := completeDataRequest(ctx, tx, orgID, dataRequestID)
+	if err != nil {
+		return models.DataRequest{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.DataRequest{}, err
+	}
+
+	return request, nil
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"timeTracker/internal/ratelimit"
+)
+
+// RateLimitRepository stores rate limiter buckets so that several instances
+// of the application share them. It is optional and not part of Repository.
+type RateLimitRepository interface {
+	TakeRateLimitToken(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error)
+	// PurgeRateLimitBuckets removes buckets last used before the given time.
+	PurgeRateLimitBuckets(ctx context.Context, idleSince time.Time) (int, error)
+}
+
+func (p *postgresRepo) TakeRateLimitToken(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	defer tx.Rollback()
+
+	// The row is created full and then locked, so that concurrent requests
+	// of the same client take their tokens one after the other.
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO NOTHING`, key, float64(limit.Burst), now)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	var bucket ratelimit.Bucket
+	err = tx.QueryRowContext(ctx, `SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE`, key).
+		Scan(&bucket.Tokens, &bucket.UpdatedAt)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	bucket, result := limit.Take(bucket, now)
+	_, err = tx.ExecContext(ctx, `UPDATE rate_limit_buckets SET tokens = $1, updated_at = $2 WHERE key = $3`,
+		bucket.Tokens, bucket.UpdatedAt, key)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	return result, tx.Commit()
+}
+
+func (p *postgresRepo) PurgeRateLimitBuckets(ctx context.Context, idleSince time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Bulk)
+	defer cancel()
+
+	result, err := p.db.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE updated_at < $1`, idleSince)
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
@@ -1,113 +1,310 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
 	"time"
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/fieldcrypt"
 	"timeTracker/internal/models"
 
-	_ "github.com/lib/pq"
+	"github.com/XSAM/otelsql"
+	"github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
+// Repository methods taking an orgID only ever read or modify rows that
+// belong to that organization. Every write is recorded in the audit log on
+// behalf of the given actor.
 type Repository interface {
-	AddUser(user models.User) (models.User, error)
-	GetUsers(page, limit int, filters map[string]string) ([]models.User, error)
-	GetUserWorkload(userID int, start, end time.Time) ([]models.Workload, error)
-	StartUserTask(userID, taskID int) (models.Task, error)
-	StopUserTask(userID, taskID int) (models.Task, error)
-	DeleteUser(id int) error
-	UpdateUser(user models.User) (models.User, error)
-	User(id int) (models.User, error)
+	AddUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (models.User, error)
+	GetUsers(ctx context.Context, orgID int, page models.Pagination, query models.UserQuery) (models.UserPage, error)
+	GetUserWorkload(ctx context.Context, orgID, userID int, start, end time.Time) ([]models.Workload, error)
+	// StartUserTask and StopUserTask fail with a precondition error when
+	// version is non-zero and differs from the current version of the task.
+	StartUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID, version int) (models.Task, error)
+	StopUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID, version int) (models.Task, error)
+	// DeleteUser soft-deletes a user, keeping their tasks and time entries
+	// until the user is restored or purged. A non-zero version must match the
+	// current version of the user.
+	DeleteUser(ctx context.Context, orgID int, actor models.Actor, id, version int) error
+	RestoreUser(ctx context.Context, orgID int, actor models.Actor, id, version int) (models.User, error)
+	// PurgeDeletedUsers permanently removes users of every organization that
+	// were soft-deleted before the given time.
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error)
+	// UpdateUser replaces the user, checking user.Version against the current
+	// version unless it is zero.
+	UpdateUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (models.User, error)
+	User(ctx context.Context, orgID, id int) (models.User, error)
+	// UserIdentity looks a user up across all organizations and is meant
+	// only for resolving an authenticated principal.
+	UserIdentity(ctx context.Context, id int) (models.User, error)
+	APIKeyRepository
+	AuditRepository
+	IdempotencyRepository
+	PersonalDataRepository
+	SearchRepository
+	// TimerStats counts running timers and time entries started since the
+	// given time in every organization, for monitoring.
+	TimerStats(ctx context.Context, since time.Time) (models.TimerStats, error)
+}
+
+const userColumns = `id, organization_id, COALESCE(passport_number, ''), surname, name, COALESCE(patronymic, ''), address,
+	role, COALESCE(team, ''), privileges, created_at, updated_at, version, deleted_at, erased_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads a row selected with userColumns, decrypting the fields that
+// are encrypted at rest.
+func (p *postgresRepo) scanUser(row scanner) (models.User, error) {
+	var u models.User
+	err := row.Scan(&u.ID, &u.OrganizationID, &u.PassportNumber, &u.Surname, &u.Name, &u.Patronymic, &u.Address,
+		&u.Role, &u.Team, pq.Array(&u.Privileges), &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.DeletedAt, &u.ErasedAt)
+	if err != nil {
+		return u, err
+	}
+
+	return p.openUser(u)
+}
+
+// sealer encrypts and decrypts the fields of users that are encrypted at rest,
+// for every backend that persists them.
+type sealer struct {
+	keys *fieldcrypt.Keyring
+}
+
+func (s sealer) openUser(u models.User) (models.User, error) {
+	var err error
+	if u.PassportNumber, err = s.keys.Decrypt(u.PassportNumber); err != nil {
+		return u, fmt.Errorf("error decrypting passport number of user %d: %w", u.ID, err)
+	}
+	if u.Address, err = s.keys.Decrypt(u.Address); err != nil {
+		return u, fmt.Errorf("error decrypting address of user %d: %w", u.ID, err)
+	}
+
+	return u, nil
+}
+
+func (s sealer) sealUser(u models.User) (models.User, error) {
+	var err error
+	if u.PassportNumber, err = s.keys.Encrypt(u.PassportNumber); err != nil {
+		return u, fmt.Errorf("error encrypting passport number: %w", err)
+	}
+	if u.Address, err = s.keys.Encrypt(u.Address); err != nil {
+		return u, fmt.Errorf("error encrypting address: %w", err)
+	}
+
+	return u, nil
+}
+
+// privileges returns the user's privileges as a non-nil slice, since the
+// column is NOT NULL and pq.Array stores a nil slice as NULL.
+func privileges(u models.User) []string {
+	if u.Privileges == nil {
+		return []string{}
+	}
+	return u.Privileges
 }
 
 type postgresRepo struct {
 	db *sql.DB
+	sealer
+	timeouts Timeouts
 }
 
-func NewPostgresRepo(host, port, user, password, dbname string) *postgresRepo {
+// Timeouts bound database operations by kind. A zero timeout leaves the
+// operation bounded only by the caller's context.
+type Timeouts struct {
+	// Connect is how long connecting is retried on startup.
+	Connect time.Duration
+	Read    time.Duration
+	Write   time.Duration
+	// Bulk applies to exports, erasure and the purge and encryption jobs,
+	// which touch many rows at once.
+	Bulk time.Duration
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// NewPostgresRepo connects to the database, retrying with exponential backoff
+// for up to timeouts.Connect so that the application can start before the
+// database is up.
+func NewPostgresRepo(host, port, user, password, dbname string, keys *fieldcrypt.Keyring,
+	timeouts Timeouts) (*postgresRepo, error) {
 	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s "+
 		"password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
-	db, err := sql.Open("postgres", psqlInfo)
+	return openPostgres(psqlInfo, keys, timeouts)
+}
+
+func openPostgres(psqlInfo string, keys *fieldcrypt.Keyring, timeouts Timeouts) (*postgresRepo, error) {
+	// Every query gets a span of its own, named after the database/sql
+	// method and carrying the statement but not its arguments.
+	db, err := otelsql.Open("postgres", psqlInfo, otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
-		panic(err)
+	if err := connect(db, timeouts.Connect); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	return &postgresRepo{db: db}
+	return &postgresRepo{db: db, sealer: sealer{keys}, timeouts: timeouts}, nil
 }
 
-func (p *postgresRepo) AddUser(user models.User) (models.User, error) {
-	query := `
-		INSERT INTO users (passport_number, surname, name, patronymic, address)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`
+const (
+	initialConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff     = 10 * time.Second
+)
+
+// connect pings the database until it answers or the timeout passes,
+// doubling the wait between attempts.
+func connect(db *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := initialConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.Ping()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("error connecting to database after %d attempts: %w", attempt, err)
+		}
+		log.Printf("Database not reachable, retrying in %s: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxConnectBackoff)
+	}
+}
 
-	err := p.db.QueryRow(query, user.PassportNumber, user.Surname, user.Name, user.Patronymic, user.Address).Scan(&user.ID)
+func (p *postgresRepo) AddUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
-		return user, fmt.Errorf("error adding user to database: %w", err)
+		return user, err
 	}
+	defer tx.Rollback()
 
-	return user, nil
-}
+	sealed, err := p.sealUser(user)
+	if err != nil {
+		return user, err
+	}
 
-// TODO: making page & limit optional
-func (p *postgresRepo) GetUsers(page, limit int, filters map[string]string) ([]models.User, error) {
-	query := `SELECT id, passport_number, surname, name, patronymic, address FROM users WHERE 1=1`
+	query := `
+		INSERT INTO users (organization_id, passport_number, passport_number_index, surname, name, patronymic, address,
+			address_tokens, role, team, privileges)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, NULLIF($10, ''), $11)
+		RETURNING ` + userColumns
+
+	created, err := p.scanUser(tx.QueryRowContext(ctx, query, orgID, sealed.PassportNumber, p.keys.BlindIndex(user.PassportNumber),
+		user.Surname, user.Name, user.Patronymic, sealed.Address, pq.Array(p.addressTokens(user.Address)), user.Role,
+		user.Team, pq.Array(privileges(user))))
+	if err != nil {
+		return user, fmt.Errorf("error adding user to database: %w", conflict(err))
+	}
 
-	var whereParams []interface{}
-	paramCounter := 1
+	if err = p.audit(ctx, tx, orgID, actor, AuditUserCreate, EntityUser, created.ID, nil, created); err != nil {
+		return user, err
+	}
 
-	for field, value := range filters {
-		if value != "" {
-			query += fmt.Sprintf(" AND %s ILIKE $%d", field, paramCounter)
-			whereParams = append(whereParams, value+"%")
-			paramCounter++
-		}
+	if err = tx.Commit(); err != nil {
+		return user, err
 	}
 
-	query += fmt.Sprintf(" ORDER BY id LIMIT $%d OFFSET $%d", paramCounter, paramCounter+1)
-	offset := (page - 1) * limit
-	whereParams = append(whereParams, limit, offset)
+	return created, nil
+}
+
+func (p *postgresRepo) GetUsers(ctx context.Context, orgID int, page models.Pagination, query models.UserQuery) (models.UserPage, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	var result models.UserPage
 
-	stmt, err := p.db.Prepare(query)
+	sort, err := userSort(query.Sort)
 	if err != nil {
-		return nil, err
+		return result, err
+	}
+	where, params, err := p.userWhere(orgID, query)
+	if err != nil {
+		return result, err
+	}
+
+	if err = p.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE `+where, params...).Scan(&result.Total); err != nil {
+		return result, err
+	}
+
+	backwards := page.Before != ""
+	if page.After != "" || backwards {
+		c := page.After
+		if backwards {
+			c = page.Before
+		}
+		values, err := decodeCursor(c, sort)
+		if err != nil {
+			return result, err
+		}
+		var condition string
+		condition, params = keyset(sort, values, backwards, params)
+		where += " AND " + condition
 	}
-	defer stmt.Close()
-	rows, err := stmt.Query(whereParams...)
 
+	// One row more than asked for tells whether there is a further page.
+	params = append(params, page.Limit+1)
+	list := fmt.Sprintf(`SELECT `+userColumns+` FROM users WHERE %s ORDER BY %s LIMIT $%d`,
+		where, orderBy(sort, backwards), len(params))
+	if page.After == "" && !backwards {
+		params = append(params, (page.Page-1)*page.Limit)
+		list += fmt.Sprintf(" OFFSET $%d", len(params))
+	}
+
+	rows, err := p.db.QueryContext(ctx, list, params...)
 	if err != nil {
-		return nil, fmt.Errorf(err.Error())
+		return result, err
 	}
+	defer rows.Close()
 
-	var users []models.User
 	for rows.Next() {
-		var u models.User
-		if err := rows.Scan(&u.ID, &u.PassportNumber, &u.Surname, &u.Name, &u.Patronymic, &u.Address); err != nil {
-			return nil, err
+		u, err := p.scanUser(rows)
+		if err != nil {
+			return result, err
 		}
-		users = append(users, u)
+		result.Users = append(result.Users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return result, err
 	}
 
-	return users, nil
+	return finishPage(result, page, sort), nil
 }
-func (p *postgresRepo) GetUserWorkload(userID int, start, end time.Time) ([]models.Workload, error) {
+
+func (p *postgresRepo) GetUserWorkload(ctx context.Context, orgID, userID int, start, end time.Time) ([]models.Workload, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
 	query := `
 	SELECT t.id, t.description, 
 		   ROUND(EXTRACT(EPOCH FROM SUM(te.duration))/3600)::integer AS hours,
 		   ROUND(MOD(EXTRACT(EPOCH FROM SUM(te.duration))::integer, 3600)/60)::integer AS minutes
 	FROM tasks t
 	JOIN time_entries te ON t.id = te.task_id
-	WHERE t.user_id = $1 AND te.start_time >= $2 AND te.end_time <= $3
+	WHERE t.organization_id = $1 AND t.user_id = $2 AND te.start_time >= $3 AND te.end_time <= $4
 	GROUP BY t.id, t.description
 	ORDER BY SUM(te.duration) DESC`
 
-	rows, err := p.db.Query(query, userID, start, end)
+	rows, err := p.db.QueryContext(ctx, query, orgID, userID, start, end)
 	if err != nil {
 		return nil, err
 	}
@@ -124,25 +321,21 @@ func (p *postgresRepo) GetUserWorkload(userID int, start, end time.Time) ([]mode
 
 	return workloads, nil
 }
-func (p *postgresRepo) StartUserTask(userID, taskID int) (models.Task, error) {
-	tx, err := p.db.Begin()
+func (p *postgresRepo) StartUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID, version int) (models.Task, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
 		return models.Task{}, err
 	}
 	defer tx.Rollback()
 
-	var task models.Task
-	taskQuery := `
-    SELECT id, user_id, description, created_at
-    FROM tasks
-    WHERE id = $1 AND user_id = $2`
-
-	err = tx.QueryRow(taskQuery, taskID, userID).
-		Scan(&task.ID, &task.UserID, &task.Description, &task.CreatedAt)
+	task, err := lockTask(ctx, tx, orgID, userID, taskID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return models.Task{}, errors.New("task not found or doesn't belong to the user")
-		}
+		return models.Task{}, err
+	}
+	if err = checkVersion("task", task.Version, version); err != nil {
 		return models.Task{}, err
 	}
 
@@ -152,27 +345,33 @@ func (p *postgresRepo) StartUserTask(userID, taskID int) (models.Task, error) {
     FROM time_entries
     WHERE task_id = $1 AND end_time IS NULL`
 
-	err = tx.QueryRow(checkActiveQuery, taskID).Scan(&activeEntries)
+	err = tx.QueryRowContext(ctx, checkActiveQuery, taskID).Scan(&activeEntries)
 	if err != nil {
 		return models.Task{}, err
 	}
 	if activeEntries > 0 {
-		return models.Task{}, errors.New("task is already active")
+		return models.Task{}, apperr.Conflict("task_already_active", "task is already active")
 	}
 
 	timeEntryQuery := `
-    INSERT INTO time_entries (task_id, start_time)
-    VALUES ($1, $2)
-    RETURNING id, start_time`
+    INSERT INTO time_entries (organization_id, task_id, start_time)
+    VALUES ($1, $2, $3)
+    RETURNING id, start_time, version`
 
-	var timeEntryID int
-	var startTime time.Time
-	err = tx.QueryRow(timeEntryQuery, taskID, time.Now()).Scan(&timeEntryID, &startTime)
+	timeEntry := models.TimeEntry{TaskID: taskID}
+	err = tx.QueryRowContext(ctx, timeEntryQuery, orgID, taskID, time.Now()).Scan(&timeEntry.ID, &timeEntry.StartTime, &timeEntry.Version)
 	if err != nil {
 		return models.Task{}, err
 	}
 
-	task.StartTime = startTime
+	task.StartTime = timeEntry.StartTime
+	if task.Version, err = bumpTask(ctx, tx, orgID, taskID); err != nil {
+		return models.Task{}, err
+	}
+
+	if err = p.audit(ctx, tx, orgID, actor, AuditTimeEntryStart, EntityTimeEntry, timeEntry.ID, nil, timeEntry); err != nil {
+		return models.Task{}, err
+	}
 
 	if err = tx.Commit(); err != nil {
 		return models.Task{}, err
@@ -180,23 +379,66 @@ func (p *postgresRepo) StartUserTask(userID, taskID int) (models.Task, error) {
 
 	return task, nil
 }
-func (p *postgresRepo) StopUserTask(userID, taskID int) (models.Task, error) {
-	tx, err := p.db.Begin()
+
+// lockTask selects a task of an active user for update, so that its version
+// cannot change before the transaction ends.
+func lockTask(ctx context.Context, tx *sql.Tx, orgID, userID, taskID int) (models.Task, error) {
+	query := `
+    SELECT t.id, t.user_id, t.description, t.created_at, t.version
+    FROM tasks t
+    JOIN users u ON u.id = t.user_id
+    WHERE t.id = $1 AND t.user_id = $2 AND t.organization_id = $3 AND u.deleted_at IS NULL
+    FOR UPDATE OF t`
+
+	var task models.Task
+	err := tx.QueryRowContext(ctx, query, taskID, userID, orgID).
+		Scan(&task.ID, &task.UserID, &task.Description, &task.CreatedAt, &task.Version)
+	if err != nil {
+		return task, notFound(err, errTaskNotFound)
+	}
+
+	return task, nil
+}
+
+// bumpTask increments the version of a task whose time entries changed and
+// returns the new version.
+func bumpTask(ctx context.Context, tx *sql.Tx, orgID, taskID int) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx, `UPDATE tasks SET version = version + 1 WHERE id = $1 AND organization_id = $2 RETURNING version`,
+		taskID, orgID).Scan(&version)
+	return version, err
+}
+func (p *postgresRepo) StopUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID, version int) (models.Task, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
 		return models.Task{}, err
 	}
 	defer tx.Rollback()
 
+	task, err := lockTask(ctx, tx, orgID, userID, taskID)
+	if err != nil {
+		return models.Task{}, err
+	}
+	if err = checkVersion("task", task.Version, version); err != nil {
+		return models.Task{}, err
+	}
+
 	query := `
 		UPDATE time_entries
-		SET end_time = $1, duration = $1 - start_time
-		WHERE task_id = $2 AND end_time IS NULL
-		RETURNING id, task_id, start_time, end_time, duration`
+		SET end_time = $1, duration = $1 - start_time, version = version + 1
+		WHERE task_id = $2 AND end_time IS NULL AND organization_id = $3
+		RETURNING id, task_id, start_time, end_time, duration, version`
 
 	var timeEntry models.TimeEntry
 	var durationStr string
-	err = tx.QueryRow(query, time.Now(), taskID).
-		Scan(&timeEntry.ID, &timeEntry.TaskID, &timeEntry.StartTime, &timeEntry.EndTime, &durationStr)
+	err = tx.QueryRowContext(ctx, query, time.Now(), taskID, orgID).
+		Scan(&timeEntry.ID, &timeEntry.TaskID, &timeEntry.StartTime, &timeEntry.EndTime, &durationStr, &timeEntry.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Task{}, apperr.Conflict("task_not_active", "task is not active")
+	}
 	if err != nil {
 		return models.Task{}, err
 	}
@@ -206,20 +448,17 @@ func (p *postgresRepo) StopUserTask(userID, taskID int) (models.Task, error) {
 	}
 	timeEntry.Duration = duration
 
-	taskQuery := `
-		SELECT id, user_id, description, created_at
-		FROM tasks
-		WHERE id = $1`
-
-	var task models.Task
-	err = tx.QueryRow(taskQuery, taskID).
-		Scan(&task.ID, &task.UserID, &task.Description, &task.CreatedAt)
-	if err != nil {
+	before := timeEntry
+	before.EndTime, before.Duration, before.Version = time.Time{}, 0, timeEntry.Version-1
+	if err = p.audit(ctx, tx, orgID, actor, AuditTimeEntryStop, EntityTimeEntry, timeEntry.ID, before, timeEntry); err != nil {
 		return models.Task{}, err
 	}
 
 	task.StartTime = timeEntry.StartTime
 	task.EndTime = timeEntry.EndTime
+	if task.Version, err = bumpTask(ctx, tx, orgID, taskID); err != nil {
+		return models.Task{}, err
+	}
 
 	if err = tx.Commit(); err != nil {
 		return models.Task{}, err
@@ -227,48 +466,219 @@ func (p *postgresRepo) StopUserTask(userID, taskID int) (models.Task, error) {
 
 	return task, nil
 }
-func (p *postgresRepo) DeleteUser(id int) error {
-	query := `DELETE FROM users WHERE id = $1`
+func (p *postgresRepo) DeleteUser(ctx context.Context, orgID int, actor models.Actor, id, version int) error {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	result, err := p.db.Exec(query, id)
+	before, err := p.scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users
+		WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL FOR UPDATE`, id, orgID))
 	if err != nil {
+		return notFound(err, errUserNotFound)
+	}
+	if err = checkVersion("user", before.Version, version); err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	query := `
+		UPDATE users SET deleted_at = $1, updated_at = $1, version = version + 1
+		WHERE id = $2 AND organization_id = $3
+		RETURNING ` + userColumns
+
+	deleted, err := p.scanUser(tx.QueryRowContext(ctx, query, time.Now(), id, orgID))
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return errors.New("user not found")
+	if err = p.audit(ctx, tx, orgID, actor, AuditUserDelete, EntityUser, id, before, deleted); err != nil {
+		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
-func (p *postgresRepo) UpdateUser(user models.User) (models.User, error) {
+func (p *postgresRepo) RestoreUser(ctx context.Context, orgID int, actor models.Actor, id, version int) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	before, err := p.scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users
+		WHERE id = $1 AND organization_id = $2 AND deleted_at IS NOT NULL FOR UPDATE`, id, orgID))
+	if err != nil {
+		return models.User{}, notFound(err, errUserNotFound)
+	}
+	if err = checkVersion("user", before.Version, version); err != nil {
+		return models.User{}, err
+	}
+
 	query := `
-		UPDATE users
-		SET passport_number = $1, surname = $2, name = $3, patronymic = $4, address = $5
-		WHERE id = $6
-		RETURNING id, passport_number, surname, name, patronymic, address`
+		UPDATE users SET deleted_at = NULL, updated_at = $3, version = version + 1
+		WHERE id = $1 AND organization_id = $2
+		RETURNING ` + userColumns
+
+	restored, err := p.scanUser(tx.QueryRowContext(ctx, query, id, orgID, time.Now()))
+	if err != nil {
+		return models.User{}, conflict(err)
+	}
+
+	if err = p.audit(ctx, tx, orgID, actor, AuditUserRestore, EntityUser, id, nil, restored); err != nil {
+		return models.User{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.User{}, err
+	}
+
+	return restored, nil
+}
+func (p *postgresRepo) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Bulk)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM users WHERE deleted_at < $1 RETURNING id, organization_id`, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	type purged struct{ id, orgID int }
+	var users []purged
+	for rows.Next() {
+		var u purged
+		if err := rows.Scan(&u.id, &u.orgID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// The purge is recorded without a snapshot: keeping the personal data in
+	// the audit log would defeat the point of removing it.
+	for _, u := range users {
+		if err = p.audit(ctx, tx, u.orgID, models.Actor{}, AuditUserPurge, EntityUser, u.id, nil, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(users), nil
+}
+
+func (p *postgresRepo) UpdateUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Write)
+	defer cancel()
 
-	err := p.db.QueryRow(query, user.PassportNumber, user.Surname, user.Name, user.Patronymic, user.Address, user.ID).
-		Scan(&user.ID, &user.PassportNumber, &user.Surname, &user.Name, &user.Patronymic, &user.Address)
+	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
 		return user, err
 	}
+	defer tx.Rollback()
+
+	before, err := p.scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users
+		WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL FOR UPDATE`, user.ID, orgID))
+	if err != nil {
+		return user, notFound(err, errUserNotFound)
+	}
+	if err = checkVersion("user", before.Version, user.Version); err != nil {
+		return user, err
+	}
+
+	sealed, err := p.sealUser(user)
+	if err != nil {
+		return user, err
+	}
+
+	query := `
+		UPDATE users
+		SET passport_number = $1, passport_number_index = $2, surname = $3, name = $4, patronymic = NULLIF($5, ''),
+			address = $6, address_tokens = $7, role = $8, team = NULLIF($9, ''), privileges = $10, updated_at = $11,
+			version = version + 1
+		WHERE id = $12 AND organization_id = $13
+		RETURNING ` + userColumns
+
+	updated, err := p.scanUser(tx.QueryRowContext(ctx, query, sealed.PassportNumber, p.keys.BlindIndex(user.PassportNumber),
+		user.Surname, user.Name, user.Patronymic, sealed.Address, pq.Array(p.addressTokens(user.Address)), user.Role,
+		user.Team, pq.Array(privileges(user)), time.Now(), user.ID, orgID))
+	if err != nil {
+		return user, conflict(err)
+	}
+
+	if err = p.audit(ctx, tx, orgID, actor, AuditUserUpdate, EntityUser, user.ID, before, updated); err != nil {
+		return user, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return user, err
+	}
+
+	return updated, nil
+}
+
+func (p *postgresRepo) TimerStats(ctx context.Context, since time.Time) (models.TimerStats, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*) FILTER (WHERE end_time IS NULL), COUNT(*) FILTER (WHERE start_time >= $1)
+		FROM time_entries
+		WHERE end_time IS NULL OR start_time >= $1`
+
+	var stats models.TimerStats
+	err := p.db.QueryRowContext(ctx, query, since).Scan(&stats.Running, &stats.Started)
+	return stats, err
+}
+
+// DB returns the connection pool of the repository.
+func (p *postgresRepo) DB() *sql.DB {
+	return p.db
+}
+
+func (p *postgresRepo) User(ctx context.Context, orgID, id int) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL`
+
+	user, err := p.scanUser(p.db.QueryRowContext(ctx, query, id, orgID))
+	if err != nil {
+		return user, notFound(err, errUserNotFound)
+	}
 
 	return user, nil
 }
-func (p *postgresRepo) User(id int) (models.User, error) {
+
+func (p *postgresRepo) UserIdentity(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
 	query := `
-		SELECT id, passport_number, surname, name, patronymic, address
+		SELECT id, organization_id, role, COALESCE(team, ''), privileges
 		FROM users
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NULL`
 
 	var user models.User
-	err := p.db.QueryRow(query, id).Scan(&user.ID, &user.PassportNumber, &user.Surname, &user.Name, &user.Patronymic, &user.Address)
+	err := p.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.OrganizationID, &user.Role, &user.Team, pq.Array(&user.Privileges))
 	if err != nil {
 		return user, err
 	}
@@ -276,8 +686,9 @@ func (p *postgresRepo) User(id int) (models.User, error) {
 	return user, nil
 }
 
-func NewRepository(host, port, user, password, dbname string) Repository {
-	return NewPostgresRepo(host, port, user, password, dbname)
+func NewRepository(host, port, user, password, dbname string, keys *fieldcrypt.Keyring,
+	timeouts Timeouts) (Repository, error) {
+	return NewPostgresRepo(host, port, user, password, dbname, keys, timeouts)
 }
 
 func parseDuration(s string) (time.Duration, error) {
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"context"
+)
+
+// SchemaRepository is implemented by backends whose schema is managed by
+// migrations, so that readiness can check the database is reachable and
+// migrated.
+type SchemaRepository interface {
+	Ping(ctx context.Context) error
+	// SchemaVersion returns the version of the last applied migration and
+	// whether it failed halfway.
+	SchemaVersion(ctx context.Context) (version uint, dirty bool, err error)
+}
+
+// Close closes the connection pool, waiting for running queries to finish.
+func (p *postgresRepo) Close() error {
+	return p.db.Close()
+}
+
+func (p *postgresRepo) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *postgresRepo) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	var version uint
+	var dirty bool
+	err := p.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	return version, dirty, err
+}
@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"timeTracker/internal/models"
+
+	"github.com/lib/pq"
+)
+
+type SearchRepository interface {
+	// Search looks for users whose names, and tasks whose descriptions,
+	// match the text by words or, to tolerate misspellings, by trigram
+	// similarity. With addresses set, users whose address contains every
+	// word of the text are found too; addresses are encrypted, so they only
+	// match whole words. Only users selected by scope and their tasks are
+	// searched.
+	Search(ctx context.Context, orgID int, text string, scope models.UserQuery, addresses bool,
+		limit int) (models.SearchResults, error)
+}
+
+// userFullName and the to_tsvector expressions below match the indexes of
+// migration 000011_add_search_indexes.
+const userFullName = `surname || ' ' || name || ' ' || COALESCE(patronymic, '')`
+
+// Highlights are HTML: the text is escaped and the matching words are
+// wrapped in <mark> tags. ts_headline marks them with the private-use
+// characters below instead, which become tags once the text is escaped.
+const (
+	matchStart    = "\uE000"
+	matchStop     = "\uE001"
+	headlineStyle = `StartSel="` + matchStart + `", StopSel="` + matchStop + `"`
+)
+
+var matchTags = strings.NewReplacer(matchStart, "<mark>", matchStop, "</mark>")
+
+// highlightHTML escapes a headline and turns its match markers into tags.
+func highlightHTML(headline string) string {
+	return matchTags.Replace(html.EscapeString(headline))
+}
+
+// addressTokenPrefix keeps the blind indexes of address words apart from
+// those of passport numbers, which are computed with the same key.
+const addressTokenPrefix = "address:"
+
+func (p *postgresRepo) Search(ctx context.Context, orgID int, text string, scope models.UserQuery, addresses bool,
+	limit int) (models.SearchResults, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	results := models.SearchResults{Query: text, Users: []models.SearchHit{}, Tasks: []models.SearchHit{}}
+
+	where, params, err := p.userWhere(orgID, scope)
+	if err != nil {
+		return results, err
+	}
+	params = append(params, text, limit)
+	textParam, limitParam := len(params)-1, len(params)
+
+	users := fmt.Sprintf(`
+		WITH q AS (SELECT websearch_to_tsquery('simple', $%[1]d) AS query)
+		SELECT id, id, `+userFullName+`,
+			ts_headline('simple', `+userFullName+`, q.query, '`+headlineStyle+`, HighlightAll=true'),
+			ts_rank(to_tsvector('simple', `+userFullName+`), q.query) + word_similarity($%[1]d, `+userFullName+`) AS rank
+		FROM users, q
+		WHERE %[3]s AND erased_at IS NULL
+			AND (to_tsvector('simple', `+userFullName+`) @@ q.query OR $%[1]d <%% (`+userFullName+`))
+		ORDER BY rank DESC, id
+		LIMIT $%[2]d`, textParam, limitParam, where)
+	if results.Users, err = p.searchHits(ctx, models.SearchHitUser, models.SearchFieldName, users, params); err != nil {
+		return results, err
+	}
+	if addresses {
+		tokens := p.addressTokens(text)
+		if len(tokens) > 0 {
+			byAddress := fmt.Sprintf(`
+				SELECT id, address FROM users
+				WHERE %s AND erased_at IS NULL AND address_tokens @> $%d
+				ORDER BY id
+				LIMIT $%d`, where, textParam, limitParam)
+			addressParams := slices.Clone(params)
+			addressParams[textParam-1] = pq.Array(tokens)
+			hits, err := p.addressHits(ctx, p.db, text, byAddress, addressParams)
+			if err != nil {
+				return results, err
+			}
+			results.Users = mergeAddressHits(results.Users, hits, limit)
+		}
+	}
+
+	tasks := fmt.Sprintf(`
+		WITH q AS (SELECT websearch_to_tsquery('simple', $%[1]d) AS query)
+		SELECT t.id, t.user_id, t.description,
+			ts_headline('simple', t.description, q.query, '`+headlineStyle+`'),
+			ts_rank(to_tsvector('simple', t.description), q.query) + word_similarity($%[1]d, t.description) AS rank
+		FROM tasks t, q
+		WHERE t.organization_id = $1 AND t.user_id IN (SELECT id FROM users WHERE %[3]s)
+			AND (to_tsvector('simple', t.description) @@ q.query OR $%[1]d <%% t.description)
+		ORDER BY rank DESC, t.id
+		LIMIT $%[2]d`, textParam, limitParam, where)
+	if results.Tasks, err = p.searchHits(ctx, models.SearchHitTask, models.SearchFieldDescription, tasks, params); err != nil {
+		return results, err
+	}
+
+	return results, nil
+}
+
+func (p *postgresRepo) searchHits(ctx context.Context, hitType, field, query string, params []interface{}) ([]models.SearchHit, error) {
+	rows, err := p.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []models.SearchHit{}
+	for rows.Next() {
+		hit := models.SearchHit{Type: hitType, Field: field}
+		if err := rows.Scan(&hit.ID, &hit.UserID, &hit.Text, &hit.Highlight, &hit.Rank); err != nil {
+			return nil, err
+		}
+		hit.Highlight = highlightHTML(hit.Highlight)
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// addressHits reads users selected by id and encrypted address, keeping
+// those whose address contains every word of text.
+func (s sealer) addressHits(ctx context.Context, db *sql.DB, text, query string,
+	params []interface{}) ([]models.SearchHit, error) {
+	rows, err := db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	words := searchWords(text)
+	hits := []models.SearchHit{}
+	for rows.Next() {
+		var id int
+		var address string
+		if err := rows.Scan(&id, &address); err != nil {
+			return nil, err
+		}
+		if address, err = s.keys.Decrypt(address); err != nil {
+			return nil, fmt.Errorf("error decrypting address of user %d: %w", id, err)
+		}
+		if hit, ok := addressHit(id, address, words); ok {
+			hits = append(hits, hit)
+		}
+	}
+
+	return hits, rows.Err()
+}
+
+// addressTokens returns the sorted blind indexes of the distinct words of
+// an address, which let Postgres find users by address without decrypting.
+func (s sealer) addressTokens(address string) []string {
+	tokens := []string{}
+	for _, word := range searchWords(address) {
+		tokens = append(tokens, s.keys.BlindIndex(addressTokenPrefix+word))
+	}
+	slices.Sort(tokens)
+	return slices.Compact(tokens)
+}
+
+// addressHit matches an address against the words of a query. Unlike names,
+// addresses only match by whole words, as that is all their blind indexes
+// allow.
+func addressHit(userID int, address string, query []string) (models.SearchHit, bool) {
+	words := searchWords(address)
+	for _, q := range query {
+		if !slices.Contains(words, q) {
+			return models.SearchHit{}, false
+		}
+	}
+	return searchHit(models.SearchHitUser, models.SearchFieldAddress, userID, userID, address, query)
+}
+
+// mergeAddressHits adds the users found by address to those found by name,
+// once each, and keeps the best limit of them.
+func mergeAddressHits(byName, byAddress []models.SearchHit, limit int) []models.SearchHit {
+	for _, hit := range byAddress {
+		if !slices.ContainsFunc(byName, func(h models.SearchHit) bool { return h.ID == hit.ID }) {
+			byName = append(byName, hit)
+		}
+	}
+	return bestHits(byName, limit)
+}
@@ -0,0 +1,11 @@
+package repository
+
+import "testing"
+
+func TestHighlightHTML(t *testing.T) {
+	headline := matchStart + "Ivanov" + matchStop + ` <img src=x onerror="alert(1)"> & ` + matchStart + "Ivan" + matchStop
+	want := `<mark>Ivanov</mark> &lt;img src=x onerror=&#34;alert(1)&#34;&gt; &amp; <mark>Ivan</mark>`
+	if got := highlightHTML(headline); got != want {
+		t.Errorf("highlightHTML = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,1265 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/fieldcrypt"
+	"timeTracker/internal/models"
+
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"modernc.org/sqlite"
+)
+
+// The SQLite backend runs the schema of migrations/sqlite, which stands in
+// for the Postgres types it lacks: timestamps are UTC text written by
+// sqliteTime, durations are microseconds and privileges a JSON array. Writes
+// take the database lock when their transaction begins, which replaces the
+// row locks Postgres takes with SELECT ... FOR UPDATE.
+
+// sqliteTimeLayout has a fixed number of fractional digits so that
+// timestamps compare and sort correctly as text. Microseconds are the
+// precision of Postgres timestamps.
+const sqliteTimeLayout = "2006-01-02 15:04:05.000000+00:00"
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+func init() {
+	// fold lowercases text for the case-insensitive matching Postgres does
+	// with ILIKE, since LIKE in SQLite only ignores the case of ASCII letters.
+	sqlite.MustRegisterDeterministicScalarFunction("fold", 1,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			if s, ok := args[0].(string); ok {
+				return strings.ToLower(s), nil
+			}
+			return args[0], nil
+		})
+}
+
+type sqliteRepo struct {
+	db *sql.DB
+	sealer
+	timeouts Timeouts
+}
+
+// SQLiteDSN returns the data source name that opens the database file at
+// path with the settings the repository relies on: enforced foreign keys, a
+// write-ahead log so that reads don't wait for writes, and transactions that
+// wait for the write lock up front rather than failing on upgrading to it.
+func SQLiteDSN(path string) string {
+	return path + "?_pragma=busy_timeout(10000)&_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_txlock=immediate"
+}
+
+// NewSQLiteRepo opens the SQLite database at path, which must have been
+// migrated with the migrations in migrations/sqlite.
+func NewSQLiteRepo(path string, keys *fieldcrypt.Keyring, timeouts Timeouts) (*sqliteRepo, error) {
+	db, err := otelsql.Open("sqlite", SQLiteDSN(path), otelsql.WithAttributes(semconv.DBSystemSqlite),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+
+	return &sqliteRepo{db: db, sealer: sealer{keys}, timeouts: timeouts}, nil
+}
+
+// scanUser reads a row selected with userColumns, decrypting the fields that
+// are encrypted at rest.
+func (s *sqliteRepo) scanUser(row scanner) (models.User, error) {
+	var u models.User
+	var privileges string
+	err := row.Scan(&u.ID, &u.OrganizationID, &u.PassportNumber, &u.Surname, &u.Name, &u.Patronymic, &u.Address,
+		&u.Role, &u.Team, &privileges, &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.DeletedAt, &u.ErasedAt)
+	if err != nil {
+		return u, err
+	}
+	if err = json.Unmarshal([]byte(privileges), &u.Privileges); err != nil {
+		return u, fmt.Errorf("error decoding privileges of user %d: %w", u.ID, err)
+	}
+
+	return s.openUser(u)
+}
+
+// sqliteArray encodes values for a JSON array column.
+func sqliteArray(values []string) (string, error) {
+	b, err := json.Marshal(values)
+	return string(b), err
+}
+
+func (s *sqliteRepo) AddUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return user, err
+	}
+	defer tx.Rollback()
+
+	sealed, err := s.sealUser(user)
+	if err != nil {
+		return user, err
+	}
+	privileges, err := sqliteArray(privileges(user))
+	if err != nil {
+		return user, err
+	}
+
+	// Users without a passport number get no index, which would otherwise
+	// make them conflict with each other.
+	query := `
+		INSERT INTO users (organization_id, passport_number, passport_number_index, surname, name, patronymic, address,
+			role, team, privileges, created_at, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, ''), $7, $8, NULLIF($9, ''), $10, $11, $11)
+		RETURNING ` + userColumns
+
+	created, err := s.scanUser(tx.QueryRowContext(ctx, query, orgID, sealed.PassportNumber, s.keys.BlindIndex(user.PassportNumber),
+		user.Surname, user.Name, user.Patronymic, sealed.Address, user.Role, user.Team, privileges, sqliteTime(time.Now())))
+	if err != nil {
+		return user, fmt.Errorf("error adding user to database: %w", sqliteConflict(err))
+	}
+
+	if err = s.audit(ctx, tx, orgID, actor, AuditUserCreate, EntityUser, created.ID, nil, created); err != nil {
+		return user, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return user, err
+	}
+
+	return created, nil
+}
+
+// userWhere builds the WHERE clause of a user listing and its parameters.
+func (s *sqliteRepo) userWhere(orgID int, query models.UserQuery) (string, []interface{}, error) {
+	where := `organization_id = $1`
+	if !query.IncludeDeleted {
+		where += ` AND deleted_at IS NULL`
+	}
+	params := []interface{}{orgID}
+
+	for _, f := range query.Filters {
+		field, err := checkFilter(f)
+		if err != nil {
+			return "", nil, err
+		}
+
+		values, err := sqliteValues(field, f.Values)
+		if err != nil {
+			return "", nil, err
+		}
+		if f.Field == "passport_number" {
+			for i, v := range f.Values {
+				values[i] = s.keys.BlindIndex(v)
+			}
+		}
+
+		switch f.Op {
+		case models.FilterIn:
+			array, err := sqliteArray(values)
+			if err != nil {
+				return "", nil, err
+			}
+			params = append(params, array)
+			where += fmt.Sprintf(" AND %s IN (SELECT value FROM json_each($%d))", field.column, len(params))
+		case models.FilterPrefix:
+			params = append(params, escapeLike(values[0])+"%")
+			where += fmt.Sprintf(` AND fold(%s) LIKE fold($%d) ESCAPE '\'`, field.column, len(params))
+		case models.FilterContains:
+			params = append(params, "%"+escapeLike(values[0])+"%")
+			where += fmt.Sprintf(` AND fold(%s) LIKE fold($%d) ESCAPE '\'`, field.column, len(params))
+		default:
+			params = append(params, values[0])
+			where += fmt.Sprintf(" AND %s %s $%d", field.column, comparisons[f.Op], len(params))
+		}
+	}
+
+	return where, params, nil
+}
+
+// sqliteValues converts filter or cursor values of a field to the form the
+// field is stored in, which only differs for timestamps.
+func sqliteValues(field userField, values []string) ([]string, error) {
+	converted := make([]string, len(values))
+	for i, v := range values {
+		converted[i] = v
+		if field.Type != models.FieldTime {
+			continue
+		}
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, apperr.Invalid("invalid_time", fmt.Sprintf("%q is not a valid time", v))
+		}
+		converted[i] = sqliteTime(t)
+	}
+	return converted, nil
+}
+
+func (s *sqliteRepo) GetUsers(ctx context.Context, orgID int, page models.Pagination, query models.UserQuery) (models.UserPage, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	var result models.UserPage
+
+	sort, err := userSort(query.Sort)
+	if err != nil {
+		return result, err
+	}
+	where, params, err := s.userWhere(orgID, query)
+	if err != nil {
+		return result, err
+	}
+
+	if err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE `+where, params...).Scan(&result.Total); err != nil {
+		return result, err
+	}
+
+	backwards := page.Before != ""
+	if page.After != "" || backwards {
+		c := page.After
+		if backwards {
+			c = page.Before
+		}
+		values, err := decodeCursor(c, sort)
+		if err != nil {
+			return result, err
+		}
+		for i, sf := range sort {
+			converted, err := sqliteValues(userFields[sf.Field], values[i:i+1])
+			if err != nil {
+				return result, apperr.Invalid("invalid_cursor", "cursor is malformed")
+			}
+			values[i] = converted[0]
+		}
+		var condition string
+		condition, params = keyset(sort, values, backwards, params)
+		where += " AND " + condition
+	}
+
+	// One row more than asked for tells whether there is a further page.
+	params = append(params, page.Limit+1)
+	list := fmt.Sprintf(`SELECT `+userColumns+` FROM users WHERE %s ORDER BY %s LIMIT $%d`,
+		where, orderBy(sort, backwards), len(params))
+	if page.After == "" && !backwards {
+		params = append(params, (page.Page-1)*page.Limit)
+		list += fmt.Sprintf(" OFFSET $%d", len(params))
+	}
+
+	rows, err := s.db.QueryContext(ctx, list, params...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := s.scanUser(rows)
+		if err != nil {
+			return result, err
+		}
+		result.Users = append(result.Users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	return finishPage(result, page, sort), nil
+}
+
+func (s *sqliteRepo) GetUserWorkload(ctx context.Context, orgID, userID int, start, end time.Time) ([]models.Workload, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	// Durations are in microseconds; seconds are rounded before the minutes
+	// are taken from them, as the Postgres query does.
+	query := `
+	SELECT t.id, t.description,
+		   CAST(ROUND(SUM(te.duration) / 3600e6) AS INTEGER) AS hours,
+		   CAST(ROUND(SUM(te.duration) / 1e6) AS INTEGER) % 3600 / 60 AS minutes
+	FROM tasks t
+	JOIN time_entries te ON t.id = te.task_id
+	WHERE t.organization_id = $1 AND t.user_id = $2 AND te.start_time >= $3 AND te.end_time <= $4
+	GROUP BY t.id, t.description
+	ORDER BY SUM(te.duration) DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, orgID, userID, sqliteTime(start), sqliteTime(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workloads []models.Workload
+	for rows.Next() {
+		var w models.Workload
+		if err := rows.Scan(&w.TaskID, &w.Description, &w.Hours, &w.Minutes); err != nil {
+			return nil, err
+		}
+		workloads = append(workloads, w)
+	}
+
+	return workloads, rows.Err()
+}
+
+// task selects a task of an active user. The transaction holds the write
+// lock of the database, so its version cannot change before it ends.
+func (s *sqliteRepo) task(ctx context.Context, tx *sql.Tx, orgID, userID, taskID int) (models.Task, error) {
+	query := `
+    SELECT t.id, t.user_id, t.description, t.created_at, t.version
+    FROM tasks t
+    JOIN users u ON u.id = t.user_id
+    WHERE t.id = $1 AND t.user_id = $2 AND t.organization_id = $3 AND u.deleted_at IS NULL`
+
+	var task models.Task
+	err := tx.QueryRowContext(ctx, query, taskID, userID, orgID).
+		Scan(&task.ID, &task.UserID, &task.Description, &task.CreatedAt, &task.Version)
+	if err != nil {
+		return task, notFound(err, errTaskNotFound)
+	}
+
+	return task, nil
+}
+
+func (s *sqliteRepo) StartUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID, version int) (models.Task, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Task{}, err
+	}
+	defer tx.Rollback()
+
+	task, err := s.task(ctx, tx, orgID, userID, taskID)
+	if err != nil {
+		return models.Task{}, err
+	}
+	if err = checkVersion("task", task.Version, version); err != nil {
+		return models.Task{}, err
+	}
+
+	var activeEntries int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM time_entries WHERE task_id = $1 AND end_time IS NULL`, taskID).
+		Scan(&activeEntries)
+	if err != nil {
+		return models.Task{}, err
+	}
+	if activeEntries > 0 {
+		return models.Task{}, apperr.Conflict("task_already_active", "task is already active")
+	}
+
+	timeEntryQuery := `
+    INSERT INTO time_entries (organization_id, task_id, start_time)
+    VALUES ($1, $2, $3)
+    RETURNING id, start_time, version`
+
+	timeEntry := models.TimeEntry{TaskID: taskID}
+	err = tx.QueryRowContext(ctx, timeEntryQuery, orgID, taskID, sqliteTime(time.Now())).
+		Scan(&timeEntry.ID, &timeEntry.StartTime, &timeEntry.Version)
+	if err != nil {
+		return models.Task{}, err
+	}
+
+	task.StartTime = timeEntry.StartTime
+	if task.Version, err = bumpTask(ctx, tx, orgID, taskID); err != nil {
+		return models.Task{}, err
+	}
+
+	if err = s.audit(ctx, tx, orgID, actor, AuditTimeEntryStart, EntityTimeEntry, timeEntry.ID, nil, timeEntry); err != nil {
+		return models.Task{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Task{}, err
+	}
+
+	return task, nil
+}
+
+func (s *sqliteRepo) StopUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID, version int) (models.Task, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Task{}, err
+	}
+	defer tx.Rollback()
+
+	task, err := s.task(ctx, tx, orgID, userID, taskID)
+	if err != nil {
+		return models.Task{}, err
+	}
+	if err = checkVersion("task", task.Version, version); err != nil {
+		return models.Task{}, err
+	}
+
+	// SQLite cannot subtract timestamps to the microsecond, so the duration
+	// is worked out here from the start of the running entry.
+	before := models.TimeEntry{TaskID: taskID}
+	err = tx.QueryRowContext(ctx, `SELECT id, start_time, version FROM time_entries
+		WHERE task_id = $1 AND end_time IS NULL AND organization_id = $2`, taskID, orgID).
+		Scan(&before.ID, &before.StartTime, &before.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Task{}, apperr.Conflict("task_not_active", "task is not active")
+	}
+	if err != nil {
+		return models.Task{}, err
+	}
+
+	end, err := time.Parse(sqliteTimeLayout, sqliteTime(time.Now()))
+	if err != nil {
+		return models.Task{}, err
+	}
+	query := `
+		UPDATE time_entries
+		SET end_time = $1, duration = $2, version = version + 1
+		WHERE id = $3
+		RETURNING id, task_id, start_time, end_time, duration, version`
+
+	var timeEntry models.TimeEntry
+	var microseconds int64
+	err = tx.QueryRowContext(ctx, query, sqliteTime(end), end.Sub(before.StartTime).Microseconds(), before.ID).
+		Scan(&timeEntry.ID, &timeEntry.TaskID, &timeEntry.StartTime, &timeEntry.EndTime, &microseconds, &timeEntry.Version)
+	if err != nil {
+		return models.Task{}, err
+	}
+	timeEntry.Duration = time.Duration(microseconds) * time.Microsecond
+
+	if err = s.audit(ctx, tx, orgID, actor, AuditTimeEntryStop, EntityTimeEntry, timeEntry.ID, before, timeEntry); err != nil {
+		return models.Task{}, err
+	}
+
+	task.StartTime = timeEntry.StartTime
+	task.EndTime = timeEntry.EndTime
+	if task.Version, err = bumpTask(ctx, tx, orgID, taskID); err != nil {
+		return models.Task{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Task{}, err
+	}
+
+	return task, nil
+}
+
+func (s *sqliteRepo) DeleteUser(ctx context.Context, orgID int, actor models.Actor, id, version int) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := s.scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users
+		WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL`, id, orgID))
+	if err != nil {
+		return notFound(err, errUserNotFound)
+	}
+	if err = checkVersion("user", before.Version, version); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE users SET deleted_at = $1, updated_at = $1, version = version + 1
+		WHERE id = $2 AND organization_id = $3
+		RETURNING ` + userColumns
+
+	deleted, err := s.scanUser(tx.QueryRowContext(ctx, query, sqliteTime(time.Now()), id, orgID))
+	if err != nil {
+		return err
+	}
+
+	if err = s.audit(ctx, tx, orgID, actor, AuditUserDelete, EntityUser, id, before, deleted); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteRepo) RestoreUser(ctx context.Context, orgID int, actor models.Actor, id, version int) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	before, err := s.scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users
+		WHERE id = $1 AND organization_id = $2 AND deleted_at IS NOT NULL`, id, orgID))
+	if err != nil {
+		return models.User{}, notFound(err, errUserNotFound)
+	}
+	if err = checkVersion("user", before.Version, version); err != nil {
+		return models.User{}, err
+	}
+
+	query := `
+		UPDATE users SET deleted_at = NULL, updated_at = $3, version = version + 1
+		WHERE id = $1 AND organization_id = $2
+		RETURNING ` + userColumns
+
+	restored, err := s.scanUser(tx.QueryRowContext(ctx, query, id, orgID, sqliteTime(time.Now())))
+	if err != nil {
+		return models.User{}, sqliteConflict(err)
+	}
+
+	if err = s.audit(ctx, tx, orgID, actor, AuditUserRestore, EntityUser, id, nil, restored); err != nil {
+		return models.User{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.User{}, err
+	}
+
+	return restored, nil
+}
+
+func (s *sqliteRepo) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Bulk)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM users WHERE deleted_at < $1 RETURNING id, organization_id`,
+		sqliteTime(deletedBefore))
+	if err != nil {
+		return 0, err
+	}
+
+	type purged struct{ id, orgID int }
+	var users []purged
+	for rows.Next() {
+		var u purged
+		if err := rows.Scan(&u.id, &u.orgID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// The purge is recorded without a snapshot: keeping the personal data in
+	// the audit log would defeat the point of removing it.
+	for _, u := range users {
+		if err = s.audit(ctx, tx, u.orgID, models.Actor{}, AuditUserPurge, EntityUser, u.id, nil, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(users), nil
+}
+
+func (s *sqliteRepo) UpdateUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return user, err
+	}
+	defer tx.Rollback()
+
+	before, err := s.scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users
+		WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL`, user.ID, orgID))
+	if err != nil {
+		return user, notFound(err, errUserNotFound)
+	}
+	if err = checkVersion("user", before.Version, user.Version); err != nil {
+		return user, err
+	}
+
+	sealed, err := s.sealUser(user)
+	if err != nil {
+		return user, err
+	}
+	privileges, err := sqliteArray(privileges(user))
+	if err != nil {
+		return user, err
+	}
+
+	query := `
+		UPDATE users
+		SET passport_number = $1, passport_number_index = NULLIF($2, ''), surname = $3, name = $4,
+			patronymic = NULLIF($5, ''), address = $6, role = $7, team = NULLIF($8, ''), privileges = $9, updated_at = $10,
+			version = version + 1
+		WHERE id = $11 AND organization_id = $12
+		RETURNING ` + userColumns
+
+	updated, err := s.scanUser(tx.QueryRowContext(ctx, query, sealed.PassportNumber, s.keys.BlindIndex(user.PassportNumber),
+		user.Surname, user.Name, user.Patronymic, sealed.Address, user.Role, user.Team, privileges,
+		sqliteTime(time.Now()), user.ID, orgID))
+	if err != nil {
+		return user, sqliteConflict(err)
+	}
+
+	if err = s.audit(ctx, tx, orgID, actor, AuditUserUpdate, EntityUser, user.ID, before, updated); err != nil {
+		return user, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return user, err
+	}
+
+	return updated, nil
+}
+
+func (s *sqliteRepo) TimerStats(ctx context.Context, since time.Time) (models.TimerStats, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*) FILTER (WHERE end_time IS NULL), COUNT(*) FILTER (WHERE start_time >= $1)
+		FROM time_entries
+		WHERE end_time IS NULL OR start_time >= $1`
+
+	var stats models.TimerStats
+	err := s.db.QueryRowContext(ctx, query, sqliteTime(since)).Scan(&stats.Running, &stats.Started)
+	return stats, err
+}
+
+// DB returns the connection pool of the repository.
+func (s *sqliteRepo) DB() *sql.DB {
+	return s.db
+}
+
+func (s *sqliteRepo) User(ctx context.Context, orgID, id int) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL`
+
+	user, err := s.scanUser(s.db.QueryRowContext(ctx, query, id, orgID))
+	if err != nil {
+		return user, notFound(err, errUserNotFound)
+	}
+
+	return user, nil
+}
+
+func (s *sqliteRepo) UserIdentity(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	query := `
+		SELECT id, organization_id, role, COALESCE(team, ''), privileges
+		FROM users
+		WHERE id = $1 AND deleted_at IS NULL`
+
+	var user models.User
+	var privileges string
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.OrganizationID, &user.Role, &user.Team, &privileges)
+	if err != nil {
+		return user, err
+	}
+	if err = json.Unmarshal([]byte(privileges), &user.Privileges); err != nil {
+		return user, fmt.Errorf("error decoding privileges of user %d: %w", user.ID, err)
+	}
+
+	return user, nil
+}
+
+func (s *sqliteRepo) AddAPIKey(ctx context.Context, orgID int, actor models.Actor, key models.APIKey,
+	keyHash string) (models.APIKey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return key, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (organization_id, name, prefix, key_hash, subject, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, orgID, key.Name, key.Prefix, keyHash, key.Subject, sqliteTime(time.Now())).
+		Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return key, err
+	}
+
+	if err = s.audit(ctx, tx, orgID, actor, AuditAPIKeyIssue, EntityAPIKey, key.ID, nil, key); err != nil {
+		return key, err
+	}
+
+	return key, tx.Commit()
+}
+
+func (s *sqliteRepo) APIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	query := `
+		SELECT id, name, prefix, subject, created_at
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL`
+
+	var key models.APIKey
+	err := s.db.QueryRowContext(ctx, query, keyHash).Scan(&key.ID, &key.Name, &key.Prefix, &key.Subject, &key.CreatedAt)
+	if err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+func (s *sqliteRepo) APIKey(ctx context.Context, orgID, id int) (models.APIKey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	query := `
+		SELECT id, name, prefix, subject, created_at, revoked_at
+		FROM api_keys
+		WHERE id = $1 AND organization_id = $2`
+
+	var key models.APIKey
+	err := s.db.QueryRowContext(ctx, query, id, orgID).Scan(&key.ID, &key.Name, &key.Prefix, &key.Subject, &key.CreatedAt, &key.RevokedAt)
+	if err != nil {
+		return key, notFound(err, errAPIKeyNotFound)
+	}
+
+	return key, nil
+}
+
+func (s *sqliteRepo) RevokeAPIKey(ctx context.Context, orgID int, actor models.Actor, id int) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE api_keys SET revoked_at = $1
+		WHERE id = $2 AND organization_id = $3 AND revoked_at IS NULL
+		RETURNING id, name, prefix, subject, created_at, revoked_at`
+
+	var key models.APIKey
+	err = tx.QueryRowContext(ctx, query, sqliteTime(time.Now()), id, orgID).
+		Scan(&key.ID, &key.Name, &key.Prefix, &key.Subject, &key.CreatedAt, &key.RevokedAt)
+	if err != nil {
+		return notFound(err, errAPIKeyNotFound)
+	}
+
+	before := key
+	before.RevokedAt = nil
+	if err = s.audit(ctx, tx, orgID, actor, AuditAPIKeyRevoke, EntityAPIKey, id, before, key); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// audit appends an event to the audit log within tx, so the record is only
+// persisted if the change it describes is committed.
+func (s *sqliteRepo) audit(ctx context.Context, tx *sql.Tx, orgID int, actor models.Actor, action, entityType string, entityID int,
+	before, after interface{}) error {
+	beforeJSON, err := s.snapshot(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := s.snapshot(after)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_log (organization_id, actor_id, action, entity_type, entity_id, before, after, request_id, created_at)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, NULLIF($8, ''), $9)`
+
+	_, err = tx.ExecContext(ctx, query, orgID, actor.UserID, action, entityType, entityID, beforeJSON, afterJSON, actor.RequestID,
+		sqliteTime(time.Now()))
+	if err != nil {
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+
+	return nil
+}
+
+const auditColumns = `id, organization_id, actor_id, action, entity_type, entity_id, before, after,
+	COALESCE(request_id, ''), created_at`
+
+func (s *sqliteRepo) AuditEvents(ctx context.Context, orgID int, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	query := `SELECT ` + auditColumns + ` FROM audit_log WHERE organization_id = $1`
+
+	params := []interface{}{orgID}
+	where := func(condition string, value interface{}) {
+		params = append(params, value)
+		query += fmt.Sprintf(" AND "+condition, len(params))
+	}
+	if filter.ActorID != 0 {
+		where("actor_id = $%d", filter.ActorID)
+	}
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if filter.EntityType != "" {
+		where("entity_type = $%d", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		where("entity_id = $%d", filter.EntityID)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $%d", sqliteTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $%d", sqliteTime(filter.To))
+	}
+
+	params = append(params, filter.Limit, (filter.Page-1)*filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", len(params)-1, len(params))
+
+	return s.auditEvents(ctx, query, params...)
+}
+
+func (s *sqliteRepo) auditEvents(ctx context.Context, query string, params ...interface{}) ([]models.AuditEvent, error) {
+	rows, err := s.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var e models.AuditEvent
+		var before, after []byte
+		err := rows.Scan(&e.ID, &e.OrganizationID, &e.ActorID, &e.Action, &e.EntityType, &e.EntityID,
+			&before, &after, &e.RequestID, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if e.Before, err = s.openSnapshot(before); err != nil {
+			return nil, err
+		}
+		if e.After, err = s.openSnapshot(after); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *sqliteRepo) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	query := `
+		INSERT INTO idempotency_keys (organization_id, subject, key, fingerprint, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (organization_id, subject, key) DO UPDATE
+		SET fingerprint = excluded.fingerprint, status = NULL, header = NULL, body = NULL,
+			created_at = excluded.created_at, expires_at = excluded.expires_at
+		WHERE idempotency_keys.expires_at <= excluded.created_at
+		RETURNING created_at`
+
+	err := s.db.QueryRowContext(ctx, query, record.OrganizationID, record.Subject, record.Key, record.Fingerprint,
+		sqliteTime(time.Now()), sqliteTime(record.ExpiresAt)).Scan(&record.CreatedAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return record, false, err
+	}
+
+	stored, err := s.idempotencyRecord(ctx, record.OrganizationID, record.Subject, record.Key)
+	return stored, false, err
+}
+
+func (s *sqliteRepo) idempotencyRecord(ctx context.Context, orgID int, subject, key string) (models.IdempotencyRecord, error) {
+	query := `
+		SELECT organization_id, subject, key, fingerprint, COALESCE(status, 0), header, COALESCE(body, ''),
+			created_at, expires_at
+		FROM idempotency_keys
+		WHERE organization_id = $1 AND subject = $2 AND key = $3`
+
+	var r models.IdempotencyRecord
+	var header []byte
+	var body string
+	err := s.db.QueryRowContext(ctx, query, orgID, subject, key).Scan(&r.OrganizationID, &r.Subject, &r.Key, &r.Fingerprint,
+		&r.Status, &header, &body, &r.CreatedAt, &r.ExpiresAt)
+	if err != nil {
+		return r, err
+	}
+
+	if header != nil {
+		if err = json.Unmarshal(header, &r.Header); err != nil {
+			return r, fmt.Errorf("error decoding stored response header: %w", err)
+		}
+	}
+	if body, err = s.keys.Decrypt(body); err != nil {
+		return r, fmt.Errorf("error decrypting stored response body: %w", err)
+	}
+	r.Body = []byte(body)
+
+	return r, nil
+}
+
+func (s *sqliteRepo) CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	body, err := s.keys.Encrypt(string(record.Body))
+	if err != nil {
+		return fmt.Errorf("error encrypting response body: %w", err)
+	}
+
+	query := `
+		UPDATE idempotency_keys SET status = $1, header = $2, body = $3
+		WHERE organization_id = $4 AND subject = $5 AND key = $6`
+
+	_, err = s.db.ExecContext(ctx, query, record.Status, string(header), body, record.OrganizationID, record.Subject, record.Key)
+	return err
+}
+
+func (s *sqliteRepo) ReleaseIdempotencyKey(ctx context.Context, orgID int, subject, key string) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE organization_id = $1 AND subject = $2 AND key = $3`,
+		orgID, subject, key)
+	return err
+}
+
+func (s *sqliteRepo) PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Bulk)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < $1`, sqliteTime(expiredBefore))
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
+
+func (s *sqliteRepo) PersonalData(ctx context.Context, orgID, userID int) (models.PersonalData, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Bulk)
+	defer cancel()
+
+	data := models.PersonalData{GeneratedAt: time.Now()}
+
+	var err error
+	data.User, err = s.scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1 AND organization_id = $2`,
+		userID, orgID))
+	if err != nil {
+		return data, notFound(err, errUserNotFound)
+	}
+
+	if data.Tasks, err = s.userTasks(ctx, orgID, userID); err != nil {
+		return data, err
+	}
+	if data.TimeEntries, err = s.userTimeEntries(ctx, orgID, userID); err != nil {
+		return data, err
+	}
+	data.AuditEvents, err = s.auditEvents(ctx, `SELECT `+auditColumns+` FROM audit_log
+		WHERE organization_id = $1 AND (
+			actor_id = $2
+			OR (entity_type = 'user' AND entity_id = $2)
+			OR (entity_type = 'time_entry' AND entity_id IN (
+				SELECT te.id FROM time_entries te JOIN tasks t ON t.id = te.task_id WHERE t.user_id = $2)))
+		ORDER BY id`, orgID, userID)
+	if err != nil {
+		return data, err
+	}
+	if data.AuditEvents == nil {
+		data.AuditEvents = []models.AuditEvent{}
+	}
+	if data.DataRequests, err = s.userDataRequests(ctx, orgID, userID); err != nil {
+		return data, err
+	}
+
+	return data, nil
+}
+
+func (s *sqliteRepo) userTasks(ctx context.Context, orgID, userID int) ([]models.Task, error) {
+	query := `
+		SELECT id, user_id, description, created_at, version
+		FROM tasks
+		WHERE organization_id = $1 AND user_id = $2
+		ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []models.Task{}
+	for rows.Next() {
+		var t models.Task
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Description, &t.CreatedAt, &t.Version); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+
+	return tasks, rows.Err()
+}
+
+func (s *sqliteRepo) userTimeEntries(ctx context.Context, orgID, userID int) ([]models.TimeEntry, error) {
+	query := `
+		SELECT te.id, te.task_id, te.start_time, te.end_time, COALESCE(te.duration, 0), te.created_at, te.version
+		FROM time_entries te
+		JOIN tasks t ON t.id = te.task_id
+		WHERE te.organization_id = $1 AND t.user_id = $2
+		ORDER BY te.id`
+
+	rows, err := s.db.QueryContext(ctx, query, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.TimeEntry{}
+	for rows.Next() {
+		var e models.TimeEntry
+		var endTime sql.NullTime
+		var microseconds int64
+		if err := rows.Scan(&e.ID, &e.TaskID, &e.StartTime, &endTime, &microseconds, &e.CreatedAt, &e.Version); err != nil {
+			return nil, err
+		}
+		e.EndTime = endTime.Time
+		e.Duration = time.Duration(microseconds) * time.Microsecond
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *sqliteRepo) userDataRequests(ctx context.Context, orgID, userID int) ([]models.DataRequest, error) {
+	query := `SELECT ` + dataRequestColumns + ` FROM data_subject_requests
+		WHERE organization_id = $1 AND user_id = $2 ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.DataRequest{}
+	for rows.Next() {
+		r, err := scanDataRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+
+	return requests, rows.Err()
+}
+
+func (s *sqliteRepo) AddDataRequest(ctx context.Context, orgID int, actor models.Actor, userID int,
+	requestType string) (models.DataRequest, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	query := `
+		INSERT INTO data_subject_requests (organization_id, user_id, type, requested_by, request_id, requested_at)
+		VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''), $6)
+		RETURNING ` + dataRequestColumns
+
+	return scanDataRequest(s.db.QueryRowContext(ctx, query, orgID, userID, requestType, actor.UserID, actor.RequestID,
+		sqliteTime(time.Now())))
+}
+
+func (s *sqliteRepo) CompleteDataRequest(ctx context.Context, orgID, id int) (models.DataRequest, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	return completeSQLiteDataRequest(ctx, s.db, orgID, id)
+}
+
+func completeSQLiteDataRequest(ctx context.Context, q queryRower, orgID, id int) (models.DataRequest, error) {
+	query := `
+		UPDATE data_subject_requests SET completed_at = $1
+		WHERE id = $2 AND organization_id = $3 AND completed_at IS NULL
+		RETURNING ` + dataRequestColumns
+
+	return scanDataRequest(q.QueryRowContext(ctx, query, sqliteTime(time.Now()), id, orgID))
+}
+
+func (s *sqliteRepo) EraseUser(ctx context.Context, orgID int, actor models.Actor, userID,
+	dataRequestID int) (models.DataRequest, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Bulk)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.DataRequest{}, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteTime(time.Now())
+	query := `
+		UPDATE users
+		SET passport_number = NULL, passport_number_index = NULL, surname = $1, name = $1, patronymic = NULL, address = $1,
+			erased_at = $2, updated_at = $2, version = version + 1
+		WHERE id = $3 AND organization_id = $4 AND erased_at IS NULL
+		RETURNING ` + userColumns
+
+	erased, err := s.scanUser(tx.QueryRowContext(ctx, query, ErasedValue, now, userID, orgID))
+	if err != nil {
+		return models.DataRequest{}, notFound(err, func() *apperr.Error {
+			return apperr.NotFound("user_not_found", "user not found or already erased")
+		})
+	}
+
+	redact := `
+		UPDATE audit_log
+		SET before = CASE WHEN before IS NULL THEN NULL ELSE json_patch(before, $1) END,
+			after = CASE WHEN after IS NULL THEN NULL ELSE json_patch(after, $1) END
+		WHERE organization_id = $2 AND entity_type = 'user' AND entity_id = $3`
+	redaction, err := json.Marshal(map[string]string{
+		"passportNumber": "",
+		"surname":        ErasedValue,
+		"name":           ErasedValue,
+		"patronymic":     "",
+		"address":        ErasedValue,
+	})
+	if err != nil {
+		return models.DataRequest{}, err
+	}
+	if _, err = tx.ExecContext(ctx, redact, string(redaction), orgID, userID); err != nil {
+		return models.DataRequest{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE api_keys SET revoked_at = $1 WHERE organization_id = $2 AND subject = $3 AND revoked_at IS NULL`,
+		now, orgID, erased.ID)
+	if err != nil {
+		return models.DataRequest{}, err
+	}
+
+	if err = s.audit(ctx, tx, orgID, actor, AuditUserErase, EntityUser, userID, nil, erased); err != nil {
+		return models.DataRequest{}, err
+	}
+
+	request, err := completeSQLiteDataRequest(ctx, tx, orgID, dataRequestID)
+	if err != nil {
+		return models.DataRequest{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.DataRequest{}, err
+	}
+
+	return request, nil
+}
+
+// Search matches words the way the in-memory backend does, as SQLite has no
+// trigram similarity to tolerate misspellings with. Addresses are decrypted
+// and matched one by one rather than through blind indexes.
+func (s *sqliteRepo) Search(ctx context.Context, orgID int, text string, scope models.UserQuery, addresses bool,
+	limit int) (models.SearchResults, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	results := models.SearchResults{Query: text, Users: []models.SearchHit{}, Tasks: []models.SearchHit{}}
+
+	where, params, err := s.userWhere(orgID, scope)
+	if err != nil {
+		return results, err
+	}
+	query := searchWords(text)
+
+	users := `SELECT id, id, ` + userFullName + ` FROM users WHERE ` + where + ` AND erased_at IS NULL`
+	if results.Users, err = s.searchHits(ctx, models.SearchHitUser, models.SearchFieldName, query, users, params); err != nil {
+		return results, err
+	}
+	if addresses {
+		byAddress := `SELECT id, address FROM users WHERE ` + where + ` AND erased_at IS NULL`
+		hits, err := s.addressHits(ctx, s.db, text, byAddress, params)
+		if err != nil {
+			return results, err
+		}
+		results.Users = mergeAddressHits(results.Users, hits, limit)
+	}
+
+	tasks := `SELECT id, user_id, description FROM tasks
+		WHERE organization_id = $1 AND user_id IN (SELECT id FROM users WHERE ` + where + `)`
+	if results.Tasks, err = s.searchHits(ctx, models.SearchHitTask, models.SearchFieldDescription, query, tasks, params); err != nil {
+		return results, err
+	}
+
+	results.Users = bestHits(results.Users, limit)
+	results.Tasks = bestHits(results.Tasks, limit)
+	return results, nil
+}
+
+func (s *sqliteRepo) searchHits(ctx context.Context, hitType, field string, query []string, statement string,
+	params []interface{}) ([]models.SearchHit, error) {
+	rows, err := s.db.QueryContext(ctx, statement, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []models.SearchHit{}
+	for rows.Next() {
+		var id, userID int
+		var text string
+		if err := rows.Scan(&id, &userID, &text); err != nil {
+			return nil, err
+		}
+		if hit, ok := searchHit(hitType, field, id, userID, text, query); ok {
+			hits = append(hits, hit)
+		}
+	}
+
+	return hits, rows.Err()
+}
+
+// Close closes the connection pool, waiting for running queries to finish.
+func (s *sqliteRepo) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteRepo) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqliteRepo) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	var version uint
+	var dirty bool
+	err := s.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	return version, dirty, err
+}
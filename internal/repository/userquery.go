@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/models"
+
+	"github.com/lib/pq"
+)
+
+type userField struct {
+	models.FieldSpec
+	// column is the SQL expression compared and ordered by.
+	column string
+	// value returns the sort key of a user written into cursors.
+	value func(u models.User) string
+}
+
+var (
+	textOps  = []models.FilterOp{models.FilterPrefix, models.FilterEq, models.FilterContains, models.FilterIn}
+	rangeOps = []models.FilterOp{models.FilterGte, models.FilterGt, models.FilterLte, models.FilterLt, models.FilterEq}
+)
+
+// userFields is the whitelist of everything a user listing can be filtered
+// and sorted by, and the only source of column names in the SQL of GetUsers.
+// passport_number is matched through its blind index and so only compares
+// for equality, while address is encrypted and can only be searched.
+var userFields = map[string]userField{
+	"id": {
+		FieldSpec: models.FieldSpec{Type: models.FieldInt, Sortable: true,
+			Ops: []models.FilterOp{models.FilterEq, models.FilterIn, models.FilterGte, models.FilterGt, models.FilterLte, models.FilterLt}},
+		column: "id",
+		value:  func(u models.User) string { return strconv.Itoa(u.ID) },
+	},
+	"surname": {
+		FieldSpec: models.FieldSpec{Type: models.FieldText, Ops: textOps, Sortable: true},
+		column:    "surname",
+		value:     func(u models.User) string { return u.Surname },
+	},
+	"name": {
+		FieldSpec: models.FieldSpec{Type: models.FieldText, Ops: textOps, Sortable: true},
+		column:    "name",
+		value:     func(u models.User) string { return u.Name },
+	},
+	"patronymic": {
+		FieldSpec: models.FieldSpec{Type: models.FieldText, Ops: textOps, Sortable: true},
+		column:    "COALESCE(patronymic, '')",
+		value:     func(u models.User) string { return u.Patronymic },
+	},
+	"passport_number": {
+		FieldSpec: models.FieldSpec{Type: models.FieldText, Ops: []models.FilterOp{models.FilterEq, models.FilterIn}},
+		column:    "passport_number_index",
+	},
+	"role": {
+		FieldSpec: models.FieldSpec{Type: models.FieldText, Ops: []models.FilterOp{models.FilterEq, models.FilterIn}, Sortable: true},
+		column:    "role",
+		value:     func(u models.User) string { return string(u.Role) },
+	},
+	"team": {
+		FieldSpec: models.FieldSpec{Type: models.FieldText, Ops: textOps, Sortable: true},
+		column:    "COALESCE(team, '')",
+		value:     func(u models.User) string { return u.Team },
+	},
+	"created_at": {
+		FieldSpec: models.FieldSpec{Type: models.FieldTime, Ops: rangeOps, Sortable: true},
+		column:    "created_at",
+		value:     func(u models.User) string { return u.CreatedAt.Format(time.RFC3339Nano) },
+	},
+	"updated_at": {
+		FieldSpec: models.FieldSpec{Type: models.FieldTime, Ops: rangeOps, Sortable: true},
+		column:    "updated_at",
+		value:     func(u models.User) string { return u.UpdatedAt.Format(time.RFC3339Nano) },
+	},
+}
+
+var comparisons = map[models.FilterOp]string{
+	models.FilterEq:  "=",
+	models.FilterGt:  ">",
+	models.FilterGte: ">=",
+	models.FilterLt:  "<",
+	models.FilterLte: "<=",
+}
+
+// UserFields returns the fields a user listing can be filtered and sorted by.
+func UserFields() map[string]models.FieldSpec {
+	fields := make(map[string]models.FieldSpec, len(userFields))
+	for name, field := range userFields {
+		fields[name] = field.FieldSpec
+	}
+	return fields
+}
+
+// userWhere builds the WHERE clause of a user listing and its parameters.
+func (p *postgresRepo) userWhere(orgID int, query models.UserQuery) (string, []interface{}, error) {
+	where := `organization_id = $1`
+	if !query.IncludeDeleted {
+		where += ` AND deleted_at IS NULL`
+	}
+	params := []interface{}{orgID}
+
+	for _, f := range query.Filters {
+		field, err := checkFilter(f)
+		if err != nil {
+			return "", nil, err
+		}
+
+		values := f.Values
+		if f.Field == "passport_number" {
+			values = make([]string, len(f.Values))
+			for i, v := range f.Values {
+				values[i] = p.keys.BlindIndex(v)
+			}
+		}
+
+		switch f.Op {
+		case models.FilterIn:
+			params = append(params, pq.Array(values))
+			where += fmt.Sprintf(" AND %s = ANY($%d)", field.column, len(params))
+		case models.FilterPrefix:
+			params = append(params, escapeLike(values[0])+"%")
+			where += fmt.Sprintf(" AND %s ILIKE $%d", field.column, len(params))
+		case models.FilterContains:
+			params = append(params, "%"+escapeLike(values[0])+"%")
+			where += fmt.Sprintf(" AND %s ILIKE $%d", field.column, len(params))
+		default:
+			params = append(params, values[0])
+			where += fmt.Sprintf(" AND %s %s $%d", field.column, comparisons[f.Op], len(params))
+		}
+	}
+
+	return where, params, nil
+}
+
+// checkFilter returns the field a filter applies to, failing unless the
+// field may be filtered with the operator and the number of values fits it.
+func checkFilter(f models.Filter) (userField, error) {
+	field, ok := userFields[f.Field]
+	if !ok || !slices.Contains(field.Ops, f.Op) || len(f.Values) == 0 ||
+		f.Op != models.FilterIn && len(f.Values) != 1 {
+		return field, apperr.Invalid("unsupported_filter",
+			fmt.Sprintf("filtering by %s[%s] is not supported", f.Field, f.Op))
+	}
+	return field, nil
+}
+
+// userSort checks the requested order and appends id as the tie-breaker
+// that makes it total, as keyset paging needs.
+func userSort(sort []models.SortField) ([]models.SortField, error) {
+	total := make([]models.SortField, 0, len(sort)+1)
+	for _, s := range sort {
+		if field, ok := userFields[s.Field]; !ok || !field.Sortable {
+			return nil, apperr.Invalid("unsupported_sort", fmt.Sprintf("sorting by %s is not supported", s.Field))
+		}
+		total = append(total, s)
+		if s.Field == "id" {
+			return total, nil
+		}
+	}
+	return append(total, models.SortField{Field: "id"}), nil
+}
+
+// orderBy renders an ORDER BY list, flipped when reading a page backwards.
+func orderBy(sort []models.SortField, backwards bool) string {
+	terms := make([]string, len(sort))
+	for i, s := range sort {
+		direction := "ASC"
+		if s.Desc != backwards {
+			direction = "DESC"
+		}
+		terms[i] = userFields[s.Field].column + " " + direction
+	}
+	return strings.Join(terms, ", ")
+}
+
+// keyset renders the condition selecting rows that come after the cursor
+// values in the given order, or before them when reading backwards, as
+// (a > $1) OR (a = $1 AND b > $2) OR ...
+func keyset(sort []models.SortField, values []string, backwards bool, params []interface{}) (string, []interface{}) {
+	base := len(params)
+	for _, v := range values {
+		params = append(params, v)
+	}
+
+	alternatives := make([]string, len(sort))
+	for i, s := range sort {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = $%d", userFields[sort[j].Field].column, base+j+1))
+		}
+		comparison := ">"
+		if s.Desc != backwards {
+			comparison = "<"
+		}
+		terms = append(terms, fmt.Sprintf("%s %s $%d", userFields[s.Field].column, comparison, base+i+1))
+		alternatives[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", params
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"context"
+	"timeTracker/internal/models"
+	"timeTracker/internal/repository"
+)
+
+type AuditService struct {
+	repo repository.Repository
+}
+
+func NewAuditService(repo repository.Repository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+func (s *AuditService) AuditEvents(ctx context.Context, orgID int, filter models.AuditFilter) (_ []models.AuditEvent, err error) {
+	ctx, endSpan := startSpan(ctx, "AuditService.AuditEvents")
+	defer endSpan(&err)
+
+	return s.repo.AuditEvents(ctx, orgID, filter)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"timeTracker/internal/auth"
+	"timeTracker/internal/models"
+	"timeTracker/internal/repository"
+)
+
+type AuthService struct {
+	repo     repository.Repository
+	verifier *auth.JWTVerifier
+}
+
+func NewAuthService(repo repository.Repository, verifier *auth.JWTVerifier) *AuthService {
+	return &AuthService{
+		repo:     repo,
+		verifier: verifier,
+	}
+}
+
+// Authenticate resolves a bearer credential, either an API key or a JWT,
+// into the principal it was issued to.
+func (s *AuthService) Authenticate(ctx context.Context, token string) (_ auth.Principal, err error) {
+	ctx, endSpan := startSpan(ctx, "AuthService.Authenticate")
+	defer endSpan(&err)
+
+	if !auth.IsAPIKey(token) {
+		principal, err := s.verifier.Verify(token)
+		if err != nil {
+			return principal, err
+		}
+		return s.resolve(ctx, principal)
+	}
+	if err := auth.ParseAPIKey(token); err != nil {
+		return auth.Principal{}, err
+	}
+
+	key, err := s.repo.APIKeyByHash(ctx, auth.HashAPIKey(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return auth.Principal{}, fmt.Errorf("%w: unknown or revoked api key", auth.ErrUnauthenticated)
+		}
+		return auth.Principal{}, fmt.Errorf("error looking up api key: %w", err)
+	}
+
+	return s.resolve(ctx, auth.Principal{Subject: key.Subject, Method: auth.MethodAPIKey, APIKeyID: key.ID})
+}
+
+func (s *AuthService) resolve(ctx context.Context, p auth.Principal) (auth.Principal, error) {
+	id, err := strconv.Atoi(p.Subject)
+	if err != nil {
+		return p, fmt.Errorf("%w: subject %q is not a user id", auth.ErrUnauthenticated, p.Subject)
+	}
+
+	user, err := s.repo.UserIdentity(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return p, fmt.Errorf("%w: user %d does not exist", auth.ErrUnauthenticated, id)
+		}
+		return p, fmt.Errorf("error looking up principal: %w", err)
+	}
+
+	p.UserID = user.ID
+	p.OrganizationID = user.OrganizationID
+	p.Role = user.Role
+	p.Team = user.Team
+	p.Privileges = user.Privileges
+
+	return p, nil
+}
+
+func (s *AuthService) IssueAPIKey(ctx context.Context, orgID int, actor models.Actor, name,
+	subject string) (_ models.IssuedAPIKey, err error) {
+	ctx, endSpan := startSpan(ctx, "AuthService.IssueAPIKey")
+	defer endSpan(&err)
+
+	key, err := auth.GenerateAPIKey()
+	if err != nil {
+		return models.IssuedAPIKey{}, fmt.Errorf("error generating api key: %w", err)
+	}
+
+	apiKey, err := s.repo.AddAPIKey(ctx, orgID, actor, models.APIKey{
+		Name:    name,
+		Prefix:  key[:auth.APIKeyDisplayLength],
+		Subject: subject,
+	}, auth.HashAPIKey(key))
+	if err != nil {
+		return models.IssuedAPIKey{}, fmt.Errorf("error saving api key to database: %w", err)
+	}
+
+	return models.IssuedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+func (s *AuthService) APIKey(ctx context.Context, orgID, id int) (_ models.APIKey, err error) {
+	ctx, endSpan := startSpan(ctx, "AuthService.APIKey")
+	defer endSpan(&err)
+
+	return s.repo.APIKey(ctx, orgID, id)
+}
+
+func (s *AuthService) RevokeAPIKey(ctx context.Context, orgID int, actor models.Actor, id int) (err error) {
+	ctx, endSpan := startSpan(ctx, "AuthService.RevokeAPIKey")
+	defer endSpan(&err)
+
+	return s.repo.RevokeAPIKey(ctx, orgID, actor, id)
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"timeTracker/internal/auth"
+	"timeTracker/internal/models"
+	"timeTracker/internal/repository"
+)
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepo()
+	actor := models.Actor{RequestID: "test"}
+	user, err := repo.AddUser(ctx, 1, actor, models.User{PassportNumber: "1234 567890", Surname: "Ivanov",
+		Name: "Ivan", Address: "1 Main St", Role: models.RoleManager})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewAuthService(repo, nil)
+
+	issued, err := s.IssueAPIKey(ctx, 1, actor, "ci", strconv.Itoa(user.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, err := s.Authenticate(ctx, issued.Key)
+	if err != nil || principal.Method != auth.MethodAPIKey || principal.APIKeyID != issued.ID ||
+		principal.UserID != user.ID || principal.Role != models.RoleManager {
+		t.Fatalf("Authenticate = %+v, %v", principal, err)
+	}
+
+	for _, key := range []string{issued.Key + "A", issued.Key[:len(issued.Key)-1] + "!", auth.APIKeyPrefix} {
+		if _, err := s.Authenticate(ctx, key); !errors.Is(err, auth.ErrUnauthenticated) {
+			t.Errorf("Authenticate(%q) = %v, want ErrUnauthenticated", key, err)
+		}
+	}
+
+	if err := s.RevokeAPIKey(ctx, 1, actor, issued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(ctx, issued.Key); !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Errorf("revoked key authenticated: %v", err)
+	}
+}
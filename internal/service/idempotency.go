@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"time"
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/models"
+	"timeTracker/internal/repository"
+)
+
+// DefaultIdempotencyKeyTTL is how long responses are kept for replay when no
+// TTL is configured.
+const DefaultIdempotencyKeyTTL = 24 * time.Hour
+
+type IdempotencyService struct {
+	repo repository.Repository
+	ttl  time.Duration
+}
+
+func NewIdempotencyService(repo repository.Repository, ttl time.Duration) *IdempotencyService {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyKeyTTL
+	}
+	return &IdempotencyService{repo: repo, ttl: ttl}
+}
+
+// Begin reserves the key for a request with the given fingerprint. When the
+// key was already used for the same request and that request completed, the
+// stored record is returned with replay set, and the response is to be sent
+// again instead of repeating the request.
+func (s *IdempotencyService) Begin(ctx context.Context, orgID int, subject, key,
+	fingerprint string) (_ models.IdempotencyRecord, _ bool, err error) {
+	ctx, endSpan := startSpan(ctx, "IdempotencyService.Begin")
+	defer endSpan(&err)
+
+	record, reserved, err := s.repo.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{
+		OrganizationID: orgID,
+		Subject:        subject,
+		Key:            key,
+		Fingerprint:    fingerprint,
+		ExpiresAt:      time.Now().Add(s.ttl),
+	})
+	if err != nil || reserved {
+		return record, false, err
+	}
+
+	if record.Fingerprint != fingerprint {
+		return record, false, apperr.Validation("idempotency key was already used for a different request",
+			apperr.FieldError{
+				Field:   "Idempotency-Key",
+				Code:    "idempotency_key_reused",
+				Message: "must be unique for every request with a different method, path or body",
+			})
+	}
+	if record.Status == 0 {
+		return record, false, apperr.Conflict("idempotency_key_in_use",
+			"a request with this idempotency key is still being processed")
+	}
+
+	return record, true, nil
+}
+
+// Complete stores the response of a request begun with Begin.
+func (s *IdempotencyService) Complete(ctx context.Context, record models.IdempotencyRecord) (err error) {
+	ctx, endSpan := startSpan(ctx, "IdempotencyService.Complete")
+	defer endSpan(&err)
+
+	return s.repo.CompleteIdempotencyKey(ctx, record)
+}
+
+// Release forgets a request begun with Begin, so that a retry runs it again.
+func (s *IdempotencyService) Release(ctx context.Context, record models.IdempotencyRecord) (err error) {
+	ctx, endSpan := startSpan(ctx, "IdempotencyService.Release")
+	defer endSpan(&err)
+
+	return s.repo.ReleaseIdempotencyKey(ctx, record.OrganizationID, record.Subject, record.Key)
+}
+
+// PurgeExpired removes the records whose TTL has passed.
+func (s *IdempotencyService) PurgeExpired(ctx context.Context) (_ int, err error) {
+	ctx, endSpan := startSpan(ctx, "IdempotencyService.PurgeExpired")
+	defer endSpan(&err)
+
+	return s.repo.PurgeIdempotencyKeys(ctx, time.Now())
+}
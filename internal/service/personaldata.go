@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"timeTracker/internal/models"
+	"timeTracker/internal/repository"
+)
+
+// ExportPersonalData records an access request by the data subject and
+// returns everything stored about them.
+func (s *UserService) ExportPersonalData(ctx context.Context, orgID int, actor models.Actor,
+	userID int) (_ models.PersonalData, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.ExportPersonalData")
+	defer endSpan(&err)
+
+	data, err := s.repo.PersonalData(ctx, orgID, userID)
+	if err != nil {
+		return data, fmt.Errorf("error collecting personal data: %w", err)
+	}
+	withoutOthersSnapshots(&data)
+
+	request, err := s.repo.AddDataRequest(ctx, orgID, actor, userID, models.DataRequestExport)
+	if err != nil {
+		return models.PersonalData{}, fmt.Errorf("error recording export request: %w", err)
+	}
+	if request, err = s.repo.CompleteDataRequest(ctx, orgID, request.ID); err != nil {
+		return models.PersonalData{}, fmt.Errorf("error completing export request: %w", err)
+	}
+	data.DataRequests = append(data.DataRequests, request)
+
+	return data, nil
+}
+
+// withoutOthersSnapshots drops the before and after states of audit events
+// that are part of the export only because the subject performed them, since
+// those states describe someone else's user, API key or time entry.
+func withoutOthersSnapshots(data *models.PersonalData) {
+	own := make(map[int]bool, len(data.TimeEntries))
+	for _, entry := range data.TimeEntries {
+		own[entry.ID] = true
+	}
+
+	for i, event := range data.AuditEvents {
+		switch {
+		case event.EntityType == repository.EntityUser && event.EntityID == data.User.ID:
+		case event.EntityType == repository.EntityTimeEntry && own[event.EntityID]:
+		default:
+			data.AuditEvents[i].Before = nil
+			data.AuditEvents[i].After = nil
+		}
+	}
+}
+
+// ErasePersonalData records an erasure request and anonymises the user. The
+// request stays open if the erasure fails, so that it can be retried.
+// Soft-deleted users are left to the retention purge or have to be restored
+// first.
+func (s *UserService) ErasePersonalData(ctx context.Context, orgID int, actor models.Actor, userID int) (_ models.DataRequest, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.ErasePersonalData")
+	defer endSpan(&err)
+
+	if _, err := s.repo.User(ctx, orgID, userID); err != nil {
+		return models.DataRequest{}, err
+	}
+
+	request, err := s.repo.AddDataRequest(ctx, orgID, actor, userID, models.DataRequestErasure)
+	if err != nil {
+		return request, fmt.Errorf("error recording erasure request: %w", err)
+	}
+
+	completed, err := s.repo.EraseUser(ctx, orgID, actor, userID, request.ID)
+	if err != nil {
+		return request, fmt.Errorf("error erasing user: %w", err)
+	}
+
+	return completed, nil
+}
@@ -0,0 +1,18 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"timeTracker/internal/models"
+)
+
+// Search finds users and tasks within scope by a free-form text, such as
+// a possibly misspelt surname or a word from a task description. Users are
+// found by address too if addresses is set.
+func (s *UserService) Search(ctx context.Context, orgID int, text string, scope models.UserQuery, addresses bool,
+	limit int) (_ models.SearchResults, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.Search")
+	defer endSpan(&err)
+
+	return s.repo.Search(ctx, orgID, strings.TrimSpace(text), scope, addresses, limit)
+}
@@ -1,104 +1,214 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
+	"timeTracker/internal/apperr"
+	"timeTracker/internal/metrics"
 	"timeTracker/internal/models"
 	"timeTracker/internal/repository"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type UserService struct {
 	repo                repository.Repository
 	GetByPassportDomain string
+	// client calls the enrichment API, propagating the trace context.
+	client *http.Client
+	// enrichmentTimeout bounds every call to the enrichment API unless it
+	// is not positive.
+	enrichmentTimeout time.Duration
 }
 
-func NewUserService(repo repository.Repository, getByPassportDomain string) *UserService {
+func NewUserService(repo repository.Repository, getByPassportDomain string, enrichmentTimeout time.Duration) *UserService {
 	return &UserService{
 		repo:                repo,
 		GetByPassportDomain: getByPassportDomain,
+		client:              &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		enrichmentTimeout:   enrichmentTimeout,
+	}
+}
+
+// enrichmentContext derives the context of a call to the enrichment API.
+func (s *UserService) enrichmentContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.enrichmentTimeout <= 0 {
+		return ctx, func() {}
 	}
+	return context.WithTimeout(ctx, s.enrichmentTimeout)
 }
 
-func (s *UserService) AddUser(user models.User) (models.User, error) {
+func (s *UserService) AddUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (_ models.User, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.AddUser")
+	defer endSpan(&err)
+
 	passportParts := strings.Split(user.PassportNumber, " ")
 	if len(passportParts) != 2 {
-		return user, fmt.Errorf("invalid passport number format")
+		return user, apperr.Validation("invalid user", apperr.FieldError{
+			Field: "passportNumber", Code: "invalid_format", Message: "must be a series and a number separated by a space",
+		})
+	}
+
+	start := time.Now()
+	peopleInfo, err := s.lookupPassport(ctx, passportParts[0], passportParts[1])
+	metrics.ObserveEnrichment(time.Since(start), err)
+	if err != nil {
+		return user, err
 	}
 
-	resp, err := http.Get(fmt.Sprintf("%s?passportSerie=%s&passportNumber=%s", s.GetByPassportDomain, passportParts[0], passportParts[1]))
+	user.Surname = peopleInfo.Surname
+	user.Name = peopleInfo.Name
+	user.Patronymic = peopleInfo.Patronymic
+	user.Address = peopleInfo.Address
+	if user.Role == "" {
+		user.Role = models.RoleEmployee
+	}
+
+	enrichedUser, err := s.repo.AddUser(ctx, orgID, actor, user)
 	if err != nil {
-		return user, fmt.Errorf("error querying getByPassport API: %w", err)
+		return user, fmt.Errorf("error saving user to database: %w", err)
+	}
+
+	return enrichedUser, nil
+}
+
+// lookupPassport queries the enrichment API for the person holding a
+// passport.
+func (s *UserService) lookupPassport(ctx context.Context, series, number string) (models.People, error) {
+	ctx, cancel := s.enrichmentContext(ctx)
+	defer cancel()
+
+	var peopleInfo models.People
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s?passportSerie=%s&passportNumber=%s", s.GetByPassportDomain, series, number), nil)
+	if err != nil {
+		return peopleInfo, apperr.Upstream("enrichment_unavailable", "error building getByPassport API request", err)
+	}
+	resp, err := s.client.Do(req)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return peopleInfo, apperr.Upstream("enrichment_timeout", "getByPassport API did not answer in time", err)
+	}
+	if err != nil {
+		return peopleInfo, apperr.Upstream("enrichment_unavailable", "error querying getByPassport API", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return user, fmt.Errorf("getByPassport API returned not OK status: %d", resp.StatusCode)
+		return peopleInfo, apperr.Upstream("enrichment_failed",
+			fmt.Sprintf("getByPassport API returned not OK status: %d", resp.StatusCode), nil)
 	}
 
-	var peopleInfo models.People
 	if err := json.NewDecoder(resp.Body).Decode(&peopleInfo); err != nil {
-		return user, fmt.Errorf("error decoding external API response: %w", err)
+		return peopleInfo, apperr.Upstream("enrichment_invalid_response", "error decoding external API response", err)
 	}
 
-	user.Surname = peopleInfo.Surname
-	user.Name = peopleInfo.Name
-	user.Patronymic = peopleInfo.Patronymic
-	user.Address = peopleInfo.Address
+	return peopleInfo, nil
+}
+
+// CheckEnrichment reports whether the enrichment API answers. Any response
+// short of a server error counts, since the probe carries no passport.
+func (s *UserService) CheckEnrichment(ctx context.Context) error {
+	ctx, cancel := s.enrichmentContext(ctx)
+	defer cancel()
 
-	enrichedUser, err := s.repo.AddUser(user)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.GetByPassportDomain, nil)
 	if err != nil {
-		return user, fmt.Errorf("error saving user to database: %w", err)
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
 	}
+	resp.Body.Close()
 
-	return enrichedUser, nil
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("getByPassport API returned status %d", resp.StatusCode)
+	}
+	return nil
 }
 
-func (s *UserService) GetUsers(page, limit int, filters map[string]string) ([]models.User, error) {
-	return s.repo.GetUsers(page, limit, filters)
+func (s *UserService) GetUsers(ctx context.Context, orgID int, page models.Pagination,
+	query models.UserQuery) (_ models.UserPage, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.GetUsers")
+	defer endSpan(&err)
+
+	return s.repo.GetUsers(ctx, orgID, page, query)
 }
 
-func (s *UserService) GetUserWorkload(userID int, start, end time.Time) ([]models.Workload, error) {
-	return s.repo.GetUserWorkload(userID, start, end)
+func (s *UserService) GetUserWorkload(ctx context.Context, orgID, userID int, start, end time.Time) (_ []models.Workload, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.GetUserWorkload")
+	defer endSpan(&err)
+
+	return s.repo.GetUserWorkload(ctx, orgID, userID, start, end)
 }
 
-func (s *UserService) StartUserTask(userID, taskID int) (models.Task, error) {
-	return s.repo.StartUserTask(userID, taskID)
+func (s *UserService) StartUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID,
+	version int) (_ models.Task, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.StartUserTask")
+	defer endSpan(&err)
+
+	return s.repo.StartUserTask(ctx, orgID, actor, userID, taskID, version)
 }
 
-func (s *UserService) StopUserTask(userID, taskID int) (models.Task, error) {
-	return s.repo.StopUserTask(userID, taskID)
+func (s *UserService) StopUserTask(ctx context.Context, orgID int, actor models.Actor, userID, taskID,
+	version int) (_ models.Task, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.StopUserTask")
+	defer endSpan(&err)
+
+	return s.repo.StopUserTask(ctx, orgID, actor, userID, taskID, version)
 }
 
-func (s *UserService) DeleteUser(id int) error {
-	return s.repo.DeleteUser(id)
+func (s *UserService) User(ctx context.Context, orgID, id int) (_ models.User, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.User")
+	defer endSpan(&err)
+
+	return s.repo.User(ctx, orgID, id)
 }
 
-func (s *UserService) UpdateUser(user models.User) (models.User, error) {
-	existingUser, err := s.repo.User(user.ID)
-	if err != nil {
-		return user, fmt.Errorf("error getting existing user: %w", err)
-	}
+func (s *UserService) DeleteUser(ctx context.Context, orgID int, actor models.Actor, id, version int) (err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.DeleteUser")
+	defer endSpan(&err)
 
-	if user.PassportNumber != "" {
-		existingUser.PassportNumber = user.PassportNumber
-	}
-	if user.Surname != "" {
-		existingUser.Surname = user.Surname
-	}
-	if user.Name != "" {
-		existingUser.Name = user.Name
-	}
-	if user.Patronymic != "" {
-		existingUser.Patronymic = user.Patronymic
-	}
-	if user.Address != "" {
-		existingUser.Address = user.Address
-	}
+	return s.repo.DeleteUser(ctx, orgID, actor, id, version)
+}
+
+func (s *UserService) RestoreUser(ctx context.Context, orgID int, actor models.Actor, id, version int) (_ models.User, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.RestoreUser")
+	defer endSpan(&err)
+
+	return s.repo.RestoreUser(ctx, orgID, actor, id, version)
+}
+
+// TimerStats reports the running timers and the time entries started during
+// the last minute.
+func (s *UserService) TimerStats(ctx context.Context) (_ models.TimerStats, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.TimerStats")
+	defer endSpan(&err)
+
+	return s.repo.TimerStats(ctx, time.Now().Add(-time.Minute))
+}
+
+// PurgeDeletedUsers permanently removes users that have been soft-deleted for
+// longer than the retention period, together with their tasks and entries.
+func (s *UserService) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (_ int, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.PurgeDeletedUsers")
+	defer endSpan(&err)
+
+	return s.repo.PurgeDeletedUsers(ctx, time.Now().Add(-retention))
+}
+
+// ReplaceUser overwrites every editable field of a user, clearing those left
+// empty.
+func (s *UserService) ReplaceUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (_ models.User, err error) {
+	ctx, endSpan := startSpan(ctx, "UserService.ReplaceUser")
+	defer endSpan(&err)
 
-	updatedUser, err := s.repo.UpdateUser(existingUser)
+	updatedUser, err := s.repo.UpdateUser(ctx, orgID, actor, user)
 	if err != nil {
 		return user, fmt.Errorf("error updating user in database: %w", err)
 	}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("timeTracker/internal/service")
+
+// startSpan starts a span for a service call. The returned function ends it,
+// recording the error the call returned, and is meant to be deferred with a
+// pointer to the named error result.
+func startSpan(ctx context.Context, name string) (context.Context, func(*error)) {
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
+	return ctx, func(err *error) {
+		if *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"timeTracker/internal/models"
+	"timeTracker/internal/repository"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedRepo records the span that was current when a user was added.
+type tracedRepo struct {
+	repository.Repository
+	span trace.SpanContext
+}
+
+func (r *tracedRepo) AddUser(ctx context.Context, orgID int, actor models.Actor, user models.User) (models.User, error) {
+	r.span = trace.SpanContextFromContext(ctx)
+	return user, nil
+}
+
+func TestAddUserSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var enrichmentSpan trace.SpanContext
+	enrichment := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		enrichmentSpan = trace.SpanContextFromContext(ctx)
+		json.NewEncoder(w).Encode(models.People{Surname: "Ivanov", Name: "Ivan"})
+	}))
+	defer enrichment.Close()
+
+	repo := &tracedRepo{}
+	s := NewUserService(repo, enrichment.URL, 0)
+	ctx, request := otel.Tracer("test").Start(context.Background(), "request")
+	if _, err := s.AddUser(ctx, 1, models.Actor{}, models.User{PassportNumber: "1234 567890"}); err != nil {
+		t.Fatal(err)
+	}
+	request.End()
+
+	spans := map[trace.SpanID]sdktrace.ReadOnlySpan{}
+	var service sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		spans[span.SpanContext().SpanID()] = span
+		if span.Name() == "UserService.AddUser" {
+			service = span
+		}
+	}
+	if service == nil {
+		t.Fatal("no span for the service call")
+	}
+	if service.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("service span is a child of %s, want the request span", service.Parent().SpanID())
+	}
+	if repo.span.SpanID() != service.SpanContext().SpanID() {
+		t.Errorf("repository called in span %s, want the service span", repo.span.SpanID())
+	}
+
+	client, ok := spans[enrichmentSpan.SpanID()]
+	if !ok || enrichmentSpan.TraceID() != request.SpanContext().TraceID() {
+		t.Fatalf("enrichment request carried span %s of trace %s, want a recorded span of trace %s",
+			enrichmentSpan.SpanID(), enrichmentSpan.TraceID(), request.SpanContext().TraceID())
+	}
+	if client.Parent().SpanID() != service.SpanContext().SpanID() {
+		t.Errorf("enrichment span is a child of %s, want the service span", client.Parent().SpanID())
+	}
+}
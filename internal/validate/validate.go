@@ -0,0 +1,249 @@
+// Package validate checks request structs against rules declared in their
+// `validate` tags and reports every violation at once, e.g.
+//
+//	type request struct {
+//		PassportNumber string `json:"passportNumber" validate:"required,passport"`
+//		Team           string `json:"team" validate:"max=100"`
+//	}
+//
+// Rules are separated by commas and applied in order. Fields are named after
+// their json tag in the reported errors.
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"timeTracker/internal/apperr"
+	"unicode/utf8"
+)
+
+// Func reports whether v satisfies a rule with the given parameter.
+type Func func(v reflect.Value, param string) bool
+
+type rule struct {
+	code    string
+	message string
+	check   Func
+}
+
+var (
+	mu    sync.RWMutex
+	rules = map[string]rule{
+		"passport": {code: "invalid_format", message: "must look like 1234 567890", check: passport},
+	}
+	passportPattern = regexp.MustCompile(`^\d{4} \d{6}$`)
+	timeType        = reflect.TypeOf(time.Time{})
+)
+
+// Register adds a named rule. Slices are checked element by element.
+func Register(name, code, message string, check Func) {
+	mu.Lock()
+	defer mu.Unlock()
+	rules[name] = rule{code: code, message: message, check: check}
+}
+
+// Struct validates the tagged fields of the struct s points to. It returns
+// an apperr validation error listing extra followed by every violation, or
+// nil if there are none.
+func Struct(s interface{}, extra ...apperr.FieldError) error {
+	fields := append([]apperr.FieldError(nil), extra...)
+
+	v := reflect.Indirect(reflect.ValueOf(s))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name := fieldName(t.Field(i))
+		if reported(fields, name) {
+			continue
+		}
+		if violation, ok := check(v, v.Field(i), name, tag); !ok {
+			fields = append(fields, violation)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return apperr.Validation("invalid request", fields...)
+}
+
+// check applies the rules of one field and returns the first violation.
+func check(parent, v reflect.Value, name, tag string) (apperr.FieldError, bool) {
+	for _, r := range strings.Split(tag, ",") {
+		rule, param, _ := strings.Cut(r, "=")
+		switch rule {
+		case "omitempty":
+			if v.IsZero() {
+				return apperr.FieldError{}, true
+			}
+		case "required":
+			if v.IsZero() {
+				return apperr.FieldError{Field: name, Code: "required", Message: "is required"}, false
+			}
+		case "min", "max":
+			if violation, ok := bound(v, name, rule, param); !ok {
+				return violation, false
+			}
+		case "gtefield":
+			other := parent.FieldByName(param)
+			if v.Type() == timeType && other.Type() == timeType && !v.IsZero() && !other.IsZero() &&
+				v.Interface().(time.Time).Before(other.Interface().(time.Time)) {
+				otherField, _ := parent.Type().FieldByName(param)
+				return apperr.FieldError{Field: name, Code: "out_of_order",
+					Message: "must not be before " + fieldName(otherField)}, false
+			}
+		default:
+			mu.RLock()
+			custom, ok := rules[rule]
+			mu.RUnlock()
+			if !ok {
+				panic(fmt.Sprintf("validate: unknown rule %q", rule))
+			}
+			if !each(v, func(e reflect.Value) bool { return custom.check(e, param) }) {
+				return apperr.FieldError{Field: name, Code: custom.code, Message: custom.message}, false
+			}
+		}
+	}
+
+	return apperr.FieldError{}, true
+}
+
+// CheckTags reports the first tag of the struct s points to that Struct
+// would panic on or ignore: an unknown rule, a bound with an invalid
+// parameter or on a kind it does not apply to, or a gtefield that does not
+// compare two times. Tests call it so that a typo fails them instead of a
+// request.
+func CheckTags(s interface{}) error {
+	t := reflect.Indirect(reflect.ValueOf(s)).Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		for _, r := range strings.Split(tag, ",") {
+			rule, param, _ := strings.Cut(r, "=")
+			if err := checkRule(t, f, rule, param); err != nil {
+				return fmt.Errorf("validate: %s.%s: %w", t.Name(), f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkRule(t reflect.Type, f reflect.StructField, rule, param string) error {
+	switch rule {
+	case "omitempty", "required":
+		return nil
+	case "min", "max":
+		if _, err := strconv.Atoi(param); err != nil {
+			return fmt.Errorf("invalid %s parameter %q", rule, param)
+		}
+		if !bounded(f.Type.Kind()) {
+			return fmt.Errorf("%s does not apply to %s", rule, f.Type.Kind())
+		}
+		return nil
+	case "gtefield":
+		other, ok := t.FieldByName(param)
+		if !ok || f.Type != timeType || other.Type != timeType {
+			return fmt.Errorf("gtefield=%s does not compare two times", param)
+		}
+		return nil
+	}
+
+	mu.RLock()
+	_, ok := rules[rule]
+	mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown rule %q", rule)
+	}
+	return nil
+}
+
+// bounded reports whether min and max apply to values of kind k.
+func bounded(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Slice, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// bound checks min and max against string lengths, slice lengths and numbers.
+func bound(v reflect.Value, name, rule, param string) (apperr.FieldError, bool) {
+	limit, err := strconv.Atoi(param)
+	if err != nil {
+		panic(fmt.Sprintf("validate: invalid %s parameter %q", rule, param))
+	}
+
+	var n int
+	var unit string
+	switch v.Kind() {
+	case reflect.String:
+		n, unit = utf8.RuneCountInString(v.String()), " characters"
+	case reflect.Slice:
+		n, unit = v.Len(), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = int(v.Int())
+	default:
+		panic(fmt.Sprintf("validate: %s does not apply to %s", rule, v.Kind()))
+	}
+
+	if rule == "min" && n < limit {
+		code := "too_small"
+		if unit != "" {
+			code = "too_short"
+		}
+		return apperr.FieldError{Field: name, Code: code, Message: "must be at least " + param + unit}, false
+	}
+	if rule == "max" && n > limit {
+		code := "too_large"
+		if unit != "" {
+			code = "too_long"
+		}
+		return apperr.FieldError{Field: name, Code: code, Message: "must be at most " + param + unit}, false
+	}
+
+	return apperr.FieldError{}, true
+}
+
+func passport(v reflect.Value, _ string) bool {
+	return passportPattern.MatchString(v.String())
+}
+
+// each applies check to every element of a slice, or to v itself otherwise.
+func each(v reflect.Value, check func(reflect.Value) bool) bool {
+	if v.Kind() != reflect.Slice {
+		return check(v)
+	}
+	for i := 0; i < v.Len(); i++ {
+		if !check(v.Index(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func fieldName(f reflect.StructField) string {
+	if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return f.Name
+}
+
+func reported(fields []apperr.FieldError, name string) bool {
+	for _, f := range fields {
+		if f.Field == name {
+			return true
+		}
+	}
+	return false
+}
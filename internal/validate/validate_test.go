@@ -0,0 +1,148 @@
+package validate
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"timeTracker/internal/apperr"
+)
+
+type testRequest struct {
+	Passport string    `json:"passport" validate:"required,passport"`
+	Name     string    `json:"name" validate:"required,max=5"`
+	Team     string    `json:"team,omitempty" validate:"max=3"`
+	Page     int       `json:"page" validate:"omitempty,min=1"`
+	Limit    int       `json:"limit" validate:"omitempty,min=1,max=100"`
+	Tags     []string  `json:"tags" validate:"max=2,lower"`
+	Start    time.Time `json:"start" validate:"required"`
+	End      time.Time `json:"end" validate:"gtefield=Start"`
+	Untagged string
+}
+
+func init() {
+	Register("lower", "not_lowercase", "must be lowercase", func(v reflect.Value, _ string) bool {
+		return v.String() == strings.ToLower(v.String())
+	})
+}
+
+func validRequest() testRequest {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return testRequest{Passport: "1234 567890", Name: "Иван", Page: 1, Limit: 100, Tags: []string{"a", "b"},
+		Start: start, End: start}
+}
+
+// codes returns the field:code pairs of a validation error.
+func codes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var appErr *apperr.Error
+	if !errors.As(err, &appErr) || appErr.Kind != apperr.KindValidation {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+	var got []string
+	for _, f := range appErr.Fields {
+		got = append(got, f.Field+":"+f.Code)
+	}
+	return got
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*testRequest)
+		want   []string
+	}{
+		{"valid", func(r *testRequest) {}, nil},
+		{"required", func(r *testRequest) { r.Name = "" }, []string{"name:required"}},
+		{"required time", func(r *testRequest) { r.Start, r.End = time.Time{}, time.Time{} }, []string{"start:required"}},
+		{"max counts characters", func(r *testRequest) { r.Name = "Иванов" }, []string{"name:too_long"}},
+		{"max of a slice", func(r *testRequest) { r.Tags = []string{"a", "b", "c"} }, []string{"tags:too_long"}},
+		{"rule on every element", func(r *testRequest) { r.Tags = []string{"a", "B"} }, []string{"tags:not_lowercase"}},
+		{"passport without a space", func(r *testRequest) { r.Passport = "1234567890" }, []string{"passport:invalid_format"}},
+		{"passport too short", func(r *testRequest) { r.Passport = "123 567890" }, []string{"passport:invalid_format"}},
+		{"passport with letters", func(r *testRequest) { r.Passport = "12a4 567890" }, []string{"passport:invalid_format"}},
+		{"page below the minimum", func(r *testRequest) { r.Page = -1 }, []string{"page:too_small"}},
+		{"omitted page", func(r *testRequest) { r.Page = 0 }, nil},
+		{"limit above the maximum", func(r *testRequest) { r.Limit = 101 }, []string{"limit:too_large"}},
+		{"end before start", func(r *testRequest) { r.End = r.Start.Add(-time.Hour) }, []string{"end:out_of_order"}},
+		{"end after start", func(r *testRequest) { r.End = r.Start.Add(time.Hour) }, nil},
+		{"every violation", func(r *testRequest) {
+			r.Passport, r.Name, r.Team, r.Limit, r.End = "", "", "long", 0, r.Start.Add(-time.Hour)
+			r.Page = -1
+		}, []string{"passport:required", "name:required", "team:too_long", "page:too_small", "end:out_of_order"}},
+	}
+	for _, tt := range tests {
+		req := validRequest()
+		tt.change(&req)
+		if got := codes(t, Struct(&req)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStructExtraErrors(t *testing.T) {
+	req := validRequest()
+	req.Page, req.Name = -1, ""
+	err := Struct(&req, apperr.FieldError{Field: "page", Code: "invalid_number", Message: "must be a number"})
+	want := []string{"page:invalid_number", "name:required"}
+	if got := codes(t, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCheckTags(t *testing.T) {
+	if err := CheckTags(&testRequest{}); err != nil {
+		t.Errorf("valid tags: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		s    interface{}
+	}{
+		{"unknown rule", &struct {
+			Name string `validate:"requird"`
+		}{}},
+		{"invalid bound", &struct {
+			Name string `validate:"max=ten"`
+		}{}},
+		{"bound on a bool", &struct {
+			Flag bool `validate:"max=1"`
+		}{}},
+		{"gtefield on strings", &struct {
+			From string
+			To   string `validate:"gtefield=From"`
+		}{}},
+		{"gtefield on a missing field", &struct {
+			To time.Time `validate:"gtefield=From"`
+		}{}},
+	}
+	for _, tt := range tests {
+		if err := CheckTags(tt.s); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestStructPanicsOnInvalidTags(t *testing.T) {
+	for name, s := range map[string]interface{}{
+		"unknown rule": &struct {
+			Name string `validate:"requird"`
+		}{Name: "x"},
+		"bound on a bool": &struct {
+			Flag bool `validate:"max=1"`
+		}{},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", name)
+				}
+			}()
+			Struct(s)
+		}()
+	}
+}
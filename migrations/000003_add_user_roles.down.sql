@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_team_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS team, DROP COLUMN IF EXISTS role;
@@ -0,0 +1,6 @@
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'employee'
+        CHECK (role IN ('admin', 'manager', 'employee')),
+    ADD COLUMN team VARCHAR(100);
+
+CREATE INDEX users_team_idx ON users (team);
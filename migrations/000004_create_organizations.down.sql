@@ -0,0 +1,9 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE time_entries DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE tasks DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_organization_id_passport_number_key,
+    DROP CONSTRAINT IF EXISTS users_organization_id_id_key,
+    DROP COLUMN IF EXISTS organization_id,
+    ADD CONSTRAINT users_passport_number_key UNIQUE (passport_number);
+DROP TABLE IF EXISTS organizations;
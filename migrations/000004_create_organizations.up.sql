@@ -0,0 +1,40 @@
+CREATE TABLE organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO organizations (name) VALUES ('default');
+
+ALTER TABLE users ADD COLUMN organization_id INTEGER;
+UPDATE users SET organization_id = (SELECT id FROM organizations WHERE name = 'default');
+ALTER TABLE users
+    ALTER COLUMN organization_id SET NOT NULL,
+    ADD CONSTRAINT users_organization_id_fkey FOREIGN KEY (organization_id) REFERENCES organizations(id),
+    DROP CONSTRAINT users_passport_number_key,
+    ADD CONSTRAINT users_organization_id_passport_number_key UNIQUE (organization_id, passport_number),
+    ADD CONSTRAINT users_organization_id_id_key UNIQUE (organization_id, id);
+
+ALTER TABLE tasks ADD COLUMN organization_id INTEGER;
+UPDATE tasks t SET organization_id = u.organization_id FROM users u WHERE u.id = t.user_id;
+ALTER TABLE tasks
+    ALTER COLUMN organization_id SET NOT NULL,
+    ADD CONSTRAINT tasks_organization_id_user_id_fkey FOREIGN KEY (organization_id, user_id)
+        REFERENCES users(organization_id, id) ON DELETE CASCADE,
+    ADD CONSTRAINT tasks_organization_id_id_key UNIQUE (organization_id, id);
+
+ALTER TABLE time_entries ADD COLUMN organization_id INTEGER;
+UPDATE time_entries te SET organization_id = t.organization_id FROM tasks t WHERE t.id = te.task_id;
+ALTER TABLE time_entries
+    ALTER COLUMN organization_id SET NOT NULL,
+    ADD CONSTRAINT time_entries_organization_id_task_id_fkey FOREIGN KEY (organization_id, task_id)
+        REFERENCES tasks(organization_id, id) ON DELETE CASCADE;
+
+ALTER TABLE api_keys ADD COLUMN organization_id INTEGER;
+UPDATE api_keys SET organization_id = (SELECT id FROM organizations WHERE name = 'default');
+ALTER TABLE api_keys
+    ALTER COLUMN organization_id SET NOT NULL,
+    ADD CONSTRAINT api_keys_organization_id_fkey FOREIGN KEY (organization_id) REFERENCES organizations(id);
+
+CREATE INDEX tasks_organization_id_idx ON tasks (organization_id);
+CREATE INDEX time_entries_organization_id_idx ON time_entries (organization_id);
@@ -0,0 +1,9 @@
+-- Soft-deleted users are restored rather than lost. Rolling back fails on the
+-- unique constraint if a passport number has been reused since; such users
+-- have to be purged or changed by hand first.
+UPDATE users SET deleted_at = NULL WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS users_organization_id_passport_number_key;
+ALTER TABLE users
+    ADD CONSTRAINT users_organization_id_passport_number_key UNIQUE (organization_id, passport_number),
+    DROP COLUMN IF EXISTS deleted_at;
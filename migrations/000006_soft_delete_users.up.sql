@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE users DROP CONSTRAINT users_organization_id_passport_number_key;
+CREATE UNIQUE INDEX users_organization_id_passport_number_key
+    ON users (organization_id, passport_number) WHERE deleted_at IS NULL;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
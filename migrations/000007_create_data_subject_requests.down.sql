@@ -0,0 +1,12 @@
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+UPDATE users SET passport_number = 'E' || id WHERE passport_number IS NULL;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS erased_at,
+    ALTER COLUMN passport_number SET NOT NULL;
+
+DROP TABLE IF EXISTS data_subject_requests;
@@ -0,0 +1,35 @@
+CREATE TABLE data_subject_requests (
+    id SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER NOT NULL,
+    type VARCHAR(16) NOT NULL CHECK (type IN ('export', 'erasure')),
+    requested_by INTEGER,
+    request_id VARCHAR(100),
+    requested_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX data_subject_requests_user_idx ON data_subject_requests (organization_id, user_id);
+
+ALTER TABLE users
+    ALTER COLUMN passport_number DROP NOT NULL,
+    ADD COLUMN erased_at TIMESTAMP WITH TIME ZONE;
+
+-- Erasure has to redact personal data from snapshots already in the audit
+-- log, so the append-only rule lets a transaction that opted in with
+-- SET LOCAL audit_log.redaction = 'on' rewrite before/after and nothing else.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND current_setting('audit_log.redaction', true) = 'on'
+        AND (NEW.id, NEW.organization_id, NEW.actor_id, NEW.action, NEW.entity_type, NEW.entity_id,
+             NEW.request_id, NEW.created_at)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.organization_id, OLD.actor_id, OLD.action, OLD.entity_type, OLD.entity_id,
+             OLD.request_id, OLD.created_at)
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,6 @@
+-- The encryption keys live in the application, so rolling back cannot
+-- decrypt existing values and passport_number stays TEXT.
+DROP INDEX IF EXISTS users_organization_id_passport_number_index_key;
+ALTER TABLE users DROP COLUMN IF EXISTS passport_number_index;
+CREATE UNIQUE INDEX users_organization_id_passport_number_key
+    ON users (organization_id, passport_number) WHERE deleted_at IS NULL;
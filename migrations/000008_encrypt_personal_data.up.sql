@@ -0,0 +1,12 @@
+-- passport_number and address hold ciphertext from now on, written by the
+-- application. passport_number_index is an HMAC blind index of the passport
+-- number used for exact lookups and uniqueness. Existing rows are encrypted
+-- and indexed when the server applies this migration on startup, or by the
+-- encrypt command; until then readiness fails.
+ALTER TABLE users
+    ALTER COLUMN passport_number TYPE TEXT,
+    ADD COLUMN passport_number_index CHAR(64);
+
+DROP INDEX IF EXISTS users_organization_id_passport_number_key;
+CREATE UNIQUE INDEX users_organization_id_passport_number_index_key
+    ON users (organization_id, passport_number_index) WHERE deleted_at IS NULL;
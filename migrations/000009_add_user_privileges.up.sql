@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN privileges TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_organization_id_surname_idx;
+DROP INDEX IF EXISTS users_organization_id_updated_at_idx;
+DROP INDEX IF EXISTS users_organization_id_created_at_idx;
@@ -0,0 +1,5 @@
+-- Support the orders user listings are most often sorted and paged by. The
+-- trailing id matches the tie-breaker added to every order.
+CREATE INDEX users_organization_id_created_at_idx ON users (organization_id, created_at, id);
+CREATE INDEX users_organization_id_updated_at_idx ON users (organization_id, updated_at, id);
+CREATE INDEX users_organization_id_surname_idx ON users (organization_id, surname, id);
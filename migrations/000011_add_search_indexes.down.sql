@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS tasks_description_trgm_idx;
+DROP INDEX IF EXISTS tasks_description_fts_idx;
+DROP INDEX IF EXISTS users_full_name_trgm_idx;
+DROP INDEX IF EXISTS users_full_name_fts_idx;
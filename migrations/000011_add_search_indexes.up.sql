@@ -0,0 +1,12 @@
+-- Full-text and trigram indexes behind GET /search. The expressions must
+-- match the ones in the search queries exactly for the indexes to be used.
+-- Addresses are encrypted at rest and therefore cannot be indexed.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX users_full_name_fts_idx ON users
+    USING gin (to_tsvector('simple', surname || ' ' || name || ' ' || COALESCE(patronymic, '')));
+CREATE INDEX users_full_name_trgm_idx ON users
+    USING gin ((surname || ' ' || name || ' ' || COALESCE(patronymic, '')) gin_trgm_ops);
+
+CREATE INDEX tasks_description_fts_idx ON tasks USING gin (to_tsvector('simple', description));
+CREATE INDEX tasks_description_trgm_idx ON tasks USING gin (description gin_trgm_ops);
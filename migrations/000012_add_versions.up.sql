@@ -0,0 +1,5 @@
+-- Row versions for optimistic concurrency control. Every update increments
+-- the version, which the API exposes as the ETag of the resource.
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE tasks ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE time_entries ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,18 @@
+-- Responses to POST requests sent with an Idempotency-Key, replayed when a
+-- client retries. A row without a status is a request still in progress.
+-- Keys are scoped to the caller, so two clients may pick the same key.
+CREATE TABLE idempotency_keys (
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    subject VARCHAR(255) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    fingerprint CHAR(64) NOT NULL,
+    status INTEGER,
+    header JSONB,
+    -- The body may hold personal data and is encrypted like the users table.
+    body TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (organization_id, subject, key)
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
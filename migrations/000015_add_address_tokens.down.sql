@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_address_tokens_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS address_tokens;
@@ -0,0 +1,8 @@
+-- Addresses are encrypted at rest, so GET /search matches them by whole
+-- words through blind indexes: address_tokens holds a keyed hash of every
+-- distinct lowercase word. The tokens need the blind index key and are
+-- computed by the application, which fills them in for existing users after
+-- migrating; until then those users are not found by address.
+ALTER TABLE users ADD COLUMN address_tokens TEXT[];
+
+CREATE INDEX users_address_tokens_idx ON users USING gin (address_tokens);
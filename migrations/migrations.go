@@ -0,0 +1,43 @@
+// Package migrations holds the schema migrations of the Postgres database and,
+// in the sqlite directory, those of the SQLite database, embedded so that the
+// binary can migrate from any working directory.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"path/filepath"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+//go:embed *.sql sqlite/*.sql
+var files embed.FS
+
+// Source returns the Postgres migrations in dir or, if dir is empty, those
+// built into the binary.
+func Source(dir string) (source.Driver, error) {
+	if dir == "" {
+		return iofs.New(files, ".")
+	}
+	return open(dir)
+}
+
+// SQLiteSource returns the SQLite migrations in the sqlite subdirectory of
+// dir or, if dir is empty, those built into the binary.
+func SQLiteSource(dir string) (source.Driver, error) {
+	if dir == "" {
+		return iofs.New(files, "sqlite")
+	}
+	return open(filepath.Join(dir, "sqlite"))
+}
+
+func open(dir string) (source.Driver, error) {
+	path, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return (&file.File{}).Open("file://" + filepath.ToSlash(path))
+}
@@ -0,0 +1,8 @@
+DROP TABLE idempotency_keys;
+DROP TABLE data_subject_requests;
+DROP TABLE audit_log;
+DROP TABLE api_keys;
+DROP TABLE time_entries;
+DROP TABLE tasks;
+DROP TABLE users;
+DROP TABLE organizations;
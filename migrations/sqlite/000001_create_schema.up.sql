@@ -0,0 +1,151 @@
+-- The schema of the SQLite backend, equivalent to the Postgres migrations up
+-- to 000013 except where SQLite lacks a feature:
+--   * timestamps are TEXT in UTC, written by the application with a fixed
+--     number of fractional digits so that they compare and sort as text;
+--   * durations are INTEGER microseconds instead of INTERVAL;
+--   * privileges are a JSON array and audit snapshots JSON text;
+--   * there are no search indexes, search filters in the application.
+-- Foreign keys are only enforced with PRAGMA foreign_keys = ON, which the
+-- application sets on every connection.
+
+CREATE TABLE organizations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000+00:00', 'now'))
+);
+
+INSERT INTO organizations (name) VALUES ('default');
+
+-- AUTOINCREMENT keeps ids of purged users and their tasks from being reused.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    -- passport_number and address hold ciphertext; passport_number_index is
+    -- the blind index used for lookups and uniqueness.
+    passport_number TEXT,
+    passport_number_index TEXT,
+    surname TEXT NOT NULL,
+    name TEXT NOT NULL,
+    patronymic TEXT,
+    address TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'employee' CHECK (role IN ('admin', 'manager', 'employee')),
+    team TEXT,
+    privileges TEXT NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    deleted_at TIMESTAMP,
+    erased_at TIMESTAMP,
+    UNIQUE (organization_id, id)
+);
+
+CREATE UNIQUE INDEX users_organization_id_passport_number_index_key
+    ON users (organization_id, passport_number_index) WHERE deleted_at IS NULL;
+CREATE INDEX users_team_idx ON users (team);
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX users_organization_id_created_at_idx ON users (organization_id, created_at, id);
+CREATE INDEX users_organization_id_updated_at_idx ON users (organization_id, updated_at, id);
+CREATE INDEX users_organization_id_surname_idx ON users (organization_id, surname, id);
+
+CREATE TABLE tasks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    description TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000+00:00', 'now')),
+    version INTEGER NOT NULL DEFAULT 1,
+    UNIQUE (organization_id, id),
+    FOREIGN KEY (organization_id, user_id) REFERENCES users(organization_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX tasks_user_id_idx ON tasks (user_id);
+
+CREATE TABLE time_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL,
+    task_id INTEGER NOT NULL,
+    start_time TIMESTAMP NOT NULL,
+    end_time TIMESTAMP,
+    -- Microseconds between start_time and end_time.
+    duration INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000+00:00', 'now')),
+    version INTEGER NOT NULL DEFAULT 1,
+    FOREIGN KEY (organization_id, task_id) REFERENCES tasks(organization_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX time_entries_task_id_idx ON time_entries (task_id);
+
+CREATE TABLE api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT UNIQUE NOT NULL,
+    subject TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    actor_id INTEGER,
+    action TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    before TEXT,
+    after TEXT,
+    request_id TEXT,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_organization_id_created_at_idx ON audit_log (organization_id, created_at);
+CREATE INDEX audit_log_entity_idx ON audit_log (organization_id, entity_type, entity_id);
+CREATE INDEX audit_log_actor_id_idx ON audit_log (organization_id, actor_id);
+
+-- The audit log is append-only, except that erasure redacts personal data
+-- from the snapshots. SQLite has no session settings to limit even that to
+-- erasure, as the Postgres schema does, so any change to the snapshots is
+-- allowed.
+CREATE TRIGGER audit_log_no_delete
+    BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER audit_log_snapshots_only
+    BEFORE UPDATE ON audit_log
+    WHEN NEW.id IS NOT OLD.id OR NEW.organization_id IS NOT OLD.organization_id OR NEW.actor_id IS NOT OLD.actor_id
+        OR NEW.action IS NOT OLD.action OR NEW.entity_type IS NOT OLD.entity_type OR NEW.entity_id IS NOT OLD.entity_id
+        OR NEW.request_id IS NOT OLD.request_id OR NEW.created_at IS NOT OLD.created_at
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TABLE data_subject_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL CHECK (type IN ('export', 'erasure')),
+    requested_by INTEGER,
+    request_id TEXT,
+    requested_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX data_subject_requests_user_idx ON data_subject_requests (organization_id, user_id);
+
+CREATE TABLE idempotency_keys (
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    subject TEXT NOT NULL,
+    key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status INTEGER,
+    header TEXT,
+    body TEXT,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (organization_id, subject, key)
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);